@@ -0,0 +1,211 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	shell "github.com/kballard/go-shellquote"
+	"github.com/sirupsen/logrus"
+)
+
+// executable returns the location of this wrapper, which is installed as
+// `<helpers>/nodejs/bin/node`.
+var executable = os.Executable // for testing
+
+// nodeVersionPattern matches the versioned installation directories of node version
+// managers, like `~/.nvm/versions/node/v18.17.1` or `~/.asdf/installs/nodejs/18.17.1`.
+var nodeVersionPattern = regexp.MustCompile(`^v?(\d+\.\d+\.\d+)$`)
+
+// sessionSchemaVersion is the version of the session descriptor schema, as documented
+// in the top-level README.  It must be incremented on incompatible changes.
+const sessionSchemaVersion = 1
+
+// sessionDescriptor describes a debug session, and is written to
+// `<helpers>/sessions/<pid>.json` while the app is running.  The Python
+// launcher writes the same schema.
+type sessionDescriptor struct {
+	SchemaVersion       int      `json:"schemaVersion"`
+	Runtime             string   `json:"runtime"`
+	RuntimeVersion      string   `json:"runtimeVersion,omitempty"`
+	Protocol            string   `json:"protocol"`
+	Backend             string   `json:"backend"`
+	BackendVersion      string   `json:"backendVersion,omitempty"`
+	Address             string   `json:"address"`
+	Port                uint     `json:"port"`
+	Wait                bool     `json:"wait"`
+	PID                 int      `json:"pid"`
+	LauncherPID         int      `json:"launcherPid"`
+	WorkingDirectory    string   `json:"workingDirectory,omitempty"`
+	OriginalCommandLine []string `json:"originalCommandLine"`
+	CommandLine         []string `json:"commandLine"`
+}
+
+// helpersRoot returns the helpers root in which this wrapper is installed, or ""
+// if the wrapper is not installed as `<helpers>/nodejs/bin/node`.
+func helpersRoot() string {
+	exe, err := executable()
+	if err != nil {
+		return ""
+	}
+	bin := filepath.Dir(exe)
+	if filepath.Base(bin) != "bin" || filepath.Base(filepath.Dir(bin)) != "nodejs" {
+		return ""
+	}
+	return filepath.Dir(filepath.Dir(bin))
+}
+
+// parseInspectArg returns the address and port at which node's inspector listens
+// for an `--inspect[-brk|-wait][=[host:]port]` argument, and whether node waits
+// for a debugger to attach.
+func parseInspectArg(arg string) (string, uint, bool, error) {
+	host, port := "127.0.0.1", uint(9229)
+	option, value := arg, ""
+	if i := strings.Index(arg, "="); i >= 0 {
+		option, value = arg[:i], arg[i+1:]
+	}
+	var wait bool
+	switch option {
+	case "--inspect":
+	case "--inspect-brk", "--inspect-wait":
+		wait = true
+	default:
+		return "", 0, false, fmt.Errorf("unsupported inspect argument %q", arg)
+	}
+	if value == "" {
+		return host, port, wait, nil
+	}
+	if i := strings.LastIndex(value, ":"); i >= 0 {
+		host, value = strings.Trim(value[:i], "[]"), value[i+1:]
+	} else if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+		// only a host was provided
+		return value, port, wait, nil
+	}
+	p, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("invalid port in inspect argument %q", arg)
+	}
+	return host, uint(p), wait, nil
+}
+
+// activeInspectArg returns the inspect argument that node will act on, from the
+// command-line or otherwise from NODE_OPTIONS.
+func (nc *nodeContext) activeInspectArg() string {
+	if _, arg := stripInspectArg(nc.args); arg != "" {
+		return arg
+	}
+	if options, found := nc.env["NODE_OPTIONS"]; found {
+		if args, err := shell.Split(options); err == nil {
+			_, arg := stripInspectArg(args)
+			return arg
+		}
+	}
+	return ""
+}
+
+// newSessionDescriptor describes the debug session for the node process with the given ID.
+func (nc *nodeContext) newSessionDescriptor(pid int) (sessionDescriptor, error) {
+	address, port, wait, err := parseInspectArg(nc.activeInspectArg())
+	if err != nil {
+		return sessionDescriptor{}, err
+	}
+	sd := sessionDescriptor{
+		SchemaVersion:       sessionSchemaVersion,
+		Runtime:             "nodejs",
+		Protocol:            "cdp",
+		Backend:             "inspector",
+		Address:             address,
+		Port:                port,
+		Wait:                wait,
+		PID:                 pid,
+		LauncherPID:         os.Getpid(),
+		OriginalCommandLine: nc.originalArgs,
+		CommandLine:         append([]string{nc.program}, nc.args...),
+	}
+	// the inspector is built into node
+	sd.RuntimeVersion = nodeVersion(nc.program, nc.env)
+	sd.BackendVersion = sd.RuntimeVersion
+	if wd, err := os.Getwd(); err == nil {
+		sd.WorkingDirectory = wd
+	}
+	return sd, nil
+}
+
+// nodeVersion returns the version of node without running it, as recorded by the official
+// node images in NODE_VERSION or in the installation path of a version manager, or "" if
+// not known.
+func nodeVersion(program string, env map[string]string) string {
+	if v := env["NODE_VERSION"]; v != "" {
+		return strings.TrimPrefix(v, "v")
+	}
+	real, err := filepath.EvalSymlinks(program)
+	if err != nil {
+		real = program
+	}
+	for dir := filepath.Dir(real); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if m := nodeVersionPattern.FindStringSubmatch(filepath.Base(dir)); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// writeSessionDescriptor writes the session descriptor under the helpers root and
+// returns its location.
+func writeSessionDescriptor(root string, sd sessionDescriptor) (string, error) {
+	data, err := json.MarshalIndent(sd, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, "sessions")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("unable to create sessions directory: %w", err)
+	}
+	f := filepath.Join(dir, fmt.Sprintf("%d.json", sd.PID))
+	if err := writeFileAtomic(f, data); err != nil {
+		return "", fmt.Errorf("unable to write session descriptor: %w", err)
+	}
+	logrus.Debugf("wrote session descriptor %q", f)
+	return f, nil
+}
+
+// writeFileAtomic writes the data to the file by way of a temporary file in the same
+// directory, so that concurrent readers never see a partially-written file.
+func writeFileAtomic(f string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(f), "."+filepath.Base(f)+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
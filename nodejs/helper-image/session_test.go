@@ -0,0 +1,187 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestParseInspectArg(t *testing.T) {
+	tests := []struct {
+		arg       string
+		shouldErr bool
+		host      string
+		port      uint
+		wait      bool
+	}{
+		{arg: "--inspect", host: "127.0.0.1", port: 9229},
+		{arg: "--inspect=9230", host: "127.0.0.1", port: 9230},
+		{arg: "--inspect=0.0.0.0:9230", host: "0.0.0.0", port: 9230},
+		{arg: "--inspect=[::]:9230", host: "::", port: 9230},
+		{arg: "--inspect=localhost", host: "localhost", port: 9229},
+		{arg: "--inspect-brk", host: "127.0.0.1", port: 9229, wait: true},
+		{arg: "--inspect-brk=0.0.0.0:9229", host: "0.0.0.0", port: 9229, wait: true},
+		{arg: "--inspect-wait=9231", host: "127.0.0.1", port: 9231, wait: true},
+		{arg: "--inspect=host:port", shouldErr: true},
+		{arg: "--inspect-port=9230", shouldErr: true},
+		{arg: "", shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.arg, func(t *testing.T) {
+			host, port, wait, err := parseInspectArg(test.arg)
+			if test.shouldErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if host != test.host || port != test.port || wait != test.wait {
+				t.Errorf("expected %s:%d (wait=%v) but got %s:%d (wait=%v)", test.host, test.port, test.wait, host, port, wait)
+			}
+		})
+	}
+}
+
+func TestHelpersRoot(t *testing.T) {
+	tests := []struct {
+		executable string
+		expected   string
+	}{
+		{"/dbg/nodejs/bin/node", "/dbg"},
+		{"/opt/helpers/nodejs/bin/node", "/opt/helpers"},
+		{"/usr/local/bin/node", ""},
+		{"/tmp/go-build123/node.test", ""},
+	}
+	oldExecutable := executable
+	t.Cleanup(func() { executable = oldExecutable })
+	for _, test := range tests {
+		t.Run(test.executable, func(t *testing.T) {
+			exe := test.executable
+			executable = func() (string, error) { return exe, nil }
+			if result := helpersRoot(); result != test.expected {
+				t.Errorf("expected %q but got %q", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestNodeVersion(t *testing.T) {
+	tests := []struct {
+		description string
+		program     string
+		env         map[string]string
+		expected    string
+	}{
+		{"official image", "/usr/local/bin/node", map[string]string{"NODE_VERSION": "20.11.0"}, "20.11.0"},
+		{"nvm", "/root/.nvm/versions/node/v18.17.1/bin/node", nil, "18.17.1"},
+		{"asdf", "/root/.asdf/installs/nodejs/16.20.2/bin/node", nil, "16.20.2"},
+		{"unknown", "/usr/bin/node", nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if v := nodeVersion(test.program, test.env); v != test.expected {
+				t.Errorf("expected %q but got %q", test.expected, v)
+			}
+		})
+	}
+}
+
+func TestSessionDescriptor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("we only support nix")
+	}
+	root, err := ioutil.TempDir("", "dbg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	oldExecutable := executable
+	executable = func() (string, error) { return filepath.Join(root, "nodejs", "bin", "node"), nil }
+	t.Cleanup(func() { executable = oldExecutable })
+
+	// the fake node reports the session descriptor once written, which happens
+	// after the process has started
+	nodeDir := filepath.Join(root, "actual")
+	if err := os.Mkdir(nodeDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	script := `#!/bin/sh
+for i in 1 2 3 4 5 6 7 8 9 10; do
+  for f in "` + root + `"/sessions/*.json; do
+    [ -f "$f" ] && exec cat "$f"
+  done
+  sleep 0.2
+done
+exit 1
+`
+	if err := ioutil.WriteFile(filepath.Join(nodeDir, "node"), []byte(script), 0555); err != nil {
+		t.Fatal(err)
+	}
+	nodeBin := filepath.Join(nodeDir, "node")
+
+	nc := nodeContext{program: nodeBin, args: []string{"script.js"}, env: map[string]string{"NODE_DEBUG": "--inspect-brk=0.0.0.0:9230", "NODE_VERSION": "18.17.1"}}
+	// unwrap fails as there is no second node on the PATH, so exec directly as run() would
+	nc.originalArgs = append([]string{nc.program}, nc.args...)
+	nc.addNodeArg(nc.env["NODE_DEBUG"])
+	delete(nc.env, "NODE_DEBUG")
+	nc.describe = nc.activeInspectArg() != ""
+
+	var out bytes.Buffer
+	if err := nc.exec(nil, &out, &out); err != nil {
+		t.Fatalf("exec failed: %v\n%s", err, out.String())
+	}
+	var sd sessionDescriptor
+	if err := json.Unmarshal(out.Bytes(), &sd); err != nil {
+		t.Fatalf("invalid descriptor: %v\n%s", err, out.String())
+	}
+	wd, _ := os.Getwd()
+	expected := sessionDescriptor{
+		SchemaVersion:       sessionSchemaVersion,
+		Runtime:             "nodejs",
+		RuntimeVersion:      "18.17.1",
+		Protocol:            "cdp",
+		Backend:             "inspector",
+		BackendVersion:      "18.17.1",
+		Address:             "0.0.0.0",
+		Port:                9230,
+		Wait:                true,
+		PID:                 sd.PID,
+		LauncherPID:         os.Getpid(),
+		WorkingDirectory:    wd,
+		OriginalCommandLine: []string{nodeBin, "script.js"},
+		CommandLine:         []string{nodeBin, "--inspect-brk=0.0.0.0:9230", "script.js"},
+	}
+	if !reflect.DeepEqual(expected, sd) {
+		t.Errorf("expected %+v but got %+v", expected, sd)
+	}
+	if sd.PID == 0 || sd.PID == os.Getpid() {
+		t.Errorf("expected the node process ID but got %d", sd.PID)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(root, "sessions", "*")); len(matches) > 0 {
+		t.Errorf("session descriptors not removed: %v", matches)
+	}
+}
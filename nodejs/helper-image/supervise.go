@@ -0,0 +1,129 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+)
+
+// execve replaces this process
+var execve = syscall.Exec // for testing
+
+// forwardedSignals are relayed to node's process group.
+var forwardedSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// reraisedSignals are the signals that the Go runtime handles by dying from the
+// signal, and so can be used to mirror node's death by the signal.
+var reraisedSignals = map[syscall.Signal]bool{syscall.SIGTERM: true, syscall.SIGINT: true, syscall.SIGHUP: true, syscall.SIGKILL: true}
+
+// processGroupAttr returns the attributes to start node in its own process group so
+// that signals can be forwarded to node and its children.  If node reads from this
+// process's stdin and it is a terminal, node's group is made the foreground group
+// until node exits, when restoreForeground takes the terminal back.
+func processGroupAttr(in io.Reader) *syscall.SysProcAttr {
+	if in == os.Stdin && isTerminal(os.Stdin) {
+		return &syscall.SysProcAttr{Setpgid: true, Foreground: true, Ctty: 0}
+	}
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// restoreForeground makes this wrapper's process group the terminal's foreground
+// group again so that whatever started the wrapper regains the terminal.  Changing
+// the foreground group from a background group raises SIGTTOU, which is ignored.
+func restoreForeground() {
+	signal.Ignore(syscall.SIGTTOU)
+	pgrp := int32(syscall.Getpgrp())
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&pgrp)))
+	if errno != 0 {
+		logrus.Debug("unable to restore the terminal's foreground process group: ", errno)
+	}
+}
+
+// isTerminal returns true if the file is a terminal.
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
+
+// execInPlace replaces this wrapper with node.  It returns only if node could not
+// be executed.
+func (nc *nodeContext) execInPlace() error {
+	logrus.Debugf("exec in place: %s %v", nc.program, nc.args)
+	return execve(nc.program, append([]string{nc.program}, nc.args...), envFromMap(nc.env))
+}
+
+// waitForwardingSignals waits for the started command to exit while forwarding signals
+// to its process group.
+func waitForwardingSignals(cmd *exec.Cmd) error {
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	for {
+		select {
+		case s := <-signals:
+			sig := s.(syscall.Signal)
+			logrus.Debugf("forwarding %s to process group %d", sig, cmd.Process.Pid)
+			if err := syscall.Kill(-cmd.Process.Pid, sig); err != nil && err != syscall.ESRCH {
+				logrus.Debugf("unable to forward %s: %v", sig, err)
+			}
+		case err := <-done:
+			return err
+		}
+	}
+}
+
+// exitStatus returns the wait status of node from the error returned by run(),
+// or false if the error did not come from node exiting.
+func exitStatus(err error) (syscall.WaitStatus, bool) {
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		ws, ok := ee.Sys().(syscall.WaitStatus)
+		return ws, ok
+	}
+	return 0, false
+}
+
+// exitCode returns the conventional shell exit code for a wait status: the exit code
+// of the process, or 128 plus the signal number if killed by a signal.
+func exitCode(ws syscall.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// exit exits with the same status as node.  If node was killed by a signal then
+// the wrapper kills itself with the same signal where possible.
+func exit(ws syscall.WaitStatus) {
+	// init is immune to signals without a handler
+	if ws.Signaled() && reraisedSignals[ws.Signal()] && os.Getpid() != 1 {
+		signal.Reset(ws.Signal())
+		syscall.Kill(os.Getpid(), ws.Signal())
+	}
+	os.Exit(exitCode(ws))
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestExitStatus(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("we only support nix")
+	}
+	tests := []struct {
+		description string
+		err         error
+		ok          bool
+		code        int
+	}{
+		{description: "exit 3", err: exec.Command("sh", "-c", "exit 3").Run(), ok: true, code: 3},
+		{description: "SIGTERM", err: exec.Command("sh", "-c", "kill -TERM $$").Run(), ok: true, code: 143},
+		{description: "SIGKILL", err: exec.Command("sh", "-c", "kill -KILL $$").Run(), ok: true, code: 137},
+		{description: "not an exit", err: errors.New("could not unwrap"), ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			ws, ok := exitStatus(test.err)
+			if ok != test.ok {
+				t.Fatalf("expected ok=%v but got %v", test.ok, ok)
+			}
+			if ok && exitCode(ws) != test.code {
+				t.Errorf("expected exit code %d but got %d", test.code, exitCode(ws))
+			}
+		})
+	}
+}
+
+func TestWaitForwardingSignals(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("we only support nix")
+	}
+	// catch the signals sent to this process should they arrive before forwarding begins
+	caught := make(chan os.Signal, 16)
+	signal.Notify(caught, syscall.SIGUSR1)
+	t.Cleanup(func() { signal.Stop(caught) })
+
+	// the shell's child is in the same process group and receives the signal too
+	cmd := exec.Command("sh", "-c", `trap : USR1; sh -c 'trap "exit 0" USR1; echo ready; while :; do sleep 0.1; done'; exit 7`)
+	cmd.SysProcAttr = processGroupAttr(nil)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stdout.Read(make([]byte, 6)); err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() { result <- waitForwardingSignals(cmd) }()
+	for {
+		syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+		select {
+		case err := <-result:
+			if ws, ok := exitStatus(err); !ok || exitCode(ws) != 7 {
+				t.Errorf("expected exit 7 but got %v", err)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func TestNodeContext_ExecInPlace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("we only support nix")
+	}
+	var argv0 string
+	var argv, envv []string
+	oldExecve := execve
+	execve = func(p string, a []string, e []string) error {
+		argv0, argv, envv = p, a, e
+		return errors.New("exec'd")
+	}
+	t.Cleanup(func() { execve = oldExecve })
+
+	// execve fails and so the command is run as a child instead
+	var out bytes.Buffer
+	nc := nodeContext{program: "/bin/echo", args: []string{"script.js"}, env: map[string]string{"A": "B"}, inPlace: true}
+	if err := nc.exec(nil, &out, &out); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if argv0 != "/bin/echo" || !reflect.DeepEqual(argv, []string{"/bin/echo", "script.js"}) || !reflect.DeepEqual(envv, []string{"A=B"}) {
+		t.Errorf("unexpected execve(%q, %v, %v)", argv0, argv, envv)
+	}
+	if out.String() != "script.js\n" {
+		t.Errorf("expected fallback to run the command but got %q", out.String())
+	}
+
+	// not in place
+	argv0 = ""
+	nc = nodeContext{program: "/bin/echo", args: []string{"script.js"}}
+	if err := nc.exec(nil, &out, &out); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if argv0 != "" {
+		t.Errorf("unexpected execve(%q, %v, %v)", argv0, argv, envv)
+	}
+}
@@ -27,6 +27,16 @@ limitations under the License.
 // The WRAPPER_ALLOWED environment variable allows identifying node_modules scripts
 // that should be treated as application scripts, meaning that they load and execute
 // the user's scripts directly. 
+//
+// When the wrapper is installed as `<helpers>/nodejs/bin/node` and launches an
+// application script with an `--inspect`-like argument, the debug session is
+// described in `<helpers>/sessions/<pid>.json` until node exits.  The schema is
+// documented in the top-level README.
+//
+// The wrapper otherwise replaces itself with the real node.  When it must remain
+// to manage the session descriptor, the wrapper forwards SIGTERM, SIGINT, SIGHUP,
+// SIGQUIT, SIGUSR1, and SIGUSR2 to node's process group, and exits with node's
+// exit code or dies from the same signal as node.
 package main
 
 import (
@@ -50,6 +60,14 @@ type nodeContext struct {
 	program string
 	args    []string
 	env     map[string]string
+
+	// originalArgs is the command-line as invoked
+	originalArgs []string
+	// describe is true if a debug session descriptor should be written while node runs
+	describe bool
+	// inPlace is true if node may replace this wrapper when nothing remains to be done
+	// while node runs
+	inPlace bool
 }
 
 func main() {
@@ -60,8 +78,11 @@ func main() {
 
 	// suppress npm warnings when node on PATH isn't the node used for npm
 	env["npm_config_scripts_prepend_node_path"] = "false"
-	nc := nodeContext{program: os.Args[0], args: os.Args[1:], env: env}
+	nc := nodeContext{program: os.Args[0], args: os.Args[1:], env: env, inPlace: true}
 	if err := run(&nc, os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if ws, ok := exitStatus(err); ok {
+			exit(ws)
+		}
 		logrus.Fatal(err)
 	}
 }
@@ -83,6 +104,7 @@ func logrusLevel(env map[string]string) logrus.Level {
 }
 
 func run(nc *nodeContext, stdin io.Reader, stdout, stderr io.Writer) error {
+	nc.originalArgs = append([]string{nc.program}, nc.args...)
 	if err := nc.unwrap(); err != nil {
 		return fmt.Errorf("could not unwrap: %w", err)
 	}
@@ -122,6 +144,7 @@ func run(nc *nodeContext, stdin io.Reader, stdout, stderr io.Writer) error {
 			nc.addNodeArg(nodeDebugOption)
 			delete(nc.env, "NODE_DEBUG")
 		}
+		nc.describe = nc.activeInspectArg() != ""
 		return nc.exec(stdin, stdout, stderr)
 	}
 
@@ -237,15 +260,50 @@ func (nc *nodeContext) addNodeArg(nodeArg string) {
 	nc.args = append(nc.args, nodeArg)
 }
 
-// exec runs the command, and returns an error should one occur.
+// exec runs the command, and returns an error should one occur.  The debug session
+// is described under the helpers root while the command runs if nc.describe is set.
+// Signals are forwarded to the command's process group.  If nc.inPlace is set and
+// there is no debug session to describe, this process is instead replaced by node.
 func (nc *nodeContext) exec(in io.Reader, out, err io.Writer) error {
+	root := ""
+	if nc.describe {
+		if root = helpersRoot(); root == "" {
+			logrus.Debug("not installed in a helpers root: no session descriptor written")
+		}
+	}
+	if nc.inPlace && root == "" {
+		err := nc.execInPlace()
+		logrus.Debug("unable to exec in place: ", err)
+	}
+
 	logrus.Debugf("exec: %s %v (env: %v)", nc.program, nc.args, nc.env)
 	cmd := exec.CommandContext(context.Background(), nc.program, nc.args...)
 	cmd.Env = envFromMap(nc.env)
 	cmd.Stdin = in
 	cmd.Stdout = out
 	cmd.Stderr = err
-	return cmd.Run()
+	cmd.SysProcAttr = processGroupAttr(in)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	var session string
+	if root != "" {
+		if sd, err := nc.newSessionDescriptor(cmd.Process.Pid); err != nil {
+			logrus.Warn("unable to describe debug session: ", err)
+		} else if session, err = writeSessionDescriptor(root, sd); err != nil {
+			logrus.Warn(err)
+		}
+	}
+	waitErr := waitForwardingSignals(cmd)
+	if cmd.SysProcAttr.Foreground {
+		restoreForeground()
+	}
+	if session != "" {
+		if err := os.Remove(session); err != nil {
+			logrus.Debug("unable to remove session descriptor: ", err)
+		}
+	}
+	return waitErr
 }
 
 // findScript returns the path to the node script that will be executed.
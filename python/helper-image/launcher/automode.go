@@ -0,0 +1,141 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ptvsdMaxVersion is the last python version supported by ptvsd (4.3.2).
+var ptvsdMaxVersion = pythonVersion{major: 3, minor: 8}
+
+// backendModules are the files that indicate a debugging backend is installed in a
+// site-packages directory.  pydevd-pycharm also installs `pydevd.py`.
+var backendModules = map[string]string{
+	ModeDebugpy:       "debugpy/__init__.py",
+	ModePtvsd:         "ptvsd/__init__.py",
+	ModePydevd:        "pydevd.py",
+	ModePydevdPycharm: "pydevd_pycharm.py",
+}
+
+// modeCandidates returns the debug modes to consider, in order of preference, for an IDE
+// hint as set with WRAPPER_IDE.  VS Code speaks the debug-adapter protocol (debugpy and ptvsd)
+// whereas PyCharm and IntelliJ use the pydevd protocol.
+func modeCandidates(ide string) []string {
+	switch strings.ToLower(ide) {
+	case "":
+		return []string{ModeDebugpy, ModePtvsd, ModePydevd, ModePydevdPycharm}
+	case "vscode", "code", "dap":
+		return []string{ModeDebugpy, ModePtvsd}
+	case "pycharm", "intellij", "idea":
+		return []string{ModePydevdPycharm, ModePydevd}
+	default:
+		logrus.Warnf("ignoring unknown IDE hint %q: expecting one of vscode, pycharm", ide)
+		return modeCandidates("")
+	}
+}
+
+// selectDebugMode picks the best debug mode for the interpreter for `--mode auto`, and
+// returns the mode along with the reason for choosing it.  Modes are considered in order
+// of preference for the IDE hint, skipping those that cannot work with the interpreter,
+// and choosing the first whose backend is bundled under the helpers root or is already
+// installed with the app.
+func (pc *pythonContext) selectDebugMode() (string, string, error) {
+	var rejected []string
+	for _, mode := range modeCandidates(pc.env["WRAPPER_IDE"]) {
+		if err := checkModeSupported(pc.implementation, mode); err != nil {
+			rejected = append(rejected, err.Error())
+			continue
+		}
+		if !pc.portRange.isEmpty() {
+			if err := checkPortRangeSupported(mode); err != nil {
+				rejected = append(rejected, err.Error())
+				continue
+			}
+		}
+		if mode == ModePtvsd && pc.version.compare(ptvsdMaxVersion) > 0 {
+			rejected = append(rejected, fmt.Sprintf("%s does not support Python %s", mode, pc.version))
+			continue
+		}
+		if pc.env["WRAPPER_SKIP_ENV"] != "" {
+			// the user has indicated that the backend is installed with the app
+			if where := pc.findInstalledBackend(mode); where != "" {
+				return mode, fmt.Sprintf("installed with the app at %q", where), nil
+			}
+			rejected = append(rejected, fmt.Sprintf("%s is not installed with the app", mode))
+			continue
+		}
+		// debugpy and ptvsd share a library directory so look for the backend itself
+		if p := pc.libraryPath(mode); pathExists(filepath.Join(p, backendModules[mode])) {
+			return mode, fmt.Sprintf("bundled for %s %s at %q", pc.implementation, pc.version, p), nil
+		}
+		if where := pc.findInstalledBackend(mode); where != "" {
+			return mode, fmt.Sprintf("installed with the app at %q", where), nil
+		}
+		rejected = append(rejected, fmt.Sprintf("%s is not available for %s %s", mode, pc.implementation, pc.version))
+	}
+	return "", "", fmt.Errorf("no debugging backend available: %s", strings.Join(rejected, "; "))
+}
+
+// findInstalledBackend returns the directory where the app has the backend for the given
+// mode installed, or "" if not found.  The PYTHONPATH and the interpreter's site-packages
+// directories are examined; the helpers root is ignored.
+func (pc *pythonContext) findInstalledBackend(mode string) string {
+	module := backendModules[mode]
+	if module == "" {
+		return ""
+	}
+	for _, dir := range pc.sitePackagesDirs() {
+		if strings.HasPrefix(dir, dbgRoot+"/") {
+			continue
+		}
+		if pathExists(filepath.Join(dir, module)) {
+			return dir
+		}
+	}
+	return ""
+}
+
+// sitePackagesDirs returns the directories likely to be searched by the interpreter for
+// installed packages: the PYTHONPATH, the site-packages of any virtual environment, and
+// the site-packages and dist-packages of the interpreter's installation.
+func (pc *pythonContext) sitePackagesDirs() []string {
+	var dirs []string
+	if pp := pc.env["PYTHONPATH"]; pp != "" {
+		dirs = append(dirs, filepath.SplitList(pp)...)
+	}
+	p, err := lookPath(pc.args[0], pc.env)
+	if err != nil {
+		return dirs
+	}
+	prefixes := []string{filepath.Dir(filepath.Dir(p))}
+	if real, err := filepath.EvalSymlinks(p); err == nil && filepath.Dir(filepath.Dir(real)) != prefixes[0] {
+		prefixes = append(prefixes, filepath.Dir(filepath.Dir(real)))
+	}
+	libDir := implementationLibDir(pc.implementation, pc.version)
+	for _, prefix := range prefixes {
+		dirs = append(dirs,
+			filepath.Join(prefix, "lib", libDir, "site-packages"),
+			filepath.Join(prefix, "local", "lib", libDir, "dist-packages"),
+			filepath.Join(prefix, "lib", fmt.Sprintf("python%d", pc.version.major), "dist-packages"))
+	}
+	return dirs
+}
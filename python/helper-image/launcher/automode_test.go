@@ -0,0 +1,118 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestModeCandidates(t *testing.T) {
+	tests := []struct {
+		ide      string
+		expected []string
+	}{
+		{"", []string{"debugpy", "ptvsd", "pydevd", "pydevd-pycharm"}},
+		{"vscode", []string{"debugpy", "ptvsd"}},
+		{"VSCode", []string{"debugpy", "ptvsd"}},
+		{"pycharm", []string{"pydevd-pycharm", "pydevd"}},
+		{"intellij", []string{"pydevd-pycharm", "pydevd"}},
+		{"emacs", []string{"debugpy", "ptvsd", "pydevd", "pydevd-pycharm"}},
+	}
+	for _, test := range tests {
+		t.Run(test.ide, func(t *testing.T) {
+			if diff := cmp.Diff(test.expected, modeCandidates(test.ide)); diff != "" {
+				t.Errorf("candidates differ (-got, +want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestSelectDebugMode(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+	writeFile(t, dbgRoot, "python/lib/python3.7/site-packages/debugpy/__init__.py", "")
+	writeFile(t, dbgRoot, "python/pydevd/python3.7/lib/python3.7/site-packages/pydevd.py", "")
+	writeFile(t, dbgRoot, "python/lib/python3.9/site-packages/ptvsd/__init__.py", "")
+	writeFile(t, dbgRoot, "python/pydevd/python3.9/lib/python3.9/site-packages/pydevd.py", "")
+	writeFile(t, dbgRoot, "python/lib/pypy3.10/site-packages/ptvsd/__init__.py", "")
+	writeFile(t, dbgRoot, "python/pydevd/pypy3.10/lib/pypy3.10/site-packages/pydevd.py", "")
+
+	// an app image with debugpy installed in a virtual environment
+	venv := t.TempDir()
+	venvPython := writeFile(t, venv, "bin/python", "")
+	writeFile(t, venv, "lib/python3.11/site-packages/debugpy/__init__.py", "")
+	// and another with pydevd-pycharm on the PYTHONPATH
+	appLib := t.TempDir()
+	writeFile(t, appLib, "pydevd_pycharm.py", "")
+
+	py37 := pythonVersion{major: 3, minor: 7, patch: 4}
+	py39 := pythonVersion{major: 3, minor: 9, patch: 1}
+	py311 := pythonVersion{major: 3, minor: 11, patch: 2}
+	pypy310 := pythonVersion{major: 3, minor: 10, patch: 13}
+	tests := []struct {
+		description    string
+		args           []string
+		env            env
+		version        pythonVersion
+		implementation string
+		shouldErr      bool
+		expected       string
+		reason         string
+	}{
+		{description: "bundled debugpy", version: py37, implementation: "cpython", expected: "debugpy", reason: "bundled for cpython 3.7.4"},
+		{description: "vscode", env: env{"WRAPPER_IDE": "vscode"}, version: py37, implementation: "cpython", expected: "debugpy"},
+		{description: "pycharm falls back to pydevd", env: env{"WRAPPER_IDE": "pycharm"}, version: py37, implementation: "cpython", expected: "pydevd"},
+		{description: "ptvsd does not support 3.9", version: py39, implementation: "cpython", expected: "pydevd"},
+		{description: "vscode with nothing usable", env: env{"WRAPPER_IDE": "vscode"}, version: py39, implementation: "cpython", shouldErr: true},
+		{description: "pypy does not support ptvsd", version: pypy310, implementation: "pypy", expected: "pydevd"},
+		{description: "graalpy only supports debugpy", version: pypy310, implementation: "graalpy", shouldErr: true},
+		{description: "installed in venv", args: []string{venvPython}, version: py311, implementation: "cpython", expected: "debugpy", reason: "installed with the app"},
+		{description: "installed on PYTHONPATH", env: env{"PYTHONPATH": appLib, "WRAPPER_IDE": "pycharm"}, version: py311, implementation: "cpython", expected: "pydevd-pycharm"},
+		{description: "skip env ignores bundled", env: env{"WRAPPER_SKIP_ENV": "1"}, version: py37, implementation: "cpython", shouldErr: true},
+		{description: "skip env with installed", args: []string{venvPython}, env: env{"WRAPPER_SKIP_ENV": "1"}, version: py311, implementation: "cpython", expected: "debugpy", reason: "installed with the app"},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			args := test.args
+			if args == nil {
+				args = []string{filepath.Join(t.TempDir(), "python")}
+			}
+			pc := pythonContext{debugMode: "auto", args: args, env: test.env, version: test.version, implementation: test.implementation}
+			mode, reason, err := pc.selectDebugMode()
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %q", mode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if mode != test.expected {
+				t.Errorf("expected %q but got %q (%s)", test.expected, mode, reason)
+			}
+			if test.reason != "" && !strings.HasPrefix(reason, test.reason) {
+				t.Errorf("expected reason %q but got %q", test.reason, reason)
+			}
+		})
+	}
+}
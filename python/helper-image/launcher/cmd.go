@@ -31,6 +31,8 @@ var newConsoleCommand = createConsoleCommand
 // commander is a subset of exec.Cmd
 type commander interface {
 	Run() error
+	Start() error
+	Wait() error
 	Output() ([]byte, error)
 	CombinedOutput() ([]byte, error)
 }
@@ -38,6 +40,14 @@ type commander interface {
 // ensures Cmd satisfies the commander interface
 var _ commander = (*exec.Cmd)(nil)
 
+// commandPid returns the process ID of a started command, or 0 if not known.
+func commandPid(cmd commander) int {
+	if c, ok := cmd.(*exec.Cmd); ok && c.Process != nil {
+		return c.Process.Pid
+	}
+	return 0
+}
+
 // createCommand creates a normal exec.Cmd object
 func createCommand(ctx context.Context, cmdline []string, env env) commander {
 	logrus.Debugf("command: %v (env: %s)", cmdline, env)
@@ -47,6 +57,7 @@ func createCommand(ctx context.Context, cmdline []string, env env) commander {
 }
 
 // createConsoleCommand creates an exec.Cmd object that connects to os.Stdin, os.Stdout, os.Stderr
+// and that runs in its own process group
 func createConsoleCommand(ctx context.Context, cmdline []string, env env) commander {
 	logrus.Debugf("command(stdin/out/err): %v (env: %s)", cmdline, env)
 	cmd := exec.CommandContext(ctx, cmdline[0], cmdline[1:]...)
@@ -54,5 +65,6 @@ func createConsoleCommand(ctx context.Context, cmdline []string, env env) comman
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Env = env.AsPairs()
+	cmd.SysProcAttr = processGroupAttr()
 	return cmd
 }
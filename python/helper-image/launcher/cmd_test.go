@@ -61,6 +61,21 @@ func (f *fakeCmd) Run() error {
 	return &exec.ExitError{}
 }
 
+func (f *fakeCmd) Start() error {
+	_t.Helper()
+	if f.mode != "Run" {
+		_t.Errorf("Command%v: expected %s() not Start()", f.cmdline, f.mode)
+	}
+	return nil
+}
+
+func (f *fakeCmd) Wait() error {
+	if f.exitCode == 0 {
+		return nil
+	}
+	return &exec.ExitError{}
+}
+
 func (f *fakeCmd) Output() ([]byte, error) {
 	_t.Helper()
 	if f.mode != "Output" {
@@ -0,0 +1,204 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pythonTarget identifies how the python program is provided on the command-line.
+type pythonTarget int
+
+const (
+	// targetNone means no program was specified (e.g., `python -V`)
+	targetNone pythonTarget = iota
+	// targetScript is a script file (or directory or zipapp): `python app.py`
+	targetScript
+	// targetModule is a module run with `-m`: `python -m flask`
+	targetModule
+	// targetCommand is a program string passed with `-c`: `python -c 'import app'`
+	targetCommand
+	// targetStdin is a program read from stdin: `python -`
+	targetStdin
+)
+
+// Interpreter options as understood by CPython's getopt (see Python/getopt.c).
+// Python 2 options (-3, -t, -Q) are included as we support Python 2.7.
+const (
+	// pythonFlags are single-character options that take no argument
+	pythonFlags = "bBdEhiIOPqRsSuvVx?3t"
+	// pythonFlagsWithArg are single-character options that require an argument
+	pythonFlagsWithArg = "WXQ"
+)
+
+// pythonLongOptions are the long options supported by CPython; the value is true
+// if the option requires an argument.
+var pythonLongOptions = map[string]bool{
+	"--check-hash-based-pycs": true,
+	"--help":                  false,
+	"--help-all":              false,
+	"--help-env":              false,
+	"--help-xoptions":         false,
+	"--version":               false,
+}
+
+// pythonCommandLine is a parsed python command-line of the form:
+//
+//	python [option ...] [-c cmd | -m mod | file | -] [arg ...]
+type pythonCommandLine struct {
+	// interpreter is the python executable
+	interpreter string
+	// options are the interpreter options, split so that each option and
+	// any argument appear as separate elements (e.g., `-uWignore` becomes
+	// `-u -W ignore`)
+	options []string
+	// kind describes the program target
+	kind pythonTarget
+	// target is the script path, module name, or command string
+	target string
+	// args are the program arguments, available to the program as sys.argv[1:]
+	args []string
+}
+
+// parsePythonCommandLine parses a python command-line, where args[0] is the python interpreter.
+func parsePythonCommandLine(args []string) (pythonCommandLine, error) {
+	if len(args) == 0 {
+		return pythonCommandLine{}, fmt.Errorf("no python command-line specified")
+	}
+	cl := pythonCommandLine{interpreter: args[0]}
+
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			// end of interpreter options
+			i++
+			break
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		if strings.HasPrefix(arg, "--") {
+			name, value := arg, ""
+			hasValue := false
+			if eq := strings.IndexByte(arg, '='); eq > 0 {
+				name, value, hasValue = arg[:eq], arg[eq+1:], true
+			}
+			requiresArg, found := pythonLongOptions[name]
+			switch {
+			case !found:
+				return pythonCommandLine{}, fmt.Errorf("unknown python option: %q", arg)
+			case requiresArg && !hasValue:
+				if i+1 >= len(args) {
+					return pythonCommandLine{}, fmt.Errorf("python option %q requires an argument", name)
+				}
+				i++
+				value = args[i]
+				fallthrough
+			case requiresArg:
+				cl.options = append(cl.options, name, value)
+			case hasValue:
+				return pythonCommandLine{}, fmt.Errorf("python option %q does not take an argument", name)
+			default:
+				cl.options = append(cl.options, name)
+			}
+			continue
+		}
+
+		// one or more short options, possibly combined like `-uB` or `-Wignore`
+		for j := 1; j < len(arg); j++ {
+			c := arg[j]
+			switch {
+			case c == 'c' || c == 'm':
+				// -c and -m terminate the option list: the remainder of this argument
+				// or the next argument is the command or module name
+				value := arg[j+1:]
+				if value == "" {
+					if i+1 >= len(args) {
+						return pythonCommandLine{}, fmt.Errorf("python option -%c requires an argument", c)
+					}
+					i++
+					value = args[i]
+				}
+				cl.kind = targetModule
+				if c == 'c' {
+					cl.kind = targetCommand
+				}
+				cl.target = value
+				cl.args = args[i+1:]
+				return cl, nil
+
+			case strings.IndexByte(pythonFlagsWithArg, c) >= 0:
+				value := arg[j+1:]
+				if value == "" {
+					if i+1 >= len(args) {
+						return pythonCommandLine{}, fmt.Errorf("python option -%c requires an argument", c)
+					}
+					i++
+					value = args[i]
+				}
+				cl.options = append(cl.options, "-"+string(c), value)
+				j = len(arg) // consumed remainder of arg
+
+			case strings.IndexByte(pythonFlags, c) >= 0:
+				cl.options = append(cl.options, "-"+string(c))
+
+			default:
+				return pythonCommandLine{}, fmt.Errorf("unknown python option -%c in %q", c, arg)
+			}
+		}
+	}
+
+	if i < len(args) {
+		cl.kind = targetScript
+		if args[i] == "-" {
+			cl.kind = targetStdin
+		}
+		cl.target = args[i]
+		cl.args = args[i+1:]
+	}
+	return cl, nil
+}
+
+// targetArgs returns the command-line arguments that select the program,
+// in a form suitable for passing to python, debugpy, or ptvsd.
+func (cl pythonCommandLine) targetArgs() []string {
+	switch cl.kind {
+	case targetScript:
+		return []string{cl.target}
+	case targetModule:
+		return []string{"-m", cl.target}
+	case targetCommand:
+		return []string{"-c", cl.target}
+	case targetStdin:
+		return []string{"-"}
+	}
+	return nil
+}
+
+// interpreterArgs returns the interpreter and its options.
+func (cl pythonCommandLine) interpreterArgs() []string {
+	return append([]string{cl.interpreter}, cl.options...)
+}
+
+// commandLine returns the command-line as an argument list.
+func (cl pythonCommandLine) commandLine() []string {
+	cmdline := cl.interpreterArgs()
+	cmdline = append(cmdline, cl.targetArgs()...)
+	return append(cmdline, cl.args...)
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestParsePythonCommandLine(t *testing.T) {
+	tests := []struct {
+		description string
+		args        []string
+		shouldErr   bool
+		expected    pythonCommandLine
+	}{
+		{"no args", nil, true, pythonCommandLine{}},
+		{"interpreter only", []string{"python"}, false, pythonCommandLine{interpreter: "python"}},
+		{"version", []string{"python", "-V"}, false, pythonCommandLine{interpreter: "python", options: []string{"-V"}}},
+		{"script", []string{"python", "app.py"}, false, pythonCommandLine{interpreter: "python", kind: targetScript, target: "app.py"}},
+		{"script with args", []string{"python", "app.py", "-u", "-m", "x"}, false, pythonCommandLine{interpreter: "python", kind: targetScript, target: "app.py", args: []string{"-u", "-m", "x"}}},
+		{"module", []string{"python", "-m", "flask", "run"}, false, pythonCommandLine{interpreter: "python", kind: targetModule, target: "flask", args: []string{"run"}}},
+		{"module (no space)", []string{"python", "-mflask", "run"}, false, pythonCommandLine{interpreter: "python", kind: targetModule, target: "flask", args: []string{"run"}}},
+		{"command", []string{"python", "-c", "import app", "x"}, false, pythonCommandLine{interpreter: "python", kind: targetCommand, target: "import app", args: []string{"x"}}},
+		{"command (no space)", []string{"python", "-cimport app"}, false, pythonCommandLine{interpreter: "python", kind: targetCommand, target: "import app"}},
+		{"stdin", []string{"python", "-", "x"}, false, pythonCommandLine{interpreter: "python", kind: targetStdin, target: "-", args: []string{"x"}}},
+		{"options before module", []string{"python", "-u", "-X", "dev", "-W", "ignore", "-m", "gunicorn", "app:app"}, false, pythonCommandLine{interpreter: "python", options: []string{"-u", "-X", "dev", "-W", "ignore"}, kind: targetModule, target: "gunicorn", args: []string{"app:app"}}},
+		{"combined flags", []string{"python", "-uBEsI", "app.py"}, false, pythonCommandLine{interpreter: "python", options: []string{"-u", "-B", "-E", "-s", "-I"}, kind: targetScript, target: "app.py"}},
+		{"combined flags with argument", []string{"python", "-uWignore", "-OO", "app.py"}, false, pythonCommandLine{interpreter: "python", options: []string{"-u", "-W", "ignore", "-O", "-O"}, kind: targetScript, target: "app.py"}},
+		{"combined flags with module", []string{"python", "-um", "flask"}, false, pythonCommandLine{interpreter: "python", options: []string{"-u"}, kind: targetModule, target: "flask"}},
+		{"combined flags with module (no space)", []string{"python", "-Bmflask"}, false, pythonCommandLine{interpreter: "python", options: []string{"-B"}, kind: targetModule, target: "flask"}},
+		{"-X with value", []string{"python", "-Ximporttime", "-X", "frozen_modules=off", "app.py"}, false, pythonCommandLine{interpreter: "python", options: []string{"-X", "importtime", "-X", "frozen_modules=off"}, kind: targetScript, target: "app.py"}},
+		{"long option with argument", []string{"python", "--check-hash-based-pycs", "always", "app.py"}, false, pythonCommandLine{interpreter: "python", options: []string{"--check-hash-based-pycs", "always"}, kind: targetScript, target: "app.py"}},
+		{"long option with = argument", []string{"python", "--check-hash-based-pycs=never", "app.py"}, false, pythonCommandLine{interpreter: "python", options: []string{"--check-hash-based-pycs", "never"}, kind: targetScript, target: "app.py"}},
+		{"end of options", []string{"python", "-u", "--", "-app.py"}, false, pythonCommandLine{interpreter: "python", options: []string{"-u"}, kind: targetScript, target: "-app.py"}},
+		{"python 2 options", []string{"python2.7", "-3", "-tt", "-Qnew", "app.py"}, false, pythonCommandLine{interpreter: "python2.7", options: []string{"-3", "-t", "-t", "-Q", "new"}, kind: targetScript, target: "app.py"}},
+		{"unknown option", []string{"python", "-Z", "app.py"}, true, pythonCommandLine{}},
+		{"unknown long option", []string{"python", "--frobnicate", "app.py"}, true, pythonCommandLine{}},
+		{"long option with unexpected argument", []string{"python", "--version=1", "app.py"}, true, pythonCommandLine{}},
+		{"missing module", []string{"python", "-m"}, true, pythonCommandLine{}},
+		{"missing command", []string{"python", "-u", "-c"}, true, pythonCommandLine{}},
+		{"missing -W argument", []string{"python", "-W"}, true, pythonCommandLine{}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			result, err := parsePythonCommandLine(test.args)
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %v", result)
+				}
+			} else if err != nil {
+				t.Error("unexpected error:", err)
+			} else if diff := cmp.Diff(test.expected, result, cmp.AllowUnexported(test.expected), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("%T differ (-got, +want): %s", result, diff)
+			}
+		})
+	}
+}
+
+func TestPythonCommandLineRoundTrip(t *testing.T) {
+	tests := []struct {
+		description string
+		args        []string
+		expected    []string
+	}{
+		{"script", []string{"python", "app.py", "arg"}, []string{"python", "app.py", "arg"}},
+		{"module", []string{"python", "-mflask", "run"}, []string{"python", "-m", "flask", "run"}},
+		{"command", []string{"python", "-u", "-c", "import app", "x"}, []string{"python", "-u", "-c", "import app", "x"}},
+		{"stdin", []string{"python", "-uB", "-", "x"}, []string{"python", "-u", "-B", "-", "x"}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			cl, err := parsePythonCommandLine(test.args)
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if diff := cmp.Diff(test.expected, cl.commandLine()); diff != "" {
+				t.Errorf("command-lines differ (-got, +want): %s", diff)
+			}
+		})
+	}
+}
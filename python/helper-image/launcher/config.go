@@ -0,0 +1,358 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// launcherConfig is the launcher configuration as read from a configuration file.
+// Unset values are nil, and are left to the environment variables or to the defaults,
+// so that a command override can set a value back to `0`, `false`, or `""`.  Settings
+// that are otherwise provided through environment variables are applied as those
+// variables, and only when the variable is not already set.
+type launcherConfig struct {
+	Mode              string  `json:"mode,omitempty"`
+	Port              *uint   `json:"port,omitempty"`
+	Wait              *bool   `json:"wait,omitempty"`
+	WaitTimeout       *uint   `json:"waitTimeout,omitempty"`
+	WaitTimeoutAction *string `json:"waitTimeoutAction,omitempty"`
+	Subprocess        *bool   `json:"subprocess,omitempty"`
+	PortRange         *string `json:"portRange,omitempty"`
+	Connect           *string `json:"connect,omitempty"`
+	Inject            *bool   `json:"inject,omitempty"`
+	InjectMatch       *string `json:"injectMatch,omitempty"`
+	StatusAddress     *string `json:"statusAddress,omitempty"`
+
+	// Enabled is WRAPPER_ENABLED
+	Enabled *bool `json:"enabled,omitempty"`
+	// SkipEnv is WRAPPER_SKIP_ENV
+	SkipEnv *bool `json:"skipEnv,omitempty"`
+	// PythonVersion is WRAPPER_PYTHON_VERSION
+	PythonVersion *string `json:"pythonVersion,omitempty"`
+	// PythonImplementation is WRAPPER_PYTHON_IMPLEMENTATION
+	PythonImplementation *string `json:"pythonImplementation,omitempty"`
+	// IDE is WRAPPER_IDE
+	IDE *string `json:"ide,omitempty"`
+	// Verbose is WRAPPER_VERBOSE
+	Verbose *string `json:"verbose,omitempty"`
+
+	// Commands are overrides for particular commands, applied in order
+	Commands []commandConfig `json:"commands,omitempty"`
+}
+
+// commandConfig overrides the configuration for commands that match a pattern.
+type commandConfig struct {
+	// Match is a glob pattern, like `gunicorn` or `celery*`, matched against the name
+	// of the program and, for python command-lines, the module or script name.
+	Match string `json:"match"`
+	launcherConfig
+}
+
+// defaultConfigFile returns the location of the configuration file used when
+// neither `--config` nor WRAPPER_CONFIG are set.
+func defaultConfigFile() string {
+	return filepath.Join(dbgRoot, "python", "launcher.json")
+}
+
+// readConfig reads the configuration file from the given location, or from
+// WRAPPER_CONFIG, or otherwise from the default location.  It returns the location
+// of the file read, or "" if there was no file at the default location.
+func readConfig(location string, env env) (launcherConfig, string, error) {
+	if location == "" {
+		location = env["WRAPPER_CONFIG"]
+	}
+	if location == "" {
+		location = defaultConfigFile()
+		if !pathExists(location) {
+			return launcherConfig{}, "", nil
+		}
+	}
+	data, err := ioutil.ReadFile(location)
+	if err != nil {
+		return launcherConfig{}, "", fmt.Errorf("unable to read configuration: %w", err)
+	}
+	c, err := parseConfig(data)
+	if err != nil {
+		return launcherConfig{}, "", fmt.Errorf("invalid configuration %q: %w", location, err)
+	}
+	logrus.Debugf("read configuration %q", location)
+	return c, location, nil
+}
+
+// parseConfig parses a JSON configuration.  Unknown fields are rejected to catch typos.
+func parseConfig(data []byte) (launcherConfig, error) {
+	var c launcherConfig
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&c); err != nil {
+		return launcherConfig{}, err
+	}
+	for i, cc := range c.Commands {
+		if cc.Match == "" {
+			return launcherConfig{}, fmt.Errorf("command override %d has no match pattern", i)
+		}
+		if _, err := path.Match(cc.Match, ""); err != nil {
+			return launcherConfig{}, fmt.Errorf("command override %d: bad pattern %q", i, cc.Match)
+		}
+		if len(cc.Commands) > 0 {
+			return launcherConfig{}, fmt.Errorf("command override %q cannot have command overrides", cc.Match)
+		}
+	}
+	if c.Mode != "" {
+		if err := validateDebugMode(c.Mode); err != nil {
+			return launcherConfig{}, err
+		}
+	}
+	if c.WaitTimeoutAction != nil {
+		if err := validateWaitTimeoutAction(*c.WaitTimeoutAction); err != nil {
+			return launcherConfig{}, err
+		}
+	}
+	return c, nil
+}
+
+// forCommand returns the configuration with the overrides for the command-line applied.
+func (c launcherConfig) forCommand(args []string) launcherConfig {
+	result := c
+	result.Commands = nil
+	names := commandNames(args)
+	for _, cc := range c.Commands {
+		for _, name := range names {
+			if matched, _ := path.Match(cc.Match, name); matched {
+				logrus.Debugf("applying configuration for %q to %q", cc.Match, name)
+				result.merge(cc.launcherConfig)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// merge overlays the set values of the other configuration.
+func (c *launcherConfig) merge(other launcherConfig) {
+	if other.Mode != "" {
+		c.Mode = other.Mode
+	}
+	if other.Port != nil {
+		c.Port = other.Port
+	}
+	if other.Wait != nil {
+		c.Wait = other.Wait
+	}
+	if other.WaitTimeout != nil {
+		c.WaitTimeout = other.WaitTimeout
+	}
+	if other.WaitTimeoutAction != nil {
+		c.WaitTimeoutAction = other.WaitTimeoutAction
+	}
+	if other.Subprocess != nil {
+		c.Subprocess = other.Subprocess
+	}
+	if other.PortRange != nil {
+		c.PortRange = other.PortRange
+	}
+	if other.Connect != nil {
+		c.Connect = other.Connect
+	}
+	if other.Inject != nil {
+		c.Inject = other.Inject
+	}
+	if other.InjectMatch != nil {
+		c.InjectMatch = other.InjectMatch
+	}
+	if other.StatusAddress != nil {
+		c.StatusAddress = other.StatusAddress
+	}
+	if other.Enabled != nil {
+		c.Enabled = other.Enabled
+	}
+	if other.SkipEnv != nil {
+		c.SkipEnv = other.SkipEnv
+	}
+	if other.PythonVersion != nil {
+		c.PythonVersion = other.PythonVersion
+	}
+	if other.PythonImplementation != nil {
+		c.PythonImplementation = other.PythonImplementation
+	}
+	if other.IDE != nil {
+		c.IDE = other.IDE
+	}
+	if other.Verbose != nil {
+		c.Verbose = other.Verbose
+	}
+}
+
+// commandNames returns the names by which a command-line can be matched: the base name
+// of the program and, for a python command-line, the module or the script's base name.
+func commandNames(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	names := []string{filepath.Base(args[0])}
+	if !isPythonInterpreter(args[0]) {
+		return names
+	}
+	cl, err := parsePythonCommandLine(args)
+	if err != nil {
+		return names
+	}
+	switch cl.kind {
+	case targetModule:
+		names = append(names, cl.target)
+	case targetScript:
+		names = append(names, filepath.Base(cl.target))
+	}
+	return names
+}
+
+// envSettings returns the configured settings that are provided as environment variables.
+// Settings of `""` leave the variable unset.
+func (c launcherConfig) envSettings() map[string]string {
+	settings := map[string]string{}
+	if c.Enabled != nil {
+		settings["WRAPPER_ENABLED"] = strconv.FormatBool(*c.Enabled)
+	}
+	// WRAPPER_SKIP_ENV is honoured when set to any value
+	if c.SkipEnv != nil && *c.SkipEnv {
+		settings["WRAPPER_SKIP_ENV"] = "true"
+	}
+	for name, value := range map[string]*string{
+		"WRAPPER_PYTHON_VERSION":        c.PythonVersion,
+		"WRAPPER_PYTHON_IMPLEMENTATION": c.PythonImplementation,
+		"WRAPPER_IDE":                   c.IDE,
+		"WRAPPER_VERBOSE":               c.Verbose,
+	} {
+		if value != nil && *value != "" {
+			settings[name] = *value
+		}
+	}
+	return settings
+}
+
+// applyConfig applies the configuration to the launch context where not overridden by
+// the flags that were explicitly set or by the environment.  Returns the port range.
+func (pc *pythonContext) applyConfig(c launcherConfig, flags map[string]bool, portRange string) string {
+	pc.flags = flags
+	if !flags["mode"] && c.Mode != "" {
+		pc.debugMode = c.Mode
+	}
+	if !flags["port"] && c.Port != nil {
+		pc.port = *c.Port
+	}
+	if !flags["wait"] && c.Wait != nil {
+		pc.wait = *c.Wait
+	}
+	if !flags["wait-timeout"] && c.WaitTimeout != nil {
+		pc.waitTimeout = *c.WaitTimeout
+	}
+	if !flags["wait-timeout-action"] && c.WaitTimeoutAction != nil {
+		pc.waitTimeoutAction = *c.WaitTimeoutAction
+	}
+	if !flags["subprocess"] && c.Subprocess != nil {
+		pc.subprocess = *c.Subprocess
+	}
+	if !flags["port-range"] && c.PortRange != nil {
+		portRange = *c.PortRange
+	}
+	if !flags["connect"] && c.Connect != nil {
+		pc.connect = *c.Connect
+	}
+	if !flags["inject"] && c.Inject != nil {
+		pc.inject = *c.Inject
+	}
+	if !flags["inject-match"] && c.InjectMatch != nil {
+		pc.injectMatch = *c.InjectMatch
+	}
+	if !flags["status-address"] && c.StatusAddress != nil {
+		pc.statusAddress = *c.StatusAddress
+	}
+	for name, value := range c.envSettings() {
+		if _, found := pc.env[name]; !found {
+			pc.env[name] = value
+			pc.configEnv = append(pc.configEnv, name)
+		}
+	}
+	return portRange
+}
+
+// unsetConfigEnv removes the environment variables set from the configuration file so
+// that they are not inherited by the app or by nested launchers, which read the
+// configuration file themselves.
+func (pc *pythonContext) unsetConfigEnv() {
+	for _, name := range pc.configEnv {
+		delete(pc.env, name)
+	}
+	pc.configEnv = nil
+}
+
+// effectiveConfig returns the configuration in effect for the launch.  Empty values
+// are omitted.
+func (pc *pythonContext) effectiveConfig() launcherConfig {
+	enabled := isEnabled(pc.env)
+	skipEnv := pc.env["WRAPPER_SKIP_ENV"] != ""
+	c := launcherConfig{
+		Mode:                 pc.debugMode,
+		Port:                 &pc.port,
+		Wait:                 &pc.wait,
+		WaitTimeout:          &pc.waitTimeout,
+		WaitTimeoutAction:    optionalString(pc.waitTimeoutAction),
+		Subprocess:           &pc.subprocess,
+		Connect:              optionalString(pc.connect),
+		Inject:               &pc.inject,
+		InjectMatch:          optionalString(pc.injectMatch),
+		StatusAddress:        optionalString(pc.statusAddress),
+		Enabled:              &enabled,
+		SkipEnv:              &skipEnv,
+		PythonVersion:        optionalString(pc.env["WRAPPER_PYTHON_VERSION"]),
+		PythonImplementation: optionalString(pc.env["WRAPPER_PYTHON_IMPLEMENTATION"]),
+		IDE:                  optionalString(pc.env["WRAPPER_IDE"]),
+		Verbose:              optionalString(logrusLevel(pc.env).String()),
+	}
+	if !pc.portRange.isEmpty() {
+		c.PortRange = optionalString(pc.portRange.String())
+	}
+	return c
+}
+
+// optionalString returns the string as an optional setting, which is unset if empty.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// printConfig writes the effective configuration as JSON to stdout.
+func (pc *pythonContext) printConfig() error {
+	data, err := json.MarshalIndent(pc.effectiveConfig(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(os.Stdout, string(data))
+	return err
+}
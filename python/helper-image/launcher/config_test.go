@@ -0,0 +1,250 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// optionalUint returns the number as an optional setting.
+func optionalUint(u uint) *uint {
+	return &u
+}
+
+func TestParseConfig(t *testing.T) {
+	yes := true
+	tests := []struct {
+		description string
+		config      string
+		shouldErr   bool
+		expected    launcherConfig
+	}{
+		{description: "empty", config: `{}`},
+		{
+			description: "settings",
+			config:      `{"mode": "debugpy", "port": 5678, "wait": true, "waitTimeout": 60, "waitTimeoutAction": "exit", "subprocess": true, "connect": "ide:5678", "inject": true, "injectMatch": "gunicorn", "skipEnv": true, "pythonVersion": "3.9", "ide": "vscode"}`,
+			expected:    launcherConfig{Mode: "debugpy", Port: optionalUint(5678), Wait: &yes, WaitTimeout: optionalUint(60), WaitTimeoutAction: optionalString("exit"), Subprocess: &yes, Connect: optionalString("ide:5678"), Inject: &yes, InjectMatch: optionalString("gunicorn"), SkipEnv: &yes, PythonVersion: optionalString("3.9"), IDE: optionalString("vscode")},
+		},
+		{
+			description: "command overrides",
+			config:      `{"mode": "auto", "commands": [{"match": "celery", "portRange": "5678-5687"}]}`,
+			expected:    launcherConfig{Mode: "auto", Commands: []commandConfig{{Match: "celery", launcherConfig: launcherConfig{PortRange: optionalString("5678-5687")}}}},
+		},
+		{description: "unknown field", config: `{"mdoe": "debugpy"}`, shouldErr: true},
+		{description: "unknown mode", config: `{"mode": "pdb"}`, shouldErr: true},
+		{description: "unknown wait timeout action", config: `{"waitTimeoutAction": "abort"}`, shouldErr: true},
+		{description: "not json", config: `mode: debugpy`, shouldErr: true},
+		{description: "override without pattern", config: `{"commands": [{"port": 5678}]}`, shouldErr: true},
+		{description: "override with bad pattern", config: `{"commands": [{"match": "[", "port": 5678}]}`, shouldErr: true},
+		{description: "nested overrides", config: `{"commands": [{"match": "a", "commands": [{"match": "b"}]}]}`, shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			result, err := parseConfig([]byte(test.config))
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if diff := cmp.Diff(test.expected, result, cmp.AllowUnexported(commandConfig{})); diff != "" {
+				t.Errorf("%T differ (-got, +want): %s", result, diff)
+			}
+		})
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+	dir := t.TempDir()
+	explicit := writeFile(t, dir, "explicit.json", `{"port": 1}`)
+	fromEnv := writeFile(t, dir, "env.json", `{"port": 2}`)
+	invalid := writeFile(t, dir, "invalid.json", `{"port": "x"}`)
+
+	tests := []struct {
+		description string
+		location    string
+		env         env
+		defaultFile string
+		shouldErr   bool
+		expected    string
+		port        uint
+	}{
+		{description: "no configuration"},
+		{description: "default", defaultFile: `{"port": 3}`, expected: filepath.Join(dbgRoot, "python", "launcher.json"), port: 3},
+		{description: "WRAPPER_CONFIG", env: env{"WRAPPER_CONFIG": fromEnv}, defaultFile: `{"port": 3}`, expected: fromEnv, port: 2},
+		{description: "--config", location: explicit, env: env{"WRAPPER_CONFIG": fromEnv}, expected: explicit, port: 1},
+		{description: "missing", location: filepath.Join(dir, "missing.json"), shouldErr: true},
+		{description: "invalid", location: invalid, shouldErr: true},
+		{description: "invalid default", defaultFile: `{`, shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if test.defaultFile != "" {
+				writeFile(t, dbgRoot, "python/launcher.json", test.defaultFile)
+				t.Cleanup(func() { writeFile(t, dbgRoot, "python/launcher.json", "{}") })
+			}
+			c, location, err := readConfig(test.location, test.env)
+			if test.shouldErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if location != test.expected && !(test.expected == "" && location == defaultConfigFile()) {
+				t.Errorf("expected configuration %q but got %q", test.expected, location)
+			}
+			if (c.Port == nil && test.port != 0) || (c.Port != nil && *c.Port != test.port) {
+				t.Errorf("expected port %d but got %v", test.port, c.Port)
+			}
+		})
+	}
+}
+
+func TestForCommand(t *testing.T) {
+	yes, no := true, false
+	c := launcherConfig{
+		Mode: "debugpy",
+		Port: optionalUint(5678),
+		Commands: []commandConfig{
+			{Match: "celery", launcherConfig: launcherConfig{PortRange: optionalString("5678-5687")}},
+			{Match: "manage.py", launcherConfig: launcherConfig{Enabled: &no}},
+			{Match: "gunicorn", launcherConfig: launcherConfig{Mode: "pydevd", Wait: &yes}},
+			{Match: "gunicorn*", launcherConfig: launcherConfig{Port: optionalUint(7000)}},
+		},
+	}
+	tests := []struct {
+		description string
+		args        []string
+		expected    launcherConfig
+	}{
+		{description: "no match", args: []string{"python", "app.py"}, expected: launcherConfig{Mode: "debugpy", Port: optionalUint(5678)}},
+		{description: "program", args: []string{"/usr/local/bin/celery", "worker"}, expected: launcherConfig{Mode: "debugpy", Port: optionalUint(5678), PortRange: optionalString("5678-5687")}},
+		{description: "module", args: []string{"python3", "-u", "-m", "celery", "worker"}, expected: launcherConfig{Mode: "debugpy", Port: optionalUint(5678), PortRange: optionalString("5678-5687")}},
+		{description: "script", args: []string{"python", "/app/manage.py", "runserver"}, expected: launcherConfig{Mode: "debugpy", Port: optionalUint(5678), Enabled: &no}},
+		{description: "later overrides win", args: []string{"gunicorn", "app:app"}, expected: launcherConfig{Mode: "pydevd", Port: optionalUint(7000), Wait: &yes}},
+		{description: "arguments are not matched", args: []string{"python", "app.py", "celery"}, expected: launcherConfig{Mode: "debugpy", Port: optionalUint(5678)}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			result := c.forCommand(test.args)
+			if diff := cmp.Diff(test.expected, result); diff != "" {
+				t.Errorf("%T differ (-got, +want): %s", result, diff)
+			}
+		})
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	yes, no := true, false
+	c := launcherConfig{Mode: "pydevd", Port: optionalUint(7000), Wait: &yes, WaitTimeout: optionalUint(60), WaitTimeoutAction: optionalString("exit"), Subprocess: &yes, PortRange: optionalString("7000-7009"), Connect: optionalString("ide:7000"), Inject: &yes, InjectMatch: optionalString("celery*"), StatusAddress: optionalString(":5680"), Enabled: &no, SkipEnv: &yes, PythonVersion: optionalString("3.9"), Verbose: optionalString("debug")}
+
+	t.Run("configuration file over defaults", func(t *testing.T) {
+		pc := pythonContext{debugMode: "", port: 9999, env: env{}}
+		r := pc.applyConfig(c, map[string]bool{}, "")
+		expected := pythonContext{debugMode: "pydevd", port: 7000, wait: true, waitTimeout: 60, waitTimeoutAction: "exit", subprocess: true, connect: "ide:7000", inject: true, injectMatch: "celery*", statusAddress: ":5680", flags: map[string]bool{},
+			env:       env{"WRAPPER_ENABLED": "false", "WRAPPER_SKIP_ENV": "true", "WRAPPER_PYTHON_VERSION": "3.9", "WRAPPER_VERBOSE": "debug"},
+			configEnv: []string{"WRAPPER_ENABLED", "WRAPPER_PYTHON_VERSION", "WRAPPER_SKIP_ENV", "WRAPPER_VERBOSE"}}
+		if diff := cmp.Diff(expected, pc, cmp.AllowUnexported(expected, pythonVersion{}, portRange{}), cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+			t.Errorf("%T differ (-got, +want): %s", pc, diff)
+		}
+		if r != "7000-7009" {
+			t.Errorf("expected port range from configuration but got %q", r)
+		}
+
+		pc.unsetConfigEnv()
+		if len(pc.env) != 0 || pc.configEnv != nil {
+			t.Errorf("configuration environment not removed: %v", pc.env)
+		}
+	})
+
+	t.Run("flags and environment over configuration file", func(t *testing.T) {
+		flags := map[string]bool{"mode": true, "port": true, "wait": true, "subprocess": true, "port-range": true, "connect": true, "inject": true, "inject-match": true, "wait-timeout": true, "wait-timeout-action": true}
+		pc := pythonContext{debugMode: "debugpy", port: 5678, wait: false, env: env{"WRAPPER_ENABLED": "true", "WRAPPER_VERBOSE": "warn"}}
+		r := pc.applyConfig(c, flags, "")
+		expected := pythonContext{debugMode: "debugpy", port: 5678, wait: false, statusAddress: ":5680", flags: flags,
+			env:       env{"WRAPPER_ENABLED": "true", "WRAPPER_SKIP_ENV": "true", "WRAPPER_PYTHON_VERSION": "3.9", "WRAPPER_VERBOSE": "warn"},
+			configEnv: []string{"WRAPPER_PYTHON_VERSION", "WRAPPER_SKIP_ENV"}}
+		if diff := cmp.Diff(expected, pc, cmp.AllowUnexported(expected, pythonVersion{}, portRange{}), cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+			t.Errorf("%T differ (-got, +want): %s", pc, diff)
+		}
+		if r != "" {
+			t.Errorf("expected flag to override port range but got %q", r)
+		}
+
+		// only the variables set from the configuration file are removed
+		pc.unsetConfigEnv()
+		if diff := cmp.Diff(env{"WRAPPER_ENABLED": "true", "WRAPPER_VERBOSE": "warn"}, pc.env); diff != "" {
+			t.Errorf("env differs (-got, +want): %s", diff)
+		}
+	})
+}
+
+func TestApplyConfigOverrideToZero(t *testing.T) {
+	c, err := parseConfig([]byte(`{"port": 5678, "wait": true, "portRange": "5678-5687", "statusAddress": ":5680", "pythonVersion": "3.9",
+		"commands": [{"match": "manage.py", "port": 0, "wait": false, "portRange": "", "statusAddress": "", "pythonVersion": ""}]}`))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	pc := pythonContext{port: 9999, env: env{}}
+	r := pc.applyConfig(c.forCommand([]string{"python", "manage.py", "migrate"}), map[string]bool{}, "")
+	expected := pythonContext{port: 0, wait: false, statusAddress: "", flags: map[string]bool{}, env: env{}}
+	if diff := cmp.Diff(expected, pc, cmp.AllowUnexported(expected, pythonVersion{}, portRange{})); diff != "" {
+		t.Errorf("%T differ (-got, +want): %s", pc, diff)
+	}
+	if r != "" {
+		t.Errorf("expected the override to clear the port range but got %q", r)
+	}
+
+	// other commands keep the settings
+	pc = pythonContext{port: 9999, env: env{}}
+	r = pc.applyConfig(c.forCommand([]string{"python", "app.py"}), map[string]bool{}, "")
+	expected = pythonContext{port: 5678, wait: true, statusAddress: ":5680", flags: map[string]bool{}, env: env{"WRAPPER_PYTHON_VERSION": "3.9"}, configEnv: []string{"WRAPPER_PYTHON_VERSION"}}
+	if diff := cmp.Diff(expected, pc, cmp.AllowUnexported(expected, pythonVersion{}, portRange{})); diff != "" {
+		t.Errorf("%T differ (-got, +want): %s", pc, diff)
+	}
+	if r != "5678-5687" {
+		t.Errorf("expected port range from configuration but got %q", r)
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	yes, no := true, false
+	pc := pythonContext{debugMode: "debugpy", port: 5678, portRange: portRange{5678, 5687}, env: env{"WRAPPER_ENABLED": "no", "WRAPPER_IDE": "vscode"}}
+	expected := launcherConfig{Mode: "debugpy", Port: optionalUint(5678), Wait: &no, WaitTimeout: optionalUint(0), Subprocess: &no, PortRange: optionalString("5678-5687"), Inject: &no, Enabled: &no, SkipEnv: &no, IDE: optionalString("vscode"), Verbose: optionalString("warning")}
+	if diff := cmp.Diff(expected, pc.effectiveConfig()); diff != "" {
+		t.Errorf("%T differ (-got, +want): %s", expected, diff)
+	}
+
+	pc = pythonContext{debugMode: "pydevd", port: 9999, wait: true, waitTimeout: 30, waitTimeoutAction: "exit", subprocess: true, connect: "ide:9999", inject: true, injectMatch: "gunicorn", env: env{"WRAPPER_SKIP_ENV": "1", "WRAPPER_VERBOSE": "debug"}}
+	expected = launcherConfig{Mode: "pydevd", Port: optionalUint(9999), Wait: &yes, WaitTimeout: optionalUint(30), WaitTimeoutAction: optionalString("exit"), Subprocess: &yes, Connect: optionalString("ide:9999"), Inject: &yes, InjectMatch: optionalString("gunicorn"), Enabled: &yes, SkipEnv: &yes, Verbose: optionalString("debug")}
+	if diff := cmp.Diff(expected, pc.effectiveConfig()); diff != "" {
+		t.Errorf("%T differ (-got, +want): %s", expected, diff)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// parseConnectAddress parses the `host:port` address of a debugger to connect to.
+func parseConnectAddress(s string) (string, uint, error) {
+	host, p, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid connect address %q: expecting host:port", s)
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil || port == 0 {
+		return "", 0, fmt.Errorf("invalid connect address %q: bad port", s)
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("invalid connect address %q: no host", s)
+	}
+	return host, uint(port), nil
+}
+
+// connectHost returns the host of the debugger to connect to, or "" if the backend listens.
+func (pc *pythonContext) connectHost() string {
+	host, _, _ := net.SplitHostPort(pc.connect)
+	return host
+}
+
+// checkConnectSupported returns an error if the debug mode cannot connect to the debugger
+// for this launch.
+func (pc *pythonContext) checkConnectSupported() error {
+	if pc.debugMode != ModePtvsd {
+		return nil
+	}
+	// ptvsd's API can only listen
+	if pc.embedded != "" {
+		return fmt.Errorf("%s cannot connect to a debugger from %s", pc.debugMode, pc.embedded)
+	}
+	if pc.inject || pc.reloader != "" {
+		return fmt.Errorf("%s cannot connect to a debugger when injected", pc.debugMode)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseConnectAddress(t *testing.T) {
+	tests := []struct {
+		address   string
+		shouldErr bool
+		host      string
+		port      uint
+	}{
+		{address: "localhost:5678", host: "localhost", port: 5678},
+		{address: "host.docker.internal:12345", host: "host.docker.internal", port: 12345},
+		{address: "[::1]:5678", host: "::1", port: 5678},
+		{address: "localhost", shouldErr: true},
+		{address: ":5678", shouldErr: true},
+		{address: "localhost:0", shouldErr: true},
+		{address: "localhost:70000", shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			host, port, err := parseConnectAddress(test.address)
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %q %d", host, port)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if host != test.host || port != test.port {
+				t.Errorf("expected %q %d but got %q %d", test.host, test.port, host, port)
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Servers that embed the python interpreter, whose python processes are configured for
+// debugging through the server's own options rather than through the python command-line.
+const (
+	// EmbeddedUwsgi is uWSGI, a native binary that embeds python or loads a python plugin
+	EmbeddedUwsgi = "uwsgi"
+	// EmbeddedModWsgi is `mod_wsgi-express`, which runs Apache httpd with mod_wsgi
+	EmbeddedModWsgi = "mod_wsgi"
+)
+
+// bootstrapModule is the name of the module that starts the debugging backend within
+// each of an embedded server's python processes.
+const bootstrapModule = "skaffold_debug"
+
+// uwsgiPluginDirs are the usual locations of uWSGI's plugins, as installed by distributions.
+var uwsgiPluginDirs = []string{"/usr/lib/uwsgi/plugins", "/usr/lib/uwsgi", "/usr/lib64/uwsgi", "/usr/local/lib/uwsgi/plugins"}
+
+// embeddedBootstrap is a module that starts the debugging backend when imported.  uWSGI
+// imports the module in its master process when the app is loaded before forking workers,
+// in which case the backend is started in each worker after it is forked.  With a port
+// range, each process claims its own port as defined by portClaimBootstrap, as do the
+// processes that python subsequently forks, moving on to the next port should it be in
+// use by a process that has not claimed it.  With a debugger to connect to, each process
+// instead connects to the debugger.  The serving processes of a reloader retry starting
+// the backend for a while, as the port may still be held for the previous process.
+const embeddedBootstrap = `_skaffold_mode = '{mode}'
+_skaffold_port = {port}
+_skaffold_wait = {wait}
+_skaffold_subprocess = {subprocess}
+_skaffold_range = {range}
+_skaffold_connect = base64.b64decode('{connect}').decode('utf-8')
+_skaffold_retry = {retry}
+
+def _skaffold_listen():
+    if _skaffold_range:
+        port = _skaffold_first
+        while True:
+            port = _skaffold_claim(port)
+            if port is None:
+                sys.stderr.write('skaffold: no free debug port in %d-%d for process %d\n' % (_skaffold_first, _skaffold_last, os.getpid()))
+                return
+            try:
+                _skaffold_attach(port)
+                return
+            except Exception:
+                # the port is in use by a process that has not claimed it
+                _skaffold_release(port)
+                port += 1
+    deadline = time.time() + _skaffold_retry
+    while True:
+        try:
+            _skaffold_attach(_skaffold_port)
+            return
+        except Exception as e:
+            if time.time() < deadline:
+                # the port may still be held on behalf of the previous process
+                time.sleep(0.5)
+                continue
+            sys.stderr.write('skaffold: unable to start %s on port %d in process %d: %s\n' % (_skaffold_mode, _skaffold_port, os.getpid(), e))
+            return
+
+def _skaffold_attach(port):
+    if _skaffold_connect and _skaffold_mode == 'debugpy':
+        import debugpy
+        debugpy.configure(subProcess=_skaffold_subprocess)
+        debugpy.connect((_skaffold_connect, port))
+    elif _skaffold_connect:
+        import pydevd
+        pydevd.settrace(_skaffold_connect, port=port, suspend=False, patch_multiprocessing=_skaffold_subprocess)
+    elif _skaffold_mode == 'debugpy':
+        import debugpy
+        debugpy.configure(subProcess=_skaffold_subprocess)
+        debugpy.listen(('localhost', port))
+        if _skaffold_wait:
+            _skaffold_wait_for_client(debugpy.wait_for_client, debugpy.wait_for_client.cancel)
+    elif _skaffold_mode == 'ptvsd':
+        import ptvsd
+        ptvsd.enable_attach(address=('localhost', port))
+        if _skaffold_wait:
+            _skaffold_wait_for_client(ptvsd.wait_for_attach)
+    else:
+        import pydevd
+        pydevd._enable_attach(('', port), patch_multiprocessing=_skaffold_subprocess)
+        if _skaffold_wait:
+            import threading
+            cancel = threading.Event()
+            _skaffold_wait_for_client(lambda: pydevd._wait_for_attach(cancel), cancel.set)
+
+def _skaffold_after_fork():
+    if _skaffold_reset():
+        _skaffold_listen()
+
+def _skaffold_start():
+    try:
+        import uwsgi
+    except ImportError:
+        _skaffold_listen()
+        # processes forked by python, such as gunicorn workers, claim ports of their own
+        if _skaffold_range and hasattr(os, 'register_at_fork'):
+            os.register_at_fork(after_in_child=_skaffold_after_fork)
+        return
+    if uwsgi.worker_id() > 0:
+        _skaffold_listen()
+        return
+    # the master process forks the workers after loading the app
+    try:
+        from uwsgidecorators import postfork
+        postfork(_skaffold_listen)
+    except ImportError:
+        uwsgi.post_fork_hook = _skaffold_listen
+
+_skaffold_start()
+`
+
+// embeddedServer returns the server that embeds python for the command-line, or ""
+// if the command-line runs python directly.
+func embeddedServer(args []string) string {
+	if strings.HasPrefix(filepath.Base(args[0]), "uwsgi") {
+		return EmbeddedUwsgi
+	}
+	if !isPythonInterpreter(args[0]) {
+		return ""
+	}
+	cl, err := parsePythonCommandLine(args)
+	if err == nil && cl.kind == targetScript && filepath.Base(cl.target) == "mod_wsgi-express" && len(cl.args) > 0 {
+		switch cl.args[0] {
+		case "start-server", "setup-server":
+			return EmbeddedModWsgi
+		}
+	}
+	return ""
+}
+
+// embeddedInterpreter determines the version and implementation of the python interpreter
+// embedded in the server.  mod_wsgi-express is run by the same python as mod_wsgi.
+func (pc *pythonContext) embeddedInterpreter(ctx context.Context) error {
+	if pc.embedded != EmbeddedUwsgi {
+		return pc.isPythonLauncher(ctx)
+	}
+	p, err := lookPath(pc.args[0], pc.env)
+	if err != nil {
+		return err
+	}
+	version, impl, err := resolveInterpreter(p, pc.env, func() (interpreterInfo, error) {
+		return uwsgiInterpreter(ctx, p, pc.args, pc.env)
+	})
+	pc.version = version
+	pc.implementation = impl
+	return err
+}
+
+// uwsgiInterpreter determines the python embedded by uWSGI from the `libpythonX.Y` required
+// by the binary or by its python plugin, from the virtual environment in which uWSGI is
+// installed, or from the virtual environment configured with `--home`.
+func uwsgiInterpreter(ctx context.Context, uwsgi string, args []string, env env) (interpreterInfo, error) {
+	if info := versionFromELF(uwsgi); info.version != "" {
+		logrus.Debugf("uWSGI python %s from %q", info.version, uwsgi)
+		return info, nil
+	}
+	if info := versionFromPyvenvCfg(uwsgi); info.version != "" {
+		logrus.Debugf("uWSGI python %s from the virtual environment of %q", info.version, uwsgi)
+		return info, nil
+	}
+	for _, plugin := range uwsgiPythonPlugins(args) {
+		if info := versionFromELF(plugin); info.version != "" {
+			logrus.Debugf("uWSGI python %s from plugin %q", info.version, plugin)
+			return info, nil
+		}
+	}
+	for _, home := range uwsgiOptionValues(args, "--home", "-H", "--virtualenv", "--venv", "--pyhome") {
+		if info, err := detectInterpreter(ctx, filepath.Join(home, "bin", "python"), env); err == nil {
+			logrus.Debugf("uWSGI python %s from virtual environment %q", info.version, home)
+			return info, nil
+		}
+	}
+	return interpreterInfo{}, fmt.Errorf("unable to determine the python embedded by %q: set WRAPPER_PYTHON_VERSION", uwsgi)
+}
+
+// uwsgiPythonPlugins returns the locations of the python plugins loaded by uWSGI.  If no
+// plugin is named on the command-line, such as when configured in an `.ini` file, then
+// the python plugin found in the plugin directories is used when there is only one.
+func uwsgiPythonPlugins(args []string) []string {
+	dirs := append(uwsgiOptionValues(args, "--plugins-dir", "--plugin-dir"), uwsgiPluginDirs...)
+	var names []string
+	for _, value := range uwsgiOptionValues(args, "--plugin", "--plugins", "--need-plugin", "--need-plugins") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); strings.Contains(name, "python") {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		var found []string
+		for _, dir := range dirs {
+			matches, _ := filepath.Glob(filepath.Join(dir, "python*_plugin.so"))
+			found = append(found, matches...)
+		}
+		if len(found) == 1 {
+			return found
+		}
+		return nil
+	}
+	var plugins []string
+	for _, name := range names {
+		if strings.Contains(name, "/") {
+			plugins = append(plugins, name)
+			continue
+		}
+		for _, dir := range dirs {
+			if p := filepath.Join(dir, name+"_plugin.so"); pathExists(p) {
+				plugins = append(plugins, p)
+				break
+			}
+		}
+	}
+	return plugins
+}
+
+// uwsgiOptionValues returns the values of the named options, which are given as
+// `--option value`, `--option=value`, or `-o value`.
+func uwsgiOptionValues(args []string, names ...string) []string {
+	var values []string
+	for i := 1; i < len(args); i++ {
+		for _, name := range names {
+			switch {
+			case args[i] == name && i+1 < len(args):
+				values = append(values, args[i+1])
+			case strings.HasPrefix(args[i], name+"="):
+				values = append(values, args[i][len(name)+1:])
+			}
+		}
+	}
+	return values
+}
+
+// updateEmbeddedCommandLine adds options to the server's command-line to have each of its
+// python processes find the debugging backend and import the bootstrap module.
+func (pc *pythonContext) updateEmbeddedCommandLine() error {
+	libraryPath, err := pc.bundledLibraryPath()
+	if err != nil {
+		return err
+	}
+	dir, err := pc.writeBootstrapModule()
+	if err != nil {
+		return err
+	}
+	var paths []string
+	if libraryPath != "" {
+		paths = append(paths, libraryPath)
+	}
+	paths = append(paths, dir)
+
+	switch pc.embedded {
+	case EmbeddedUwsgi:
+		for _, p := range paths {
+			pc.args = append(pc.args, "--pythonpath", p)
+		}
+		pc.args = append(pc.args, "--import", bootstrapModule)
+
+	case EmbeddedModWsgi:
+		// mod_wsgi adds its python path with site.addsitedir() and so processes `.pth` files
+		pth := filepath.Join(dir, bootstrapModule+".pth")
+		if err := ioutil.WriteFile(pth, []byte("import "+bootstrapModule+"\n"), 0644); err != nil {
+			return err
+		}
+		for _, p := range paths {
+			pc.args = append(pc.args, "--python-path", p)
+		}
+	}
+	switch {
+	case pc.connect != "":
+		logrus.Infof("%s python processes connect to the debugger at %s", pc.embedded, pc.connect)
+	case pc.portRange.isEmpty():
+		logrus.Infof("%s python processes listen for %s on port %d", pc.embedded, pc.debugMode, pc.port)
+	default:
+		logrus.Infof("%s python processes claim %s ports from %s; allocations are recorded in %q", pc.embedded, pc.debugMode, pc.portRange, portsDir())
+	}
+	return nil
+}
+
+// embeddedBootstrapScript returns the bootstrap module that starts the debugging backend.
+func (pc *pythonContext) embeddedBootstrapScript() string {
+	r := pc.portRange
+	if r.isEmpty() {
+		// the claim functions are defined but unused
+		r = portRange{first: pc.port, last: pc.port}
+	}
+	retry := 0
+	if pc.reloader != "" {
+		retry = reloadRetry
+	}
+	return "import base64\n" + portClaimReplacer(r,
+		"{mode}", pc.debugMode,
+		"{port}", strconv.Itoa(int(pc.port)),
+		"{wait}", pythonBool(pc.wait),
+		"{subprocess}", pythonBool(pc.subprocess),
+		"{range}", pythonBool(!pc.portRange.isEmpty()),
+		"{connect}", base64.StdEncoding.EncodeToString([]byte(pc.connectHost())),
+		"{retry}", strconv.Itoa(retry)).Replace(portClaimBootstrap+forkResetBootstrap+pc.waitBootstrapScript()+embeddedBootstrap)
+}
+
+// writeBootstrapModule writes out the bootstrap module and returns its directory.
+func (pc *pythonContext) writeBootstrapModule() (string, error) {
+	f, err := pc.writeScript("embedded*", bootstrapModule+".py", pc.embeddedBootstrapScript())
+	if err != nil {
+		return "", err
+	}
+	logrus.Debugf("wrote bootstrap module %q", f)
+	return filepath.Dir(f), nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEmbeddedServer(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"uwsgi", "--http", ":8000", "--wsgi-file", "app.py"}, EmbeddedUwsgi},
+		{[]string{"/usr/bin/uwsgi_python311", "--ini", "app.ini"}, EmbeddedUwsgi},
+		{[]string{"/usr/bin/python3", "/usr/local/bin/mod_wsgi-express", "start-server", "app.wsgi"}, EmbeddedModWsgi},
+		{[]string{"/usr/bin/python3", "/usr/local/bin/mod_wsgi-express", "install-module"}, ""},
+		{[]string{"python", "-m", "gunicorn", "app:app"}, ""},
+		{[]string{"gunicorn", "app:app"}, ""},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			if result := embeddedServer(test.args); result != test.expected {
+				t.Errorf("expected %q but got %q", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestUwsgiPythonPlugins(t *testing.T) {
+	dir := t.TempDir()
+	python3 := writeFile(t, dir, "plugins/python3_plugin.so", "")
+	python311 := writeFile(t, dir, "other/python311_plugin.so", "")
+	writeFile(t, dir, "plugins/router_cache_plugin.so", "")
+	oldPluginDirs := uwsgiPluginDirs
+	uwsgiPluginDirs = []string{filepath.Join(dir, "plugins")}
+	t.Cleanup(func() { uwsgiPluginDirs = oldPluginDirs })
+
+	tests := []struct {
+		description string
+		args        []string
+		expected    []string
+	}{
+		{"--plugin", []string{"uwsgi", "--plugin", "python3", "--http", ":8000"}, []string{python3}},
+		{"--plugins list", []string{"uwsgi", "--plugins=router_cache,python3"}, []string{python3}},
+		{"--plugins-dir", []string{"uwsgi", "--plugins-dir", filepath.Join(dir, "other"), "--need-plugin", "python311"}, []string{python311}},
+		{"plugin path", []string{"uwsgi", "--plugin", python311}, []string{python311}},
+		{"only python plugin", []string{"uwsgi", "--ini", "app.ini"}, []string{python3}},
+		{"ambiguous", []string{"uwsgi", "--plugins-dir", filepath.Join(dir, "other"), "--ini", "app.ini"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if diff := cmp.Diff(test.expected, uwsgiPythonPlugins(test.args)); diff != "" {
+				t.Errorf("plugins differ (-got, +want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestUwsgiInterpreter(t *testing.T) {
+	useEmptyDefaultPath(t)
+	oldPluginDirs := uwsgiPluginDirs
+	uwsgiPluginDirs = nil
+	t.Cleanup(func() { uwsgiPluginDirs = oldPluginDirs })
+
+	// uWSGI installed with pip into a virtual environment
+	venv := t.TempDir()
+	writeFile(t, venv, "pyvenv.cfg", "home = /usr/bin\nversion = 3.11.4\n")
+	writeFile(t, venv, "bin/python", "\x7fELF")
+	uwsgi := writeFile(t, venv, "bin/uwsgi", "\x7fELF")
+	info, err := uwsgiInterpreter(context.TODO(), uwsgi, []string{uwsgi}, env{})
+	if err != nil || info.version != "3.11.4" {
+		t.Errorf("expected 3.11.4 but got %q (%v)", info.version, err)
+	}
+
+	// a virtual environment configured with --home
+	uwsgi = writeFile(t, t.TempDir(), "uwsgi", "\x7fELF")
+	info, err = uwsgiInterpreter(context.TODO(), uwsgi, []string{uwsgi, "--home", venv}, env{})
+	if err != nil || info.version != "3.11.4" {
+		t.Errorf("expected 3.11.4 but got %q (%v)", info.version, err)
+	}
+
+	if info, err = uwsgiInterpreter(context.TODO(), uwsgi, []string{uwsgi}, env{}); err == nil {
+		t.Errorf("expected an error but got %q", info.version)
+	}
+}
+
+func TestPrepareEmbedded(t *testing.T) {
+	useEmptyDefaultPath(t)
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+	sitePackages := writeFile(t, dbgRoot, "python/lib/python3.11/site-packages/debugpy/__init__.py", "")
+	sitePackages = filepath.Dir(filepath.Dir(sitePackages))
+
+	venv := t.TempDir()
+	writeFile(t, venv, "pyvenv.cfg", "home = /usr/bin\nversion = 3.11.4\n")
+	uwsgi := writeFile(t, venv, "bin/uwsgi", "\x7fELF")
+	python := writeFile(t, venv, "bin/python3.11", "\x7fELF")
+	express := writeFile(t, venv, "bin/mod_wsgi-express", "#!"+python+"\n")
+
+	t.Run("uwsgi", func(t *testing.T) {
+		pc := pythonContext{debugMode: ModeDebugpy, port: 5678, args: []string{uwsgi, "--http", ":8000", "--wsgi-file", "app.py"}, env: env{}}
+		if !pc.prepare(context.TODO()) {
+			t.Fatal("expected uwsgi to be configured")
+		}
+		if len(pc.scripts) != 1 {
+			t.Fatalf("expected a bootstrap module to be written but got %v", pc.scripts)
+		}
+		expected := []string{uwsgi, "--http", ":8000", "--wsgi-file", "app.py", "--pythonpath", sitePackages, "--pythonpath", pc.scripts[0], "--import", "skaffold_debug"}
+		if diff := cmp.Diff(expected, pc.args); diff != "" {
+			t.Errorf("args differ (-got, +want): %s", diff)
+		}
+		if diff := cmp.Diff(env{}, pc.env); diff != "" {
+			t.Errorf("env differs (-got, +want): %s", diff)
+		}
+		bootstrap, err := ioutil.ReadFile(filepath.Join(pc.scripts[0], "skaffold_debug.py"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{"_skaffold_mode = 'debugpy'", "_skaffold_port = 5678", "_skaffold_range = False"} {
+			if !strings.Contains(string(bootstrap), s) {
+				t.Errorf("bootstrap should contain %q", s)
+			}
+		}
+	})
+
+	t.Run("mod_wsgi", func(t *testing.T) {
+		pc := pythonContext{debugMode: ModeDebugpy, port: 5678, args: []string{express, "start-server", "app.wsgi"}, env: env{}}
+		if !pc.prepare(context.TODO()) {
+			t.Fatal("expected mod_wsgi-express to be configured")
+		}
+		if len(pc.scripts) != 1 {
+			t.Fatalf("expected a bootstrap module to be written but got %v", pc.scripts)
+		}
+		expected := []string{python, express, "start-server", "app.wsgi", "--python-path", sitePackages, "--python-path", pc.scripts[0]}
+		if diff := cmp.Diff(expected, pc.args); diff != "" {
+			t.Errorf("args differ (-got, +want): %s", diff)
+		}
+		pth, err := ioutil.ReadFile(filepath.Join(pc.scripts[0], "skaffold_debug.pth"))
+		if err != nil || string(pth) != "import skaffold_debug\n" {
+			t.Errorf("unexpected .pth file %q (%v)", pth, err)
+		}
+	})
+}
@@ -55,6 +55,17 @@ func (e env) AppendFilepath(key string, path string) {
 	e[key] = v
 }
 
+// PrependFilepath prepends a path to a environment variable.
+func (e env) PrependFilepath(key string, path string) {
+	v := e[key]
+	if v != "" {
+		v = path + string(filepath.ListSeparator) + v
+	} else {
+		v = path
+	}
+	e[key] = v
+}
+
 func (e env) String() string {
 	var keys []string
 	for k, _ := range e {
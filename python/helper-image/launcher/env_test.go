@@ -20,6 +20,8 @@ import (
 	"path/filepath"
 	"sort"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestEnvAsPairs(t *testing.T) {
@@ -110,3 +112,26 @@ func TestEnvAppendFilepath(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvPrependFilepath(t *testing.T) {
+	tests := []struct {
+		description string
+		env         env
+		key         string
+		value       string
+		expected    env
+	}{
+		{"empty", env{}, "PYTHONPATH", "value", env{"PYTHONPATH": "value"}},
+		{"existing value", env{"PYTHONPATH": "other"}, "PYTHONPATH", "value", env{"PYTHONPATH": "value" + string(filepath.ListSeparator) + "other"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			result := test.env
+			result.PrependFilepath(test.key, test.value)
+			if diff := cmp.Diff(test.expected, result); diff != "" {
+				t.Errorf("env differs (-got, +want): %s", diff)
+			}
+		})
+	}
+}
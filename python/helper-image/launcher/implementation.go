@@ -0,0 +1,101 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ImplCPython string = "cpython"
+	ImplPyPy    string = "pypy"
+	ImplGraalPy string = "graalpy"
+)
+
+// interpreterPrefixes maps the interpreter file-name prefixes to their implementation.
+var interpreterPrefixes = map[string]string{
+	"python":  ImplCPython,
+	"pypy":    ImplPyPy,
+	"graalpy": ImplGraalPy,
+}
+
+// supportedModes lists the debug modes that work with each implementation.  ptvsd
+// predates PyPy 3 support, and GraalPy only provides debugpy compatibility.
+var supportedModes = map[string][]string{
+	ImplCPython: {ModeDebugpy, ModePtvsd, ModePydevd, ModePydevdPycharm},
+	ImplPyPy:    {ModeDebugpy, ModePydevd, ModePydevdPycharm},
+	ImplGraalPy: {ModeDebugpy},
+}
+
+// isPythonInterpreter returns true if the file name looks like a python interpreter,
+// like `python3`, `pypy3.10`, or `graalpy`.
+func isPythonInterpreter(p string) bool {
+	return implementationFromName(p) != ""
+}
+
+// implementationFromName returns the implementation suggested by the interpreter's
+// file name, or "" if it does not look like a python interpreter.
+func implementationFromName(p string) string {
+	base := filepath.Base(p)
+	for prefix, impl := range interpreterPrefixes {
+		if strings.HasPrefix(base, prefix) {
+			return impl
+		}
+	}
+	return ""
+}
+
+// implementationFromBanner returns the implementation described by a `python -V`
+// banner or the `implementation` value of a `pyvenv.cfg`.  PyPy and GraalPy report
+// themselves as such; anything else is assumed to be CPython.
+func implementationFromBanner(banner string) string {
+	switch lower := strings.ToLower(banner); {
+	case strings.Contains(lower, "pypy"):
+		return ImplPyPy
+	case strings.Contains(lower, "graalpy"):
+		return ImplGraalPy
+	default:
+		return ImplCPython
+	}
+}
+
+// implementationLibDir returns the name of the library directory for the given
+// implementation and version, like `python3.11` or `pypy3.10`.
+func implementationLibDir(impl string, v pythonVersion) string {
+	switch impl {
+	case ImplPyPy, ImplGraalPy:
+		return fmt.Sprintf("%s%d.%d", impl, v.major, v.minor)
+	default:
+		return v.libDir()
+	}
+}
+
+// checkModeSupported returns an error if the debug mode cannot work with the implementation.
+func checkModeSupported(impl, mode string) error {
+	modes, found := supportedModes[impl]
+	if !found {
+		return fmt.Errorf("unknown python implementation %q", impl)
+	}
+	for _, m := range modes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not supported with %s: use one of %s", mode, impl, strings.Join(modes, ", "))
+}
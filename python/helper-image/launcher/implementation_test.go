@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestImplementationFromName(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"python", "cpython"},
+		{"/usr/bin/python3.11", "cpython"},
+		{"/opt/pypy/bin/pypy3", "pypy"},
+		{"graalpy", "graalpy"},
+		{"gunicorn", ""},
+		{"/usr/bin/env", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			if result := implementationFromName(test.path); result != test.expected {
+				t.Errorf("expected %q but got %q", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestImplementationFromBanner(t *testing.T) {
+	tests := []struct {
+		banner   string
+		expected string
+	}{
+		{"Python 3.11.4", "cpython"},
+		{"Python 3.10.13 (f1607341da97, Sep 28 2023, 05:41:26)\n[PyPy 7.3.13 with GCC 10.2.1 20210110]", "pypy"},
+		{"GraalPy 3.10.8 (Oracle GraalVM Native 23.1.0)", "graalpy"},
+		{"CPython", "cpython"},
+		{"PyPy", "pypy"},
+	}
+	for _, test := range tests {
+		t.Run(test.banner, func(t *testing.T) {
+			if result := implementationFromBanner(test.banner); result != test.expected {
+				t.Errorf("expected %q but got %q", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestImplementationLibDir(t *testing.T) {
+	tests := []struct {
+		impl     string
+		version  pythonVersion
+		expected string
+	}{
+		{"cpython", pythonVersion{major: 3, minor: 11, patch: 2}, "python3.11"},
+		{"cpython", pythonVersion{major: 3, minor: 13, abiFlags: "t"}, "python3.13t"},
+		{"pypy", pythonVersion{major: 3, minor: 10, patch: 13}, "pypy3.10"},
+		{"graalpy", pythonVersion{major: 3, minor: 10, patch: 8}, "graalpy3.10"},
+	}
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			if result := implementationLibDir(test.impl, test.version); result != test.expected {
+				t.Errorf("expected %q but got %q", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestCheckModeSupported(t *testing.T) {
+	tests := []struct {
+		impl      string
+		mode      string
+		shouldErr bool
+	}{
+		{"cpython", "debugpy", false},
+		{"cpython", "ptvsd", false},
+		{"cpython", "pydevd-pycharm", false},
+		{"pypy", "debugpy", false},
+		{"pypy", "pydevd", false},
+		{"pypy", "ptvsd", true},
+		{"graalpy", "debugpy", false},
+		{"graalpy", "pydevd", true},
+		{"jython", "debugpy", true},
+	}
+	for _, test := range tests {
+		t.Run(test.impl+"/"+test.mode, func(t *testing.T) {
+			err := checkModeSupported(test.impl, test.mode)
+			if test.shouldErr && err == nil {
+				t.Error("expected an error")
+			} else if !test.shouldErr && err != nil {
+				t.Error("unexpected error:", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// injectedMarker is the environment variable set by the first python process to start the
+// debugging backend with injection, so that its child processes do not start another.
+const injectedMarker = "SKAFFOLD_DEBUG_INJECTED"
+
+// injectionHook is a `sitecustomize` module that python imports on startup from the
+// PYTHONPATH.  The hook imports the bootstrap module, which starts the debugging backend,
+// in the first python process or in the processes whose program, module, or script name
+// matches the pattern.  For a reloader, the backend is instead started in each serving
+// process started by the reloader, but not in the reloader itself.  The processes of the
+// debugging backends themselves, like debugpy's adapter, are ignored.  Any `sitecustomize`
+// module hidden by the hook is then imported.
+const injectionHook = `import base64
+import fnmatch
+import os
+import sys
+
+_skaffold_match = base64.b64decode('{match}').decode('utf-8')
+_skaffold_every = {every}
+_skaffold_reloader = {reloader}
+
+def _skaffold_command_line():
+    try:
+        with open('/proc/self/cmdline', 'rb') as f:
+            return [a.decode('utf-8', 'replace') for a in f.read().split(b'\0')[:-1]]
+    except (IOError, OSError):
+        return list(getattr(sys, 'orig_argv', None) or [sys.executable] + sys.argv)
+
+def _skaffold_names(args):
+    module = script = None
+    i = 1
+    while i < len(args) and module is None and script is None:
+        arg = args[i]
+        i += 1
+        if arg == '--':
+            script = args[i] if i < len(args) else None
+        elif arg.startswith('--'):
+            if arg == '--check-hash-based-pycs':
+                i += 1
+        elif arg.startswith('-') and arg != '-':
+            for j, c in enumerate(arg[1:]):
+                if c in 'cmWXQ':
+                    value = arg[j + 2:]
+                    if not value and i < len(args):
+                        value = args[i]
+                        i += 1
+                    if c == 'm':
+                        module = value
+                    elif c == 'c':
+                        script = '-c'
+                    break
+        else:
+            script = arg
+    names = [os.path.basename(args[0])] if args else []
+    if module:
+        names.append(module)
+    if script:
+        names.append(os.path.basename(script))
+    return names, module or script or ''
+
+def _skaffold_serving(args):
+    # Django and Werkzeug mark the reloader's child in its environment, and uvicorn and
+    # watchfiles spawn the child with multiprocessing
+    return (os.environ.get('RUN_MAIN') == 'true' or os.environ.get('WERKZEUG_RUN_MAIN') == 'true'
+            or '--multiprocessing-fork' in args)
+
+def _skaffold_selected():
+    if not _skaffold_every and os.environ.get('{marker}'):
+        return False
+    args = _skaffold_command_line()
+    if _skaffold_reloader and not _skaffold_serving(args):
+        return False
+    names, target = _skaffold_names(args)
+    for backend in ('debugpy', 'ptvsd', 'pydevd'):
+        if target.split('.')[0] == backend or (os.sep + backend + os.sep) in target:
+            return False
+    if _skaffold_match:
+        return any(fnmatch.fnmatchcase(name, _skaffold_match) for name in names)
+    return True
+
+def _skaffold_chain():
+    # import the sitecustomize module, if any, that this module hides
+    here = os.path.dirname(os.path.abspath(__file__))
+    module = sys.modules.pop('sitecustomize', None)
+    path = sys.path[:]
+    sys.path[:] = [p for p in path if os.path.abspath(p or os.curdir) != here]
+    try:
+        import sitecustomize
+    except ImportError:
+        if module is not None:
+            sys.modules['sitecustomize'] = module
+    except Exception as e:
+        sys.stderr.write('skaffold: error in sitecustomize: %s\n' % e)
+    finally:
+        sys.path[:] = path
+
+_skaffold_chain()
+if _skaffold_selected():
+    os.environ['{marker}'] = str(os.getpid())
+    import {module}
+`
+
+// injectionInterpreter determines the version and implementation of the python interpreter
+// without rewriting the command-line: the python run by the command-line, if it can be
+// determined, or otherwise the python found on the PATH.
+func (pc *pythonContext) injectionInterpreter(ctx context.Context) error {
+	probe := pythonContext{args: append([]string(nil), pc.args...), env: pc.env}
+	python := "python3"
+	var bypass *bypassError
+	if err := probe.unwrapLauncher(ctx); err == nil && isPythonInterpreter(probe.args[0]) {
+		python = probe.args[0]
+		if cl, err := parsePythonCommandLine(probe.args); err == nil && cl.ignoredEnvironmentOption() != "" {
+			logrus.Warnf("python ignores PYTHONPATH with %s and so the hook is not imported: use --inject=false", cl.ignoredEnvironmentOption())
+		}
+	} else if errors.As(err, &bypass) && bypass.python != "" {
+		// the runner's environment
+		python = bypass.python
+	} else if p, err := lookPath("python3", pc.env); err == nil {
+		python = p
+	} else if p, err := lookPath("python", pc.env); err == nil {
+		python = p
+	}
+	logrus.Debugf("determining the injected python from %q", python)
+	version, impl, err := determineInterpreter(ctx, python, probe.env)
+	pc.version = version
+	pc.implementation = impl
+	return err
+}
+
+// updateInjectionEnv writes out the injection hook and the bootstrap module and adds them
+// to the PYTHONPATH, ahead of any user-configured values so that the hook is imported.
+func (pc *pythonContext) updateInjectionEnv() error {
+	if pc.env == nil {
+		pc.env = env{}
+	}
+	libraryPath, err := pc.bundledLibraryPath()
+	if err != nil {
+		return err
+	}
+	dir, err := pc.writeBootstrapModule()
+	if err != nil {
+		return err
+	}
+	hook := filepath.Join(dir, "sitecustomize.py")
+	if err := ioutil.WriteFile(hook, []byte(pc.injectionHookScript()), 0644); err != nil {
+		return err
+	}
+	// a marker inherited from an earlier launch would prevent the backend from starting
+	delete(pc.env, injectedMarker)
+	pc.env.PrependFilepath("PYTHONPATH", dir)
+	if libraryPath != "" {
+		pc.env.AppendFilepath("PYTHONPATH", libraryPath)
+	}
+
+	target := "the first python process"
+	switch {
+	case pc.reloader != "":
+		target = "each serving process of the " + pc.reloader + " reloader"
+	case pc.injectMatch != "":
+		target = "python processes matching " + pc.injectMatch
+	}
+	switch {
+	case pc.connect != "":
+		logrus.Infof("injected %s into %s to connect to the debugger at %s", pc.debugMode, target, pc.connect)
+	case pc.portRange.isEmpty():
+		logrus.Infof("injected %s into %s to listen on port %d", pc.debugMode, target, pc.port)
+	default:
+		logrus.Infof("injected %s into %s to claim ports from %s; allocations are recorded in %q", pc.debugMode, target, pc.portRange, portsDir())
+	}
+	return nil
+}
+
+// injectionHookScript returns the `sitecustomize` module that imports the bootstrap module.
+// With a port range, every matching process starts the backend on a port of its own.
+func (pc *pythonContext) injectionHookScript() string {
+	match := pc.injectMatch
+	if pc.reloader != "" {
+		// the reloader determines the serving processes
+		match = ""
+	}
+	return strings.NewReplacer(
+		"{match}", base64.StdEncoding.EncodeToString([]byte(match)),
+		"{every}", pythonBool(!pc.portRange.isEmpty()),
+		"{reloader}", pythonBool(pc.reloader != ""),
+		"{marker}", injectedMarker,
+		"{module}", bootstrapModule).Replace(injectionHook)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPrepareInjection(t *testing.T) {
+	useEmptyDefaultPath(t)
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+	sitePackages := writeFile(t, dbgRoot, "python/lib/python3.11/site-packages/debugpy/__init__.py", "")
+	sitePackages = filepath.Dir(filepath.Dir(sitePackages))
+
+	venv := t.TempDir()
+	writeFile(t, venv, "pyvenv.cfg", "home = /usr/bin\nversion = 3.11.4\n")
+	python := writeFile(t, venv, "bin/python3", "\x7fELF")
+	supervisord := writeFile(t, venv, "bin/supervisord", "#!"+python+"\n")
+	runsvdir := writeFile(t, t.TempDir(), "runsvdir", "\x7fELF")
+	// conda cannot be bypassed when activating the environment runs scripts
+	writeFile(t, venv, "etc/conda/activate.d/env.sh", "")
+	conda := writeFile(t, t.TempDir(), "conda", "\x7fELF")
+
+	tests := []struct {
+		description string
+		pc          pythonContext
+		expected    []string
+	}{
+		{
+			description: "python script",
+			pc:          pythonContext{debugMode: ModeDebugpy, port: 5678, inject: true, args: []string{supervisord, "-n", "-c", "/etc/supervisord.conf"}, env: env{injectedMarker: "1"}},
+			expected:    []string{"_skaffold_match = base64.b64decode('').decode('utf-8')", "_skaffold_every = False", "import skaffold_debug"},
+		},
+		{
+			description: "program with python on the PATH",
+			pc:          pythonContext{debugMode: ModeDebugpy, port: 5678, inject: true, injectMatch: "gunicorn", portRange: portRange{5678, 5687}, args: []string{runsvdir, "/etc/service"}, env: env{"PATH": filepath.Dir(python)}},
+			expected:    []string{"_skaffold_match = base64.b64decode('Z3VuaWNvcm4=').decode('utf-8')", "_skaffold_every = True"},
+		},
+		{
+			description: "runner that cannot be bypassed",
+			pc:          pythonContext{debugMode: ModeDebugpy, port: 5678, args: []string{conda, "run", "-p", venv, "gunicorn", "app:app"}, env: env{}},
+			expected:    []string{"_skaffold_match = base64.b64decode('Z3VuaWNvcm4=').decode('utf-8')"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			pc := test.pc
+			args := append([]string(nil), pc.args...)
+			if !pc.prepare(context.TODO()) {
+				t.Fatal("expected injection to be configured")
+			}
+			if diff := cmp.Diff(args, pc.args); diff != "" {
+				t.Errorf("args should be unchanged (-got, +want): %s", diff)
+			}
+			if pc.version.String() != "3.11.4" {
+				t.Errorf("expected python 3.11.4 but got %v", pc.version)
+			}
+			if len(pc.scripts) != 1 {
+				t.Fatalf("expected the hook to be written but got %v", pc.scripts)
+			}
+			if expected := pc.scripts[0] + string(filepath.ListSeparator) + sitePackages; pc.env["PYTHONPATH"] != expected {
+				t.Errorf("expected PYTHONPATH %q but got %q", expected, pc.env["PYTHONPATH"])
+			}
+			if _, found := pc.env[injectedMarker]; found {
+				t.Error("injection marker should be removed")
+			}
+			if _, err := ioutil.ReadFile(filepath.Join(pc.scripts[0], "skaffold_debug.py")); err != nil {
+				t.Error("bootstrap module not written:", err)
+			}
+			hook, err := ioutil.ReadFile(filepath.Join(pc.scripts[0], "sitecustomize.py"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range test.expected {
+				if !strings.Contains(string(hook), s) {
+					t.Errorf("hook should contain %q", s)
+				}
+			}
+		})
+	}
+}
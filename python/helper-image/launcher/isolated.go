@@ -0,0 +1,94 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pathBootstrap is a script that appends the bundled backend to `sys.path` and then runs
+// the remainder of its command-line, either `-m module args...` or `script args...`, as
+// python would have.  The script is used when python ignores the PYTHONPATH.  Unless
+// python is run with `-I` or `-P`, python puts the script's own directory first on the
+// path, which is replaced by the current directory for a module, or by the directory
+// of the target script.
+const pathBootstrap = `import base64
+import os
+import runpy
+import sys
+
+sys.path.append(base64.b64decode('{path}').decode('utf-8'))
+if not (sys.flags.isolated or getattr(sys.flags, 'safe_path', False)):
+    if sys.argv[1] == '-m':
+        sys.path[0] = os.getcwd()
+    elif os.path.isfile(sys.argv[1]):
+        sys.path[0] = os.path.dirname(os.path.realpath(sys.argv[1]))
+    else:
+        # runpy puts a directory or zip archive first on the path itself
+        del sys.path[0]
+del sys.argv[0]
+if sys.argv[0] == '-m':
+    del sys.argv[0]
+    runpy.run_module(sys.argv[0], run_name='__main__', alter_sys=True)
+else:
+    runpy.run_path(sys.argv[0], run_name='__main__')
+`
+
+// ignoredEnvironmentOption returns the interpreter option, `-E` or `-I`, with which python
+// ignores PYTHON* environment variables like PYTHONPATH and PYTHONHOME, or "" if none.
+func (cl pythonCommandLine) ignoredEnvironmentOption() string {
+	for i := 0; i < len(cl.options); i++ {
+		option := cl.options[i]
+		switch {
+		case option == "-E" || option == "-I":
+			return option
+		case pythonLongOptions[option], len(option) == 2 && strings.IndexByte(pythonFlagsWithArg, option[1]) >= 0:
+			i++ // skip the argument
+		}
+	}
+	return ""
+}
+
+// updateIsolatedCommandLine has the backend found through a bootstrap script when python is
+// run with `-E` or `-I` and so does not see the bundled backend on the PYTHONPATH.
+func (pc *pythonContext) updateIsolatedCommandLine() error {
+	cl, err := parsePythonCommandLine(pc.args)
+	if err != nil {
+		return err
+	}
+	option := cl.ignoredEnvironmentOption()
+	if option == "" {
+		return nil
+	}
+	libraryPath, err := pc.bundledLibraryPath()
+	if err != nil || libraryPath == "" {
+		return err
+	}
+	script := strings.Replace(pathBootstrap, "{path}", base64.StdEncoding.EncodeToString([]byte(libraryPath)), 1)
+	f, err := pc.writeScript("isolated*", "skaffold_path.py", script)
+	if err != nil {
+		return err
+	}
+	logrus.Warnf("python ignores PYTHONPATH with %s: %s is instead added to sys.path by %q", option, pc.debugMode, f)
+	cmdline := append(cl.interpreterArgs(), f)
+	cmdline = append(cmdline, cl.targetArgs()...)
+	pc.args = append(cmdline, cl.args...)
+	return nil
+}
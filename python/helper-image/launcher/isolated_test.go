@@ -0,0 +1,166 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIgnoredEnvironmentOption(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"python", "app.py"}, ""},
+		{[]string{"python", "-E", "app.py"}, "-E"},
+		{[]string{"python", "-Es", "app.py"}, "-E"},
+		{[]string{"python", "-u", "-I", "-m", "gunicorn"}, "-I"},
+		{[]string{"python", "-X", "dev", "-I", "app.py"}, "-I"},
+		{[]string{"python", "-W", "ignore", "-c", "print('-E')"}, ""},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			cl, err := parsePythonCommandLine(test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result := cl.ignoredEnvironmentOption(); result != test.expected {
+				t.Errorf("expected %q but got %q", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestUpdateIsolatedCommandLine(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+	sitePackages := writeFile(t, dbgRoot, "python/lib/python3.11/site-packages/debugpy/__init__.py", "")
+	sitePackages = filepath.Dir(filepath.Dir(sitePackages))
+	script := filepath.Join(dbgRoot, "python", "tmp", "isolated*", "skaffold_path.py")
+
+	tests := []struct {
+		description string
+		args        []string
+		env         env
+		expected    []string // the bootstrap script is inserted at the element ""
+	}{
+		{
+			description: "not isolated",
+			args:        []string{"python", "-m", "debugpy", "--listen", "5678", "app.py"},
+			expected:    []string{"python", "-m", "debugpy", "--listen", "5678", "app.py"},
+		},
+		{
+			description: "-I module",
+			args:        []string{"python", "-I", "-m", "debugpy", "--listen", "5678", "-m", "gunicorn"},
+			expected:    []string{"python", "-I", "", "-m", "debugpy", "--listen", "5678", "-m", "gunicorn"},
+		},
+		{
+			description: "-E script",
+			args:        []string{"python", "-uE", "/tmp/skaffold_pydevd_child_ports.py", "--server", "--port", "5678"},
+			expected:    []string{"python", "-u", "-E", "", "/tmp/skaffold_pydevd_child_ports.py", "--server", "--port", "5678"},
+		},
+		{
+			description: "skip env",
+			args:        []string{"python", "-I", "-m", "debugpy", "--listen", "5678", "app.py"},
+			env:         env{"WRAPPER_SKIP_ENV": "true"},
+			expected:    []string{"python", "-I", "-m", "debugpy", "--listen", "5678", "app.py"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			pc := pythonContext{debugMode: ModeDebugpy, version: pythonVersion{major: 3, minor: 11}, implementation: ImplCPython, args: test.args, env: test.env}
+			if err := pc.updateIsolatedCommandLine(); err != nil {
+				t.Fatal(err)
+			}
+			expected := append([]string(nil), test.expected...)
+			for i, arg := range expected {
+				if arg == "" && i < len(pc.args) && fileMatch(t, script, pc.args[i]) {
+					expected[i] = pc.args[i]
+				}
+			}
+			if diff := cmp.Diff(expected, pc.args); diff != "" {
+				t.Errorf("args differ (-got, +want): %s", diff)
+			}
+			if len(pc.scripts) == 0 {
+				return
+			}
+			bootstrap, err := ioutil.ReadFile(filepath.Join(pc.scripts[0], "skaffold_path.py"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoded := base64.StdEncoding.EncodeToString([]byte(sitePackages)); !strings.Contains(string(bootstrap), encoded) {
+				t.Errorf("bootstrap should add %q to sys.path", sitePackages)
+			}
+		})
+	}
+}
+
+func TestPathBootstrap(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not found")
+	}
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+	// a stand-in for pydevd, which runs `--module --file module` without changing sys.path
+	writeFile(t, dbgRoot, "python/pydevd/python3.11/lib/python3.11/site-packages/pydevd.py", `import runpy
+import sys
+i = sys.argv.index('--file')
+if '--module' in sys.argv:
+    runpy.run_module(sys.argv[i + 1], run_name='__main__', alter_sys=True)
+else:
+    runpy.run_path(sys.argv[i + 1], run_name='__main__')
+`)
+	app := t.TempDir()
+	writeFile(t, app, "localpkg/__main__.py", "print('module ok')\n")
+	writeFile(t, app, "helper.py", "")
+	writeFile(t, app, "app.py", "import helper\nprint('script ok')\n")
+
+	tests := []struct {
+		description string
+		args        []string
+		dir         string
+		expected    string
+	}{
+		{"-E -m under pydevd", []string{python, "-E", "-m", "pydevd", "--server", "--port", "5678", "--continue", "--module", "--file", "localpkg"}, app, "module ok\n"},
+		{"-E script", []string{python, "-E", filepath.Join(app, "app.py")}, t.TempDir(), "script ok\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			pc := pythonContext{debugMode: ModePydevd, version: pythonVersion{major: 3, minor: 11}, implementation: ImplCPython, args: test.args}
+			if err := pc.updateIsolatedCommandLine(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(pc.removeScripts)
+			cmd := exec.Command(pc.args[0], pc.args[1:]...)
+			cmd.Dir = test.dir
+			out, err := cmd.CombinedOutput()
+			if err != nil || string(out) != test.expected {
+				t.Errorf("expected %q but got %q (%v)", test.expected, out, err)
+			}
+		})
+	}
+}
@@ -41,33 +41,32 @@ limitations under the License.
 //
 // This launcher is expected to be invoked as follows:
 //
-//	launcher --mode <pydevd|pydevd-pycharm|debugpy|ptvsd> \
-//	    --port p [--wait] -- original-command-line ...
+//	launcher --mode <pydevd|pydevd-pycharm|debugpy|ptvsd|auto> \
+//	    --port p [--wait [--wait-timeout seconds] \
+//	    [--wait-timeout-action continue|exit]] [--subprocess] \
+//	    [--port-range first-last] [--connect host:port] \
+//	    [--inject [--inject-match pattern]] [--status-address addr] \
+//	    [--config file] [--print-config] -- original-command-line ...
 //
 // This launcher determines the python executable based on
-// `original-command-line`, unwrapping any python scripts, and
-// configures the debugging back-end.
+// `original-command-line`, unwrapping any python scripts, `env`
+// invocations, shell wrappers, version-manager shims, and
+// package-manager runners, and configures the debugging back-end.
 // The launcher configures the PYTHONPATH to point to the appropriate
 // installation pydevd/debugpy/ptvsd for the corresponding python binary.
 //
 // debugpy and ptvsd are pretty straightforward translations of the
 // launcher command-line `python -m debugpy`.
 //
-// pydevd is more involved as pydevd does not support loading modules
-// from the command-line (e.g., `python -m flask`).  This launcher
-// instead creates a small module-loader script using runpy.
+// pydevd is more involved as pydevd only launches files with `--file`,
+// which must be its last argument.  Modules are launched with pydevd's
+// `--module` option, which treats the `--file` argument as a module name.
 // So `launcher --mode pydevd --port 5678 -- python -m flask app.py`
-// will create a temp file named `skaffold_pydevd_launch.py`:
-// ```
-// import sys
-// import runpy
-// runpy.run_module('flask', run_name="__main__",alter_sys=True)
-// ```
-// and will then invoke:
+// will invoke:
 // ```
 //
 //	python -m pydevd --server --port 5678 --continue \
-//	  --file /tmp/pydevd716531212/skaffold_pydevd_launch.py
+//	  --module --file flask app.py
 //
 // ```
 //
@@ -80,25 +79,32 @@ limitations under the License.
 //     to point to bundled debugging backends: this is useful if
 //     your app already includes `debugpy`.
 //   - Set `WRAPPER_PYTHON_VERSION=3.9` to avoid trying to determine
-//     the python version by executing `python -V`
+//     the python version.
+//   - Set `WRAPPER_PYTHON_IMPLEMENTATION` to one of `cpython`, `pypy`,
+//     or `graalpy` to override the detected python implementation.
+//     No backend is bundled for GraalPy.
+//   - Set `WRAPPER_IDE` to `vscode` or `pycharm` to guide `--mode auto`.
+//   - Set `WRAPPER_CONFIG` to the location of a configuration file.
 //   - Set `WRAPPER_VERBOSE` to one of `error`, `warn`, `info`, `debug`,
 //     or `trace` to reduce or increase the verbosity
+//
+// The Python Launcher section of the top-level README describes these
+// options in detail.
 package main
 
 import (
+	"archive/zip"
 	"context"
+	"encoding/base64"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 
-	shell "github.com/kballard/go-shellquote"
 	"github.com/sirupsen/logrus"
 )
 
@@ -113,6 +119,7 @@ const (
 	ModePtvsd         string = "ptvsd"
 	ModePydevd        string = "pydevd"
 	ModePydevdPycharm string = "pydevd-pycharm"
+	ModeAuto          string = "auto"
 )
 
 // pythonContext represents the launch context.
@@ -120,11 +127,48 @@ type pythonContext struct {
 	debugMode string
 	port      uint
 	wait      bool
+	// waitTimeout, if not 0, is the longest time in seconds to wait for a debugger with wait
+	waitTimeout uint
+	// waitTimeoutAction is what the app does when no debugger attaches within the wait timeout
+	waitTimeoutAction string
+	// subprocess, if true, has child python processes debugged too
+	subprocess bool
+	// portRange, if not empty, has each debugged process claim a port from the range
+	portRange portRange
+	// connect, if set, is the `host:port` of a debugger to connect to rather than listening
+	connect string
+	// statusAddress, if set, is the address at which to serve the launch status
+	statusAddress string
+	// embedded is the server that embeds python, like uwsgi, or "" if python is run directly
+	embedded string
+	// inject, if true, starts the backend from a `sitecustomize` hook rather than rewriting the command-line
+	inject bool
+	// injectMatch, if set, is a pattern for the python processes to debug with inject; otherwise the first
+	injectMatch string
+	// reloader is the development server reloader, like django, whose serving processes are debugged
+	reloader string
 
 	args []string
 	env  env
 
-	major, minor int // python version
+	version        pythonVersion
+	implementation string
+
+	// modeReason records why the debug mode was chosen with `--mode auto`
+	modeReason string
+	// originalArgs is the command-line as provided to the launcher
+	originalArgs []string
+	// delegated is true if the app is launched through a nested launcher, as for shell commands
+	delegated bool
+
+	// configFile is the location of the configuration file read, if any
+	configFile string
+	// flags records the flags that were explicitly set
+	flags map[string]bool
+	// configEnv are the environment variables set from the configuration file
+	configEnv []string
+	// scripts are the directories of launch scripts written for the app, removed when the app exits
+	scripts []string
 }
 
 func main() {
@@ -135,53 +179,96 @@ func main() {
 
 	pc := pythonContext{env: env}
 	flag.StringVar(&dbgRoot, "helpers", "/dbg", "base location for skaffold-debug helpers")
-	flag.StringVar(&pc.debugMode, "mode", "", "debugger mode: debugpy, ptvsd, pydevd, pydevd-pycharm")
+	flag.StringVar(&pc.configFile, "config", "", "configuration file (default $WRAPPER_CONFIG or <helpers>/python/launcher.json)")
+	flag.StringVar(&pc.debugMode, "mode", "", "debugger mode: debugpy, ptvsd, pydevd, pydevd-pycharm, auto")
 	flag.UintVar(&pc.port, "port", 9999, "port to listen for remote debug connections")
 	flag.BoolVar(&pc.wait, "wait", false, "wait for debugger connection on start")
+	flag.UintVar(&pc.waitTimeout, "wait-timeout", 0, "with --wait, the longest time in seconds to wait for a debugger connection (default 0 waits indefinitely)")
+	flag.StringVar(&pc.waitTimeoutAction, "wait-timeout-action", WaitTimeoutContinue, "what the app does when no debugger connects within the wait timeout: continue, exit")
+	flag.BoolVar(&pc.subprocess, "subprocess", false, "debug child python processes too, such as multiprocessing workers")
+	flag.StringVar(&pc.connect, "connect", "", "host:port of a debugger to connect to rather than listening for connections")
+	flag.BoolVar(&pc.inject, "inject", false, "start the backend from a sitecustomize hook on the PYTHONPATH rather than rewriting the command-line")
+	flag.StringVar(&pc.injectMatch, "inject-match", "", "with --inject, the pattern for the program, module, or script name of the python processes to debug (default the first python process)")
+	flag.StringVar(&pc.statusAddress, "status-address", "", "address (e.g., :5680) at which to serve the launch status as JSON; the launcher then supervises the app")
+	portRangeFlag := flag.String("port-range", "", "range of ports (first-last) from which each python process, including forked workers, claims a port (debugpy only)")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration as JSON and exit")
 
 	flag.Parse()
+	// flags take precedence over the environment, which takes precedence over the configuration file
+	cfg, location, err := readConfig(pc.configFile, env)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	pc.configFile = location
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	portRangeValue := pc.applyConfig(cfg.forCommand(flag.Args()), explicit, *portRangeFlag)
+	logrus.SetLevel(logrusLevel(env))
+
+	if portRangeValue != "" {
+		r, err := parsePortRange(portRangeValue)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		pc.portRange = r
+		pc.port = r.first
+	}
+	if pc.connect != "" {
+		_, port, err := parseConnectAddress(pc.connect)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if !pc.portRange.isEmpty() {
+			logrus.Fatal("cannot connect to a debugger with a port range")
+		}
+		pc.port = port
+	}
+	if *printConfig {
+		if err := pc.printConfig(); err != nil {
+			logrus.Fatal(err)
+		}
+		os.Exit(0)
+	}
 	if err := validateDebugMode(pc.debugMode); err != nil {
 		logrus.Fatal(err)
 	}
+	if err := validateWaitTimeoutAction(pc.waitTimeoutAction); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := validateWaitTimeout(pc.waitTimeout, pc.connect); err != nil {
+		logrus.Fatal(err)
+	}
 
 	if len(flag.Args()) == 0 {
 		logrus.Fatal("expected python command-line args")
 	}
+	// unwrapLauncher may rewrite the command-line in place
+	pc.originalArgs = append([]string(nil), flag.Args()...)
 	pc.args = flag.Args()
 	logrus.Debug("app command-line: ", pc.args)
 
-	if !pc.prepare(ctx) {
-		logrus.Info("launching original command: ", flag.Args())
-		cmd := newConsoleCommand(ctx, flag.Args(), env)
-		run(cmd)
-	} else {
-		pc.launch(ctx)
+	configured := pc.prepare(ctx)
+	pc.unsetConfigEnv()
+	if !configured {
+		pc.removeScripts()
+		logrus.Info("launching original command: ", pc.originalArgs)
+		pc.args = pc.originalArgs
+		pc.env = env
 	}
+	pc.launch(ctx, configured)
 	// NOTREACHED
 }
 
 // validateDebugMode ensures the provided mode is a supported mode.
 func validateDebugMode(mode string) error {
 	switch mode {
-	case ModeDebugpy, ModePtvsd, ModePydevd, ModePydevdPycharm:
+	case ModeDebugpy, ModePtvsd, ModePydevd, ModePydevdPycharm, ModeAuto:
 		return nil
 	default:
-		return fmt.Errorf("unknown debugger mode %q; expecting one of %v", mode, []string{ModeDebugpy, ModePtvsd, ModePydevd, ModePydevdPycharm})
+		return fmt.Errorf("unknown debugger mode %q; expecting one of %v", mode, []string{ModeDebugpy, ModePtvsd, ModePydevd, ModePydevdPycharm, ModeAuto})
 	}
 }
 
-func run(cmd commander) {
-	if err := cmd.Run(); err != nil {
-		var ee exec.ExitError
-		if errors.Is(err, &ee) {
-			os.Exit(ee.ExitCode())
-		}
-		logrus.Fatal("error launching python debugging: ", err)
-	}
-	os.Exit(0)
-	// NOTREACHED
-}
-
 // prepare sets up the debugging command line.  Return true if successful or false if setup could not be completed.
 func (pc *pythonContext) prepare(ctx context.Context) bool {
 	if !isEnabled(pc.env) {
@@ -193,15 +280,95 @@ func (pc *pythonContext) prepare(ctx context.Context) bool {
 		return false
 	}
 
-	// rewrite the command-line by expanding script shebangs to run python and launch the app
-	if err := pc.unwrapLauncher(ctx); err != nil {
-		logrus.Warn("unable to determine launcher: ", err)
-		return false
+	var bypass *bypassError
+	if pc.inject {
+		// the command-line is left untouched
+		if err := pc.injectionInterpreter(ctx); err != nil {
+			logrus.Warn("unable to determine python for injection: ", err)
+			return false
+		}
+	} else if err := pc.unwrapLauncher(ctx); errors.As(err, &bypass) {
+		// the runner is left to run its command, which is debugged from the injection hook
+		logrus.Warnf("%v: debugging %q through injection instead", err, bypass.match)
+		pc.inject = true
+		if pc.injectMatch == "" {
+			pc.injectMatch = bypass.match
+		}
+		if err := pc.injectionInterpreter(ctx); err != nil {
+			logrus.Warn("unable to determine python for injection: ", err)
+			return false
+		}
+	} else {
+		// rewrite the command-line by expanding script shebangs to run python and launch the app
+		if err != nil {
+			logrus.Warn("unable to determine launcher: ", err)
+			return false
+		}
+		if isShell(pc.args[0]) {
+			// have the shell launch the app command through this launcher
+			if err := pc.updateShellCommandLine(ctx); err != nil {
+				logrus.Warn("unable to configure shell command for debugging: ", err)
+				return false
+			}
+			pc.delegated = true
+			return true
+		}
+		if pc.embedded = embeddedServer(pc.args); pc.embedded != "" {
+			if err := pc.embeddedInterpreter(ctx); err != nil {
+				logrus.Warnf("unable to determine python embedded in %s: %v", pc.embedded, err)
+				return false
+			}
+		} else if err := pc.isPythonLauncher(ctx); err != nil {
+			logrus.Warn("not a python launcher: ", err)
+			return false
+		} else if pc.reloader = detectReloader(pc.args, pc.env); pc.reloader != "" {
+			logrus.Debugf("%s reloader re-executes the app: debugging the serving process", pc.reloader)
+		}
+	}
+	if pc.debugMode == ModeAuto {
+		mode, reason, err := pc.selectDebugMode()
+		if err != nil {
+			logrus.Warn("unable to select debugging backend: ", err)
+			return false
+		}
+		logrus.Infof("selected %s: %s", mode, reason)
+		pc.debugMode = mode
+		pc.modeReason = reason
 	}
-	if err := pc.isPythonLauncher(ctx); err != nil {
-		logrus.Warn("not a python launcher: ", err)
+	if err := checkModeSupported(pc.implementation, pc.debugMode); err != nil {
+		logrus.Warn("unable to debug: ", err)
 		return false
 	}
+	if !pc.portRange.isEmpty() {
+		if err := checkPortRangeSupported(pc.debugMode); err != nil {
+			logrus.Warn("unable to debug: ", err)
+			return false
+		}
+	}
+	if pc.connect != "" {
+		if err := pc.checkConnectSupported(); err != nil {
+			logrus.Warn("unable to debug: ", err)
+			return false
+		}
+	}
+	pc.logWaitTimeout()
+
+	if pc.inject || pc.reloader != "" {
+		// the reloader's serving process is configured through the injection hook
+		if err := pc.updateInjectionEnv(); err != nil {
+			logrus.Warn("unable to configure injection: ", err)
+			return false
+		}
+		return true
+	}
+	if pc.embedded != "" {
+		// the server is configured to start the backend in each of its python processes
+		if err := pc.updateEmbeddedCommandLine(); err != nil {
+			logrus.Warnf("unable to configure %s for debugging: %v", pc.embedded, err)
+			return false
+		}
+		return true
+	}
 
 	// set PYTHONPATH to point to the appropriate library for the given python version.
 	if err := pc.updateEnv(ctx); err != nil {
@@ -214,261 +381,519 @@ func (pc *pythonContext) prepare(ctx context.Context) bool {
 		logrus.Warn("unable to setup launcher: ", err)
 		return false
 	}
+	if err := pc.updateIsolatedCommandLine(); err != nil {
+		logrus.Warn("unable to configure isolated python: ", err)
+		return false
+	}
 	return true
 }
 
-func (pc *pythonContext) launch(ctx context.Context) {
+// launch runs the app, which is configured for debugging if configured is true.
+// If a status address is set then the launcher serves the status while supervising
+// the app.  A debug session descriptor is written while a configured app runs,
+// unless the app is launched through a nested launcher which writes its own.
+// The launcher otherwise replaces itself with the app, except when running as init
+// where it remains to reap orphaned processes, or when there are launch scripts to
+// remove once the app exits.
+func (pc *pythonContext) launch(ctx context.Context, configured bool) {
+	var status *statusServer
+	if pc.statusAddress != "" {
+		status = newStatusServer(pc, configured)
+		if err := status.serve(pc.statusAddress); err != nil {
+			logrus.Warn(err)
+			status = nil
+		}
+	}
+	describe := configured && !pc.delegated
+	if status == nil && !describe && len(pc.scripts) == 0 && getpid() != 1 {
+		err := execInPlace(pc.args, pc.env)
+		logrus.Debug("unable to exec in place: ", err)
+	}
 	cmd := newConsoleCommand(ctx, pc.args, pc.env)
-	run(cmd)
+	pc.supervise(cmd, status, describe)
 	// NOTREACHED
 }
 
 // alreadyConfigured tries to determine if the python command-line is already configured
 // for debugging.
 func (pc *pythonContext) alreadyConfigured() bool {
-	// TODO: consider handling `#!/usr/bin/env python` too, though `pip install` seems
-	// to hard-code the python location instead.
-	if filepath.Base(pc.args[0]) == "pydevd" {
+	args := pc.args
+	if filepath.Base(args[0]) == "env" {
+		ec, err := parseEnvCommand(args)
+		if err != nil {
+			return false
+		}
+		args = ec.command
+	}
+	if filepath.Base(args[0]) == "pydevd" {
 		logrus.Debug("already configured to use pydevd")
 		return true
 	}
-	if strings.HasPrefix(filepath.Base(pc.args[0]), "python") && len(pc.args) > 1 {
-		if (pc.args[1] == "-m" && len(pc.args) > 2 && pc.args[2] == "debugpy") || pc.args[1] == "-mdebugpy" {
-			logrus.Debug("already configured to use debugpy")
-			return true
-		}
-		if (pc.args[1] == "-m" && len(pc.args) > 2 && pc.args[2] == "ptvsd") || pc.args[1] == "-mptvsd" {
-			logrus.Debug("already configured to use ptvsd")
-			return true
-		}
-		if (pc.args[1] == "-m" && len(pc.args) > 2 && pc.args[2] == "pydevd") || pc.args[1] == "-mpydevd" {
-			logrus.Debug("already configured to use pydevd")
-			return true
-		}
+	if !isPythonInterpreter(args[0]) {
+		return false
+	}
+	cl, err := parsePythonCommandLine(args)
+	if err != nil || cl.kind != targetModule {
+		return false
+	}
+	switch cl.target {
+	case "debugpy", "ptvsd", "pydevd":
+		logrus.Debugf("already configured to use %s", cl.target)
+		return true
 	}
 	return false
 }
 
 // unwrapLauncher attempts to expand the command-line in the given script,
-// providing that it does not look like a `python` launcher.
+// providing that it does not look like a `python` launcher.  Script shebangs
+// and `env` invocations are unwrapped repeatedly, up to maxUnwrapDepth levels,
+// and `env` variable assignments are applied to the launch environment.
+// Version manager shims, including those for `python`, are resolved to the
+// program in the selected python installation.
 // TODO: Windows .cmd and .bat files?
-func (pc *pythonContext) unwrapLauncher(_ context.Context) error {
-	p := pc.args[0]
-
-	_, err := os.Stat(p)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return fmt.Errorf("could not access launcher %q: %w", p, err)
+func (pc *pythonContext) unwrapLauncher(ctx context.Context) error {
+	for depth := 0; depth < maxUnwrapDepth; depth++ {
+		p, err := lookPath(pc.args[0], pc.env)
+		if err == nil {
+			if m := shimManager(p); m != nil {
+				real, resolveErr := m.resolve(ctx, p, pc.env)
+				if resolveErr == nil {
+					logrus.Debugf("resolved %s shim %q to %q", m.name, p, real)
+					pc.args[0] = real
+					continue
+				}
+				if !isPythonInterpreter(pc.args[0]) {
+					return fmt.Errorf("could not resolve %s shim %q: %w", m.name, p, resolveErr)
+				}
+				// the python shim can still be used to launch python
+				logrus.Warnf("could not resolve %s shim %q: %v", m.name, p, resolveErr)
+			}
+		}
+		if isPythonInterpreter(pc.args[0]) {
+			logrus.Debugf("no further unwrapping required: launcher appears to be python: %q", pc.args[0])
+			return nil
 		}
-		// try looking through PATH
-		l, err := exec.LookPath(p)
 		if err != nil {
-			return fmt.Errorf("could not find launcher %q: %w", p, err)
+			return err
 		}
-		p = l
-	}
-	if strings.HasPrefix(filepath.Base(p), "python") {
-		logrus.Debugf("no further unwrapping required: launcher appears to be python: %q", p)
-		return nil
-	}
-	f, err := os.Open(p)
-	if err != nil {
-		return fmt.Errorf("could not open launcher %q: %w", p, err)
-	}
-	defer f.Close()
 
-	shebang := make([]byte, 1024)
-	if n, err := f.Read(shebang); err == io.EOF || n < 2 {
-		logrus.Debugf("%q has no shebang", p)
-		return nil
-	} else if err != nil {
-		return fmt.Errorf("error reading file header from %q: %w", p, err)
-	} else if string(shebang[0:2]) != "#!" {
-		logrus.Debugf("%q appears to be a binary", p)
-		return nil
-	}
-	cl := strings.SplitN(string(shebang[2:]), "\n", 2)[0]
-	logrus.Tracef("%q has shebang %q", p, cl)
-	s, err := shell.Split(cl)
-	if err != nil {
-		logrus.Warnf("%q shebang %q seems odd: %v", p, cl, err)
-		s = []string{cl}
+		if filepath.Base(p) == "env" {
+			ec, err := parseEnvCommand(pc.args)
+			if err != nil {
+				return fmt.Errorf("could not unwrap %q: %w", pc.args, err)
+			}
+			pc.env = ec.apply(pc.env)
+			pc.args = ec.command
+			logrus.Debugf("unwrapped env: %v", pc.args)
+			continue
+		}
+		if r := findRunner(p); r != nil {
+			if err := pc.bypassRunner(ctx, r, p); err != nil {
+				var bypass *bypassError
+				if errors.As(err, &bypass) {
+					return err
+				}
+				return fmt.Errorf("could not bypass %q: %w", pc.args, err)
+			}
+			continue
+		}
+
+		s, err := readShebang(p)
+		if err != nil {
+			return err
+		} else if s == nil {
+			logrus.Debugf("%q has no shebang", p)
+			return nil
+		}
+		logrus.Tracef("%q has shebang %q", p, s)
+		pc.args[0] = p // ensure script is full path if resolved in PATH
+		pc.args = append(s, pc.args...)
+		logrus.Debugf("expanded command-line: %q -> %v", p, pc.args)
 	}
-	pc.args[0] = p // ensure script is full path if resolved in PATH
-	pc.args = append(s, pc.args...)
-	logrus.Debugf("expanded command-line: %q -> %v", p, pc.args)
-	return nil
+	return fmt.Errorf("too many levels of launchers: %q", pc.args)
 }
 
 func (pc *pythonContext) isPythonLauncher(ctx context.Context) error {
-	major, minor, err := determinePythonMajorMinor(ctx, pc.args[0], pc.env)
-	pc.major = major
-	pc.minor = minor
+	version, impl, err := determineInterpreter(ctx, pc.args[0], pc.env)
+	pc.version = version
+	pc.implementation = impl
 	return err
 }
 
 func (pc *pythonContext) updateEnv(ctx context.Context) error {
+	if pc.env == nil {
+		pc.env = env{}
+	}
+	libraryPath, err := pc.bundledLibraryPath()
+	if err != nil || libraryPath == "" {
+		return err
+	}
+	// Append to ensure user-configured values are found first.
+	pc.env.AppendFilepath("PYTHONPATH", libraryPath)
+	return nil
+}
+
+// bundledLibraryPath returns the location of the bundled backend to be added to the python
+// path, or "" if the backend is not to be added.
+func (pc *pythonContext) bundledLibraryPath() (string, error) {
 	// Perhaps we should check PYTHONPATH or ~/.local to see if the user has already
 	// installed one of our supported debug libraries
 	if pc.env["WRAPPER_SKIP_ENV"] != "" {
 		logrus.Debug("Skipping environment configuration by request")
-		return nil
+		return "", nil
 	}
 
 	_, err := os.Stat(dbgRoot)
 	if err != nil {
 		if os.IsNotExist(err) {
 			logrus.Warnf("skaffold-debug helpers not found at %q", dbgRoot)
-			return nil
+			return "", nil
 		}
-		return fmt.Errorf("skaffold-debug helpers are inaccessible at %q: %w", dbgRoot, err)
+		return "", fmt.Errorf("skaffold-debug helpers are inaccessible at %q: %w", dbgRoot, err)
 	}
 
-	if pc.env == nil {
-		pc.env = env{}
+	libraryPath := pc.libraryPath(pc.debugMode)
+	if libraryPath != "" && !pathExists(libraryPath) {
+		if where := pc.findInstalledBackend(pc.debugMode); where != "" {
+			logrus.Debugf("%s is not bundled but is installed with the app at %q", pc.debugMode, where)
+			return "", nil
+		}
+		if pc.implementation == ImplGraalPy {
+			return "", fmt.Errorf("no debugging backend is bundled for graalpy: install %s with the app and set WRAPPER_SKIP_ENV=true", pc.debugMode)
+		}
+		return "", fmt.Errorf("%s for %s %s is not available at %q: set WRAPPER_SKIP_ENV=true if %s is installed with the app", pc.debugMode, pc.implementation, pc.version, libraryPath, pc.debugMode)
 	}
+	return libraryPath, nil
+}
+
+// libraryPath returns the location of the bundled backend for the given debug mode.
+func (pc *pythonContext) libraryPath(mode string) string {
 	// The skaffold-debug-python helper image places pydevd and debugpy in /dbg/python/lib/pythonM.N,
 	// but separates pydevd and pydevd-pycharm in separate directories to avoid possible leakage.
-	var libraryPath string
-	switch pc.debugMode {
+	// Free-threaded builds and other implementations use a separate layout (e.g., pythonM.Nt or pypyM.N).
+	libDir := implementationLibDir(pc.implementation, pc.version)
+	switch mode {
 	case ModePtvsd, ModeDebugpy:
-		libraryPath = fmt.Sprintf(dbgRoot+"/python/lib/python%d.%d/site-packages", pc.major, pc.minor)
-
+		return dbgRoot + "/python/lib/" + libDir + "/site-packages"
 	case ModePydevd:
-		libraryPath = fmt.Sprintf(dbgRoot+"/python/pydevd/python%d.%d/lib/python%d.%d/site-packages", pc.major, pc.minor, pc.major, pc.minor)
-
+		return dbgRoot + "/python/pydevd/" + libDir + "/lib/" + libDir + "/site-packages"
 	case ModePydevdPycharm:
-		libraryPath = fmt.Sprintf(dbgRoot+"/python/pydevd-pycharm/python%d.%d/lib/python%d.%d/site-packages", pc.major, pc.minor, pc.major, pc.minor)
+		return dbgRoot + "/python/pydevd-pycharm/" + libDir + "/lib/" + libDir + "/site-packages"
 	}
-	if libraryPath != "" {
-		if !pathExists(libraryPath) {
-			// Warn as the user may have installed debugpy themselves
-			logrus.Warnf("Debugging support for Python %d.%d not found: may require manually installing %q", pc.major, pc.minor, pc.debugMode)
-		}
-		// Append to ensure user-configured values are found first.
-		pc.env.AppendFilepath("PYTHONPATH", libraryPath)
-	}
-	return nil
+	return ""
 }
 
+// updateCommandLine rewrites the python command-line to launch the app under the
+// configured debugging backend.  Interpreter options are preserved and kept ahead
+// of the backend module.
 func (pc *pythonContext) updateCommandLine(ctx context.Context) error {
-	// TODO(#76): we're assuming the `-m module` argument comes first
-	var cmdline []string
+	cl, err := parsePythonCommandLine(pc.args)
+	if err != nil {
+		return err
+	}
+	if cl.kind == targetNone {
+		return fmt.Errorf("no python script, module, or command specified: %q", pc.args)
+	}
+	logrus.Debugf("python command-line: interpreter=%q options=%q target=%q args=%q", cl.interpreter, cl.options, cl.target, cl.args)
+	if cl.kind == targetStdin && (pc.debugMode == ModeDebugpy || pc.debugMode == ModePtvsd) {
+		// debugpy and ptvsd cannot read the program from stdin, so use a launch script
+		f, err := pc.writeLaunchScript(cl)
+		if err != nil {
+			return err
+		}
+		cl.kind = targetScript
+		cl.target = f
+	}
+
+	// the backends otherwise wait indefinitely
+	wait := pc.wait && !pc.timedWait()
+	if pc.timedWait() && pc.portRange.isEmpty() {
+		// the launch script waits for the debugger before running the program
+		f, err := pc.writeWaitScript(cl)
+		if err != nil {
+			return err
+		}
+		cl.kind = targetScript
+		cl.target = f
+	}
+
+	cmdline := cl.interpreterArgs()
+	if !pc.portRange.isEmpty() {
+		// the launch script claims a port and starts debugpy, in this process and in forked children
+		f, err := pc.writePortRangeScript(cl, pc.portRange, pc.wait, pc.subprocess)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("claiming debug ports from %s; allocations are recorded in %q", pc.portRange, portsDir())
+		pc.args = append(append(cmdline, f), cl.args...)
+		return nil
+	}
+	if pc.connect != "" {
+		logrus.Infof("%s connects to the debugger at %s", pc.debugMode, pc.connect)
+	}
 	switch pc.debugMode {
 	case ModePtvsd:
-		cmdline = append(cmdline, pc.args[0])
-		cmdline = append(cmdline, "-m", "ptvsd", "--host", "localhost", "--port", strconv.Itoa(int(pc.port)))
-		if pc.wait {
+		if pc.connect != "" {
+			cmdline = append(cmdline, "-m", "ptvsd", "--client", "--host", pc.connectHost(), "--port", strconv.Itoa(int(pc.port)))
+		} else {
+			cmdline = append(cmdline, "-m", "ptvsd", "--host", "localhost", "--port", strconv.Itoa(int(pc.port)))
+		}
+		if wait && pc.connect == "" {
 			cmdline = append(cmdline, "--wait")
 		}
-		cmdline = append(cmdline, pc.args[1:]...)
-		pc.args = cmdline
+		if pc.subprocess {
+			cmdline = append(cmdline, "--multiprocess")
+		}
+		cmdline = append(cmdline, cl.targetArgs()...)
+		cmdline = append(cmdline, cl.args...)
 
 	case ModeDebugpy:
-		cmdline = append(cmdline, pc.args[0])
-		cmdline = append(cmdline, "-m", "debugpy", "--listen", strconv.Itoa(int(pc.port)))
-		if pc.wait {
+		if pc.connect != "" {
+			// the app does not run until connected to the debugger
+			cmdline = append(cmdline, "-m", "debugpy", "--connect", pc.connect)
+		} else {
+			cmdline = append(cmdline, "-m", "debugpy", "--listen", strconv.Itoa(int(pc.port)))
+		}
+		if wait && pc.connect == "" {
 			cmdline = append(cmdline, "--wait-for-client")
 		}
-		// debugpy expects the `-m` module argument to be separate
-		for i, arg := range pc.args[1:] {
-			if i == 0 && arg != "-m" && strings.HasPrefix(arg, "-m") {
-				cmdline = append(cmdline, "-m", strings.TrimPrefix(arg, "-m"))
-			} else {
-				cmdline = append(cmdline, arg)
-			}
+		if pc.subprocess {
+			cmdline = append(cmdline, "--configure-subProcess", "true")
 		}
-		pc.args = cmdline
+		// debugpy expects the `-m` module argument to be separate, which targetArgs ensures
+		cmdline = append(cmdline, cl.targetArgs()...)
+		cmdline = append(cmdline, cl.args...)
 
 	case ModePydevd, ModePydevdPycharm:
 		// Appropriate location to resolve pydevd is set in updateEnv
-		cmdline = append(cmdline, pc.args[0])
-		cmdline = append(cmdline, "-m", "pydevd", "--server", "--port", strconv.Itoa(int(pc.port)))
-		if !pc.wait {
-			cmdline = append(cmdline, "--continue")
+		if pc.subprocess && pc.connect == "" {
+			// pydevd's child processes would otherwise listen on the parent's port
+			f, err := pc.writeChildPortsScript()
+			if err != nil {
+				return err
+			}
+			cmdline = append(cmdline, f)
+		} else {
+			cmdline = append(cmdline, "-m", "pydevd")
+		}
+		if pc.connect != "" {
+			// pydevd connects to the IDE's debug server, as do its child processes
+			cmdline = append(cmdline, "--client", pc.connectHost(), "--port", strconv.Itoa(int(pc.port)))
+		} else {
+			cmdline = append(cmdline, "--server", "--port", strconv.Itoa(int(pc.port)))
+			if !wait {
+				cmdline = append(cmdline, "--continue")
+			}
+		}
+		if pc.subprocess {
+			cmdline = append(cmdline, "--multiprocess")
 		}
 
-		// --file is expected as last pydev argument, but it must be a file, and so launching with
-		// a module requires some special handling.
-		cmdline = append(cmdline, "--file")
-		file, args, err := handlePydevModule(pc.args[1:])
+		// --file is expected as last pydev argument, and so launching a module, command,
+		// or stdin program requires some special handling.
+		args, err := pc.handlePydevModule(cl)
 		if err != nil {
 			return err
 		}
-		cmdline = append(cmdline, file)
 		cmdline = append(cmdline, args...)
-		pc.args = cmdline
 	}
+	pc.args = cmdline
 	return nil
 }
 
-func determinePythonMajorMinor(ctx context.Context, launcherBin string, env env) (major, minor int, err error) {
-	var versionString string
+// determineInterpreter determines the version and implementation of the given python
+// interpreter, or uses the values set with WRAPPER_PYTHON_VERSION and WRAPPER_PYTHON_IMPLEMENTATION.
+func determineInterpreter(ctx context.Context, launcherBin string, env env) (pythonVersion, string, error) {
+	return resolveInterpreter(launcherBin, env, func() (interpreterInfo, error) {
+		return detectInterpreter(ctx, launcherBin, env)
+	})
+}
+
+// resolveInterpreter returns the version and implementation of the python interpreter
+// run by the launcher, using the detect function unless overridden with WRAPPER_PYTHON_VERSION
+// and WRAPPER_PYTHON_IMPLEMENTATION.
+func resolveInterpreter(launcherBin string, env env, detect func() (interpreterInfo, error)) (pythonVersion, string, error) {
+	var info interpreterInfo
 	if env["WRAPPER_PYTHON_VERSION"] != "" {
-		versionString = env["WRAPPER_PYTHON_VERSION"]
-		logrus.Debugf("Python version from WRAPPER_PYTHON_VERSION=%q", versionString)
+		info.version = env["WRAPPER_PYTHON_VERSION"]
+		logrus.Debugf("Python version from WRAPPER_PYTHON_VERSION=%q", info.version)
 	} else {
-		logrus.Debugf("trying to determine python version from %q", launcherBin)
-		cmd := newCommand(ctx, []string{launcherBin, "-V"}, env)
-		out, err := cmd.CombinedOutput()
+		var err error
+		info, err = detect()
 		if err != nil {
-			return -1, -1, fmt.Errorf("unable to determine python version from %q: %w", launcherBin, err)
+			return pythonVersion{}, "", err
 		}
-		versionString = string(out)
-		logrus.Debugf("'%s -V' = %q", launcherBin, versionString)
-		if !strings.HasPrefix(versionString, "Python ") {
-			return -1, -1, fmt.Errorf("launcher is not a python interpreter: %q", launcherBin)
+	}
+	switch {
+	case env["WRAPPER_PYTHON_IMPLEMENTATION"] != "":
+		info.implementation = strings.ToLower(env["WRAPPER_PYTHON_IMPLEMENTATION"])
+		logrus.Debugf("Python implementation from WRAPPER_PYTHON_IMPLEMENTATION=%q", info.implementation)
+	case info.implementation == "":
+		if info.implementation = implementationFromName(launcherBin); info.implementation == "" {
+			info.implementation = ImplCPython
 		}
-		versionString = versionString[len("Python "):]
 	}
+	version, err := parsePythonVersion(info.version)
+	return version, info.implementation, err
+}
 
-	v := strings.Split(strings.TrimSpace(versionString), ".")
-	major, err = strconv.Atoi(v[0])
-	if err == nil {
-		minor, err = strconv.Atoi(v[1])
+// handlePydevModule returns the trailing pydevd arguments to launch the program, starting
+// with `--file`.  pydevd launches a module with `--module`, which treats the `--file` argument
+// as a module name.  pydevd otherwise only supports launching a python source file, and so a
+// command, stdin program, directory, or zip archive is launched through a python script that
+// launches the program as python would have.
+func (pc *pythonContext) handlePydevModule(cl pythonCommandLine) ([]string, error) {
+	switch cl.kind {
+	case targetNone:
+		return nil, fmt.Errorf("no python command-line specified") // shouldn't happen
+	case targetScript:
+		if !isPathEntry(cl.target) {
+			// this is a file
+			return append([]string{"--file", cl.target}, cl.args...), nil
+		}
+	case targetModule:
+		return append([]string{"--module", "--file", cl.target}, cl.args...), nil
+	}
+	f, err := pc.writeLaunchScript(cl)
+	if err != nil {
+		return nil, err
 	}
-	return
+	return append([]string{"--file", f}, cl.args...), nil
 }
 
-// handlePydevModule applies special pydevd handling for a python module.  When a module is
-// found, we write out a python script that uses runpy to invoke the module.
-func handlePydevModule(args []string) (string, []string, error) {
-	switch {
-	case len(args) == 0:
-		return "", nil, fmt.Errorf("no python command-line specified") // shouldn't happen
-	case !strings.HasPrefix(args[0], "-"):
-		// this is a file
-		return args[0], args[1:], nil
-	case !strings.HasPrefix(args[0], "-m"):
-		// this is some other command-line flag
-		return "", nil, fmt.Errorf("expected python module: %q", args)
-	}
-	module := args[0][2:]
-	remaining := args[1:]
-	if module == "" {
-		if len(args) == 1 {
-			return "", nil, fmt.Errorf("missing python module: %q", args)
-		}
-		module = args[1]
-		remaining = args[2:]
-	}
-
-	snippet := strings.ReplaceAll(`import sys
+// isPathEntry returns true if the script is a directory or a zip archive, such as a zipapp,
+// which python runs through its `__main__.py` after placing it first on the path.
+func isPathEntry(script string) bool {
+	info, err := os.Stat(script)
+	if err != nil {
+		return false
+	}
+	if info.IsDir() {
+		return true
+	}
+	// zipapps may be prefixed with a shebang line, which the zip reader allows for
+	r, err := zip.OpenReader(script)
+	if err != nil {
+		return false
+	}
+	r.Close()
+	return true
+}
+
+// launchPreamble restores sys.path[0] to be the current directory, as python does for
+// `-c` and stdin programs, rather than the directory containing the launch script.
+const launchPreamble = `import os
+_dir = os.path.dirname(os.path.realpath(__file__))
+sys.path[:] = [p for p in sys.path if not p or os.path.realpath(p) != _dir]
+if not sys.path or sys.path[0] != '':
+    sys.path.insert(0, '')
+`
+
+// launchScript returns a python script that runs the script, module, command, or stdin
+// program described by the command-line.  The script ensures sys.argv[0] is as the original
+// program would have seen it; the remaining arguments are left to the debug backend.
+func launchScript(cl pythonCommandLine) (string, error) {
+	switch cl.kind {
+	case targetModule:
+		return strings.ReplaceAll(`import sys
 import runpy
 runpy.run_module('{module}', run_name="__main__",alter_sys=True)
-`, `{module}`, module)
+`, `{module}`, cl.target), nil
+
+	case targetCommand:
+		// the command is base64-encoded to avoid any quoting issues
+		return `import sys
+import base64
+` + launchPreamble + `sys.argv[0] = '-c'
+_code = base64.b64decode('` + base64.StdEncoding.EncodeToString([]byte(cl.target)) + `').decode('utf-8')
+exec(compile(_code, '<string>', 'exec'), {'__name__': '__main__', '__builtins__': __builtins__})
+`, nil
+
+	case targetStdin:
+		return `import sys
+` + launchPreamble + `sys.argv[0] = '-'
+exec(compile(sys.stdin.read(), '<stdin>', 'exec'), {'__name__': '__main__', '__builtins__': __builtins__})
+`, nil
+
+	case targetScript:
+		// python puts the script's directory first on the path, or for a directory or
+		// zip archive, the directory or archive itself, as does runpy
+		return `import sys
+import base64
+import runpy
+import zipfile
+` + launchPreamble + `sys.argv[0] = base64.b64decode('` + base64.StdEncoding.EncodeToString([]byte(cl.target)) + `').decode('utf-8')
+if os.path.isdir(sys.argv[0]) or zipfile.is_zipfile(sys.argv[0]):
+    del sys.path[0]
+else:
+    sys.path[0] = os.path.dirname(os.path.realpath(sys.argv[0]))
+runpy.run_path(sys.argv[0], run_name='__main__')
+`, nil
+	}
+	return "", fmt.Errorf("cannot create launch script for %q", cl.commandLine())
+}
 
-	// write out the temp location as other locations may not be writable
-	d, err := ioutil.TempDir("", "pydevd*")
+// writeLaunchScript writes out a launch script for the given command-line and
+// returns its location.
+func (pc *pythonContext) writeLaunchScript(cl pythonCommandLine) (string, error) {
+	snippet, err := launchScript(cl)
 	if err != nil {
-		return "", nil, err
+		return "", err
 	}
 	// use a skaffold-specific file name to ensure no possibility of it matching a user import
-	f := filepath.Join(d, "skaffold_pydevd_launch.py")
-	if err := ioutil.WriteFile(f, []byte(snippet), 0755); err != nil {
-		return "", nil, err
+	f, err := pc.writeScript("pydevd*", "skaffold_pydevd_launch.py", snippet)
+	if err != nil {
+		return "", err
 	}
-	return f, remaining, nil
+	logrus.Debugf("wrote launch script %q for %q", f, cl.commandLine())
+	return f, nil
+}
+
+// scriptLocations returns the locations in which launch scripts may be written, in order
+// of preference: the helpers volume, $TMPDIR, and /tmp.  The container's root filesystem
+// may be read-only.
+func scriptLocations(env env) []string {
+	locations := []string{filepath.Join(dbgRoot, "python", "tmp")}
+	if tmp := env["TMPDIR"]; tmp != "" {
+		locations = append(locations, tmp)
+	}
+	return append(locations, "/tmp")
+}
+
+// writeScript writes out a script to a new directory in the first writable script location
+// and returns the script's location.  The directory is recorded for removal once the app exits.
+func (pc *pythonContext) writeScript(prefix, name, contents string) (string, error) {
+	var errs []string
+	for _, location := range scriptLocations(pc.env) {
+		if err := os.MkdirAll(location, 0755); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		d, err := ioutil.TempDir(location, prefix)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		f := filepath.Join(d, name)
+		if err := ioutil.WriteFile(f, []byte(contents), 0755); err != nil {
+			errs = append(errs, err.Error())
+			os.RemoveAll(d)
+			continue
+		}
+		pc.scripts = append(pc.scripts, d)
+		return f, nil
+	}
+	return "", fmt.Errorf("unable to write %s: %s", name, strings.Join(errs, "; "))
+}
+
+// removeScripts removes the scripts written for launching the app.
+func (pc *pythonContext) removeScripts() {
+	for _, d := range pc.scripts {
+		if err := os.RemoveAll(d); err != nil {
+			logrus.Debug("unable to remove launch script: ", err)
+		}
+	}
+	pc.scripts = nil
 }
 
 func isEnabled(env env) bool {
@@ -494,3 +919,23 @@ func pathExists(path string) bool {
 	}
 	return false
 }
+
+// writeFileAtomic writes the data to the file by way of a temporary file in the same
+// directory, so that concurrent readers never see a partially-written file.
+func writeFileAtomic(f string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(f), "."+filepath.Base(f)+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -17,11 +17,14 @@ limitations under the License.
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -37,6 +40,7 @@ func TestValidateDebugMode(t *testing.T) {
 		{"ptvsd", false},
 		{"pydevd", false},
 		{"pydevd-pycharm", false},
+		{"auto", false},
 		{"", true},
 		{"pydev", true},         // the 'd' is important
 		{"pydev-pycharm", true}, // the 'd' is important
@@ -120,6 +124,12 @@ func TestAlreadyConfigured(t *testing.T) {
 		{"versioned python with debugpy module", pythonContext{args: []string{"/usr/bin/python3.9", "-m", "debugpy"}}, true},
 		{"python with ptvsd module", pythonContext{args: []string{"python", "-mptvsd"}}, true},
 		{"versioned python with ptvsd module", pythonContext{args: []string{"/usr/bin/python3.9", "-m", "ptvsd"}}, true},
+		{"python options with debugpy module", pythonContext{args: []string{"python", "-u", "-X", "dev", "-m", "debugpy"}}, true},
+		{"python options with app module", pythonContext{args: []string{"python", "-u", "-X", "dev", "-m", "app"}}, false},
+		{"python script named debugpy", pythonContext{args: []string{"python", "debugpy"}}, false},
+		{"env python with debugpy module", pythonContext{args: []string{"/usr/bin/env", "A=B", "python3", "-m", "debugpy"}}, true},
+		{"env python with app module", pythonContext{args: []string{"/usr/bin/env", "python3", "-m", "app"}}, false},
+		{"env -S python with debugpy module", pythonContext{args: []string{"/usr/bin/env", "-S python3 -m debugpy"}}, true},
 	}
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
@@ -200,36 +210,139 @@ func TestUnwrapLauncher(t *testing.T) {
 	}
 }
 
-func TestDeterminePythonMajorMinor(t *testing.T) {
+func TestUnwrapLauncherChain(t *testing.T) {
+	if !pathExists("/usr/bin/env") {
+		t.Skip("requires /usr/bin/env")
+	}
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(contents), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	app := write("app", "#!/usr/bin/env -S PYTHONUNBUFFERED=1 python3 -u\nprint('hi')\n")
+	wrapper := write("wrapper", "#!"+app+"\n")
+	envApp := write("envapp", "#!/usr/bin/env python3\nprint('hi')\n")
+	loop := filepath.Join(dir, "loop")
+	write("loop", "#!"+loop+"\n")
+
 	tests := []struct {
 		description string
+		args        []string
 		env         env
-		commands    commands
 		shouldErr   bool
-		major       int
-		minor       int
+		expected    []string
+		expectedEnv env
 	}{
-		{description: "2.7", commands: RunCmdOut([]string{"python", "-V"}, "Python 2.7.8"), major: 2, minor: 7},
-		{description: "2.7 and newline", commands: RunCmdOut([]string{"python", "-V"}, "Python 2.7.2\n"), major: 2, minor: 7},
-		{description: "3.9 and newline", commands: RunCmdOut([]string{"python", "-V"}, "Python 3.9.14\n"), major: 3, minor: 9},
-		{description: "4.13 from env", env: env{"WRAPPER_PYTHON_VERSION": "4.13.8888"}, major: 4, minor: 13},
-		{description: "error", commands: RunCmdOutFail([]string{"python", "-V"}, "", 1), shouldErr: true, major: -1, minor: -1},
+		{
+			description: "env python",
+			args:        []string{envApp, "arg1"},
+			expected:    []string{"python3", envApp, "arg1"},
+			expectedEnv: env{},
+		},
+		{
+			description: "env -S with assignment",
+			args:        []string{app, "arg1"},
+			env:         env{"A": "B"},
+			expected:    []string{"python3", "-u", app, "arg1"},
+			expectedEnv: env{"A": "B", "PYTHONUNBUFFERED": "1"},
+		},
+		{
+			description: "script found in PATH",
+			args:        []string{"app", "arg1"},
+			env:         env{"PATH": dir},
+			expected:    []string{"python3", "-u", app, "arg1"},
+			expectedEnv: env{"PATH": dir, "PYTHONUNBUFFERED": "1"},
+		},
+		{
+			description: "chained scripts",
+			args:        []string{wrapper, "arg1"},
+			expected:    []string{"python3", "-u", app, wrapper, "arg1"},
+			expectedEnv: env{"PYTHONUNBUFFERED": "1"},
+		},
+		{
+			description: "explicit env",
+			args:        []string{"/usr/bin/env", "-i", "X=Y", "python3", "app.py"},
+			env:         env{"A": "B"},
+			expected:    []string{"python3", "app.py"},
+			expectedEnv: env{"X": "Y"},
+		},
+		{
+			description: "recursive script",
+			args:        []string{loop},
+			shouldErr:   true,
+		},
+		{
+			description: "not in PATH",
+			args:        []string{"app"},
+			env:         env{"PATH": t.TempDir()},
+			shouldErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			pc := pythonContext{args: test.args, env: test.env}
+			err := pc.unwrapLauncher(nil)
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error: %v", pc.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("should not error:", err)
+			}
+			if diff := cmp.Diff(test.expected, pc.args); diff != "" {
+				t.Errorf("args differ (-got, +want): %s", diff)
+			}
+			if diff := cmp.Diff(test.expectedEnv, pc.env, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("env differ (-got, +want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestDetermineInterpreter(t *testing.T) {
+	tests := []struct {
+		description    string
+		env            env
+		commands       commands
+		shouldErr      bool
+		expected       pythonVersion
+		implementation string
+	}{
+		{description: "2.7", commands: RunCmdOut([]string{"python", "-VV"}, "Python 2.7.8"), expected: pythonVersion{major: 2, minor: 7, patch: 8}, implementation: "cpython"},
+		{description: "2.7 and newline", commands: RunCmdOut([]string{"python", "-VV"}, "Python 2.7.2\n"), expected: pythonVersion{major: 2, minor: 7, patch: 2}, implementation: "cpython"},
+		{description: "3.9 and newline", commands: RunCmdOut([]string{"python", "-VV"}, "Python 3.9.14\n"), expected: pythonVersion{major: 3, minor: 9, patch: 14}, implementation: "cpython"},
+		{description: "pre-release", commands: RunCmdOut([]string{"python", "-VV"}, "Python 3.13.0rc2\n"), expected: pythonVersion{major: 3, minor: 13, preRelease: "rc2"}, implementation: "cpython"},
+		{description: "local build", commands: RunCmdOut([]string{"python", "-VV"}, "Python 3.12.1+\n"), expected: pythonVersion{major: 3, minor: 12, patch: 1}, implementation: "cpython"},
+		{description: "pypy", commands: RunCmdOut([]string{"python", "-VV"}, "Python 3.10.13 (f1607341da97ff5a1e93430b6e8c4af0ad1aa019, Sep 28 2023, 05:41:26)\n[PyPy 7.3.13 with GCC 10.2.1 20210110]\n"), expected: pythonVersion{major: 3, minor: 10, patch: 13}, implementation: "pypy"},
+		{description: "graalpy", commands: RunCmdOut([]string{"python", "-VV"}, "GraalPy 3.10.8 (Oracle GraalVM Native 23.1.0)\n"), expected: pythonVersion{major: 3, minor: 10, patch: 8}, implementation: "graalpy"},
+		{description: "4.13 from env", env: env{"WRAPPER_PYTHON_VERSION": "4.13.8888"}, expected: pythonVersion{major: 4, minor: 13, patch: 8888}, implementation: "cpython"},
+		{description: "free-threaded from env", env: env{"WRAPPER_PYTHON_VERSION": "3.13t"}, expected: pythonVersion{major: 3, minor: 13, abiFlags: "t"}, implementation: "cpython"},
+		{description: "implementation from env", env: env{"WRAPPER_PYTHON_VERSION": "3.10", "WRAPPER_PYTHON_IMPLEMENTATION": "PyPy"}, expected: pythonVersion{major: 3, minor: 10}, implementation: "pypy"},
+		{description: "single component from env", env: env{"WRAPPER_PYTHON_VERSION": "3"}, shouldErr: true, implementation: "cpython"},
+		{description: "not python", commands: RunCmdOut([]string{"python", "-VV"}, "uWSGI 2.0.21\n"), shouldErr: true},
+		{description: "error", commands: RunCmdOutFail([]string{"python", "-VV"}, "", 1), shouldErr: true},
 	}
 
+	useEmptyDefaultPath(t)
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
 			test.commands.Setup(t)
-			major, minor, err := determinePythonMajorMinor(context.TODO(), "python", test.env)
+			result, impl, err := determineInterpreter(context.TODO(), "python", test.env)
 			if test.shouldErr && err == nil {
 				t.Error("expected an error")
 			} else if !test.shouldErr && err != nil {
 				t.Error("unexpected error:", err)
 			}
-			if test.major != major {
-				t.Errorf("expected major %d but got %d", test.major, major)
+			if diff := cmp.Diff(test.expected, result, cmp.AllowUnexported(test.expected)); diff != "" {
+				t.Errorf("%T differ (-got, +want): %s", result, diff)
 			}
-			if test.minor != minor {
-				t.Errorf("expected minor %d but got %d", test.minor, minor)
+			if impl != test.implementation {
+				t.Errorf("expected implementation %q but got %q", test.implementation, impl)
 			}
 		})
 	}
@@ -237,6 +350,14 @@ func TestDeterminePythonMajorMinor(t *testing.T) {
 
 func TestPrepare(t *testing.T) {
 	dbgRoot = t.TempDir()
+	useEmptyDefaultPath(t)
+	for _, dir := range []string{"lib/python3.7/site-packages", "pydevd/python3.7/lib/python3.7/site-packages", "pydevd-pycharm/python3.7/lib/python3.7/site-packages", "lib/python3.13t/site-packages", "lib/pypy3.10/site-packages", "pydevd/pypy3.10/lib/pypy3.10/site-packages"} {
+		if err := os.MkdirAll(filepath.Join(dbgRoot, "python", dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, dbgRoot, "python/lib/python3.7/site-packages/debugpy/__init__.py", "")
+	writeFile(t, dbgRoot, "python/pydevd-pycharm/python3.7/lib/python3.7/site-packages/pydevd_pycharm.py", "")
 
 	tests := []struct {
 		description string
@@ -248,58 +369,208 @@ func TestPrepare(t *testing.T) {
 		{
 			description: "debugpy",
 			pc:          pythonContext{debugMode: "debugpy", port: 2345, wait: false, args: []string{"python", "app.py"}, env: nil},
-			commands: RunCmdOut([]string{"python", "-V"}, "Python 3.7.4\n").
+			commands: RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n").
 				AndRunCmd([]string{"python", "-m", "debugpy", "--listen", "2345", "app.py"}),
-			expected: pythonContext{debugMode: "debugpy", port: 2345, wait: false, major: 3, minor: 7, args: []string{"python", "-m", "debugpy", "--listen", "2345", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+			expected: pythonContext{debugMode: "debugpy", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "debugpy", "--listen", "2345", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
 		},
 		{
 			description: "debugpy with module",
 			pc:          pythonContext{debugMode: "debugpy", port: 2345, wait: false, args: []string{"python", "-m", "gunicorn", "app:app"}, env: nil},
-			commands: RunCmdOut([]string{"python", "-V"}, "Python 3.7.4\n").
+			commands: RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n").
 				AndRunCmd([]string{"python", "-m", "debugpy", "--listen", "2345", "app.py"}),
-			expected: pythonContext{debugMode: "debugpy", port: 2345, wait: false, major: 3, minor: 7, args: []string{"python", "-m", "debugpy", "--listen", "2345", "-m", "gunicorn", "app:app"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+			expected: pythonContext{debugMode: "debugpy", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "debugpy", "--listen", "2345", "-m", "gunicorn", "app:app"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
 		},
 		{
 			description: "debugpy with module (no space)",
 			pc:          pythonContext{debugMode: "debugpy", port: 2345, wait: false, args: []string{"python", "-mgunicorn", "app:app"}, env: nil},
-			commands: RunCmdOut([]string{"python", "-V"}, "Python 3.7.4\n").
+			commands: RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n").
 				AndRunCmd([]string{"python", "-m", "debugpy", "--listen", "2345", "app.py"}),
-			expected: pythonContext{debugMode: "debugpy", port: 2345, wait: false, major: 3, minor: 7, args: []string{"python", "-m", "debugpy", "--listen", "2345", "-m", "gunicorn", "app:app"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+			expected: pythonContext{debugMode: "debugpy", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "debugpy", "--listen", "2345", "-m", "gunicorn", "app:app"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
 		},
 		{
 			description: "debugpy with wait",
 			pc:          pythonContext{debugMode: "debugpy", port: 2345, wait: true, args: []string{"python", "app.py"}, env: nil},
-			commands: RunCmdOut([]string{"python", "-V"}, "Python 3.7.4\n").
+			commands: RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n").
 				AndRunCmd([]string{"python", "-m", "debugpy", "--listen", "2345", "--wait-for-client", "app.py"}),
-			expected: pythonContext{debugMode: "debugpy", port: 2345, wait: true, major: 3, minor: 7, args: []string{"python", "-m", "debugpy", "--listen", "2345", "--wait-for-client", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+			expected: pythonContext{debugMode: "debugpy", port: 2345, wait: true, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "debugpy", "--listen", "2345", "--wait-for-client", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "debugpy with interpreter options",
+			pc:          pythonContext{debugMode: "debugpy", port: 2345, wait: false, args: []string{"python", "-u", "-X", "dev", "-W", "ignore", "-m", "gunicorn", "app:app"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			expected:    pythonContext{debugMode: "debugpy", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-u", "-X", "dev", "-W", "ignore", "-m", "debugpy", "--listen", "2345", "-m", "gunicorn", "app:app"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "debugpy with combined interpreter options",
+			pc:          pythonContext{debugMode: "debugpy", port: 2345, wait: false, args: []string{"python", "-uBXdev", "-OO", "app.py", "-u"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			expected:    pythonContext{debugMode: "debugpy", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-u", "-B", "-X", "dev", "-O", "-O", "-m", "debugpy", "--listen", "2345", "app.py", "-u"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "debugpy with subprocesses",
+			pc:          pythonContext{debugMode: "debugpy", port: 2345, subprocess: true, args: []string{"python", "-m", "celery", "worker"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			expected:    pythonContext{debugMode: "debugpy", port: 2345, subprocess: true, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "debugpy", "--listen", "2345", "--configure-subProcess", "true", "-m", "celery", "worker"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "debugpy connect",
+			pc:          pythonContext{debugMode: "debugpy", port: 5678, wait: true, connect: "ide:5678", args: []string{"python", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			expected:    pythonContext{debugMode: "debugpy", port: 5678, wait: true, connect: "ide:5678", version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "debugpy", "--connect", "ide:5678", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "ptvsd connect",
+			pc:          pythonContext{debugMode: "ptvsd", port: 5678, connect: "ide:5678", args: []string{"python", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			expected:    pythonContext{debugMode: "ptvsd", port: 5678, connect: "ide:5678", version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "ptvsd", "--client", "--host", "ide", "--port", "5678", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "pydevd-pycharm connect with subprocesses",
+			pc:          pythonContext{debugMode: "pydevd-pycharm", port: 12345, subprocess: true, connect: "host.docker.internal:12345", args: []string{"python", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			expected:    pythonContext{debugMode: "pydevd-pycharm", port: 12345, subprocess: true, connect: "host.docker.internal:12345", version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "pydevd", "--client", "host.docker.internal", "--port", "12345", "--multiprocess", "--file", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/pydevd-pycharm/python3.7/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "ptvsd with subprocesses",
+			pc:          pythonContext{debugMode: "ptvsd", port: 2345, subprocess: true, args: []string{"python", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			expected:    pythonContext{debugMode: "ptvsd", port: 2345, subprocess: true, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "ptvsd", "--host", "localhost", "--port", "2345", "--multiprocess", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
 		},
 		{
 			description: "ptvsd",
 			pc:          pythonContext{debugMode: "ptvsd", port: 2345, wait: false, args: []string{"python", "app.py"}, env: nil},
-			commands: RunCmdOut([]string{"python", "-V"}, "Python 3.7.4\n").
+			commands: RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n").
 				AndRunCmd([]string{"python", "-m", "ptvsd", "--host", "localhost", "--port", "2345", "app.py"}),
-			expected: pythonContext{debugMode: "ptvsd", port: 2345, wait: false, major: 3, minor: 7, args: []string{"python", "-m", "ptvsd", "--host", "localhost", "--port", "2345", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+			expected: pythonContext{debugMode: "ptvsd", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "ptvsd", "--host", "localhost", "--port", "2345", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
 		},
 		{
 			description: "ptvsd with wait",
 			pc:          pythonContext{debugMode: "ptvsd", port: 2345, wait: true, args: []string{"python", "app.py"}, env: nil},
-			commands: RunCmdOut([]string{"python", "-V"}, "Python 3.7.4\n").
+			commands: RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n").
 				AndRunCmd([]string{"python", "-m", "ptvsd", "--host", "localhost", "--port", "2345", "--wait", "app.py"}),
-			expected: pythonContext{debugMode: "ptvsd", port: 2345, wait: true, major: 3, minor: 7, args: []string{"python", "-m", "ptvsd", "--host", "localhost", "--port", "2345", "--wait", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+			expected: pythonContext{debugMode: "ptvsd", port: 2345, wait: true, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "ptvsd", "--host", "localhost", "--port", "2345", "--wait", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
 		},
 		{
 			description: "pydevd",
 			pc:          pythonContext{debugMode: "pydevd", port: 2345, wait: false, args: []string{"python", "app.py"}, env: nil},
-			commands: RunCmdOut([]string{"python", "-V"}, "Python 3.7.4\n").
+			commands: RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n").
 				AndRunCmd([]string{"python", "-m", "pydevd", "--server", "--port", "2345", "--continue", "--file", "app.py"}),
-			expected: pythonContext{debugMode: "pydevd", port: 2345, wait: false, major: 3, minor: 7, args: []string{"python", "-m", "pydevd", "--server", "--port", "2345", "--continue", "--file", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/pydevd/python3.7/lib/python3.7/site-packages"}},
+			expected: pythonContext{debugMode: "pydevd", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "pydevd", "--server", "--port", "2345", "--continue", "--file", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/pydevd/python3.7/lib/python3.7/site-packages"}},
 		},
 		{
 			description: "pydevd with wait",
 			pc:          pythonContext{debugMode: "pydevd", port: 2345, wait: true, args: []string{"python", "app.py"}, env: nil},
-			commands: RunCmdOut([]string{"python", "-V"}, "Python 3.7.4\n").
+			commands: RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n").
 				AndRunCmd([]string{"python", "-m", "pydevd", "--server", "--port", "2345", "--file", "app.py"}),
-			expected: pythonContext{debugMode: "pydevd", port: 2345, wait: true, major: 3, minor: 7, args: []string{"python", "-m", "pydevd", "--server", "--port", "2345", "--file", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/pydevd/python3.7/lib/python3.7/site-packages"}},
+			expected: pythonContext{debugMode: "pydevd", port: 2345, wait: true, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "pydevd", "--server", "--port", "2345", "--file", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/pydevd/python3.7/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "debugpy with command",
+			pc:          pythonContext{debugMode: "debugpy", port: 2345, wait: false, args: []string{"python", "-u", "-c", "import app; app.main()", "arg"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			expected:    pythonContext{debugMode: "debugpy", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-u", "-m", "debugpy", "--listen", "2345", "-c", "import app; app.main()", "arg"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "ptvsd with command",
+			pc:          pythonContext{debugMode: "ptvsd", port: 2345, wait: false, args: []string{"python", "-cimport app; app.main()"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			expected:    pythonContext{debugMode: "ptvsd", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "ptvsd", "--host", "localhost", "--port", "2345", "-c", "import app; app.main()"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "ptvsd with interpreter options",
+			pc:          pythonContext{debugMode: "ptvsd", port: 2345, wait: false, args: []string{"python", "-u", "-m", "gunicorn", "app:app"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			expected:    pythonContext{debugMode: "ptvsd", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-u", "-m", "ptvsd", "--host", "localhost", "--port", "2345", "-m", "gunicorn", "app:app"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "pydevd with interpreter options",
+			pc:          pythonContext{debugMode: "pydevd", port: 2345, wait: false, args: []string{"python", "-u", "-X", "dev", "app.py", "arg"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			expected:    pythonContext{debugMode: "pydevd", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-u", "-X", "dev", "-m", "pydevd", "--server", "--port", "2345", "--continue", "--file", "app.py", "arg"}, env: env{"PYTHONPATH": dbgRoot + "/python/pydevd/python3.7/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "unknown interpreter option",
+			pc:          pythonContext{debugMode: "debugpy", port: 2345, wait: false, args: []string{"python", "-Z", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			shouldFail:  true,
+			expected:    pythonContext{debugMode: "debugpy", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "-Z", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "debugpy with free-threaded python",
+			pc:          pythonContext{debugMode: "debugpy", port: 2345, wait: false, args: []string{"python", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.13.1 experimental free-threading build (main, Dec  4 2024, 08:54:15) [GCC 12.2.0]\n"),
+			expected:    pythonContext{debugMode: "debugpy", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 13, patch: 1, abiFlags: "t"}, implementation: "cpython", args: []string{"python", "-m", "debugpy", "--listen", "2345", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.13t/site-packages"}},
+		},
+		{
+			description: "pydevd not bundled for python version",
+			pc:          pythonContext{debugMode: "pydevd", port: 2345, wait: false, args: []string{"python", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.13.1 experimental free-threading build (main, Dec  4 2024, 08:54:15) [GCC 12.2.0]\n"),
+			shouldFail:  true,
+			expected:    pythonContext{debugMode: "pydevd", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 13, patch: 1, abiFlags: "t"}, implementation: "cpython", args: []string{"python", "app.py"}, env: env{}},
+		},
+		{
+			description: "not bundled but skipping environment",
+			pc:          pythonContext{debugMode: "debugpy", port: 2345, wait: false, args: []string{"python", "app.py"}, env: env{"WRAPPER_SKIP_ENV": "1"}},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.14.0a1\n"),
+			expected:    pythonContext{debugMode: "debugpy", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 14, preRelease: "a1"}, implementation: "cpython", args: []string{"python", "-m", "debugpy", "--listen", "2345", "app.py"}, env: env{"WRAPPER_SKIP_ENV": "1"}},
+		},
+		{
+			description: "debugpy with pypy",
+			pc:          pythonContext{debugMode: "debugpy", port: 2345, wait: false, args: []string{"pypy3", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"pypy3", "-VV"}, "Python 3.10.13 (f1607341da97, Sep 28 2023, 05:41:26)\n[PyPy 7.3.13 with GCC 10.2.1 20210110]\n"),
+			expected:    pythonContext{debugMode: "debugpy", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 10, patch: 13}, implementation: "pypy", args: []string{"pypy3", "-m", "debugpy", "--listen", "2345", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/pypy3.10/site-packages"}},
+		},
+		{
+			description: "pydevd with pypy",
+			pc:          pythonContext{debugMode: "pydevd", port: 2345, wait: false, args: []string{"pypy3", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"pypy3", "-VV"}, "Python 3.10.13 (f1607341da97, Sep 28 2023, 05:41:26)\n[PyPy 7.3.13 with GCC 10.2.1 20210110]\n"),
+			expected:    pythonContext{debugMode: "pydevd", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 10, patch: 13}, implementation: "pypy", args: []string{"pypy3", "-m", "pydevd", "--server", "--port", "2345", "--continue", "--file", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/pydevd/pypy3.10/lib/pypy3.10/site-packages"}},
+		},
+		{
+			description: "ptvsd is not supported with pypy",
+			pc:          pythonContext{debugMode: "ptvsd", port: 2345, wait: false, args: []string{"pypy3", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"pypy3", "-VV"}, "Python 3.10.13 (f1607341da97, Sep 28 2023, 05:41:26)\n[PyPy 7.3.13 with GCC 10.2.1 20210110]\n"),
+			shouldFail:  true,
+			expected:    pythonContext{debugMode: "ptvsd", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 10, patch: 13}, implementation: "pypy", args: []string{"pypy3", "app.py"}},
+		},
+		{
+			description: "debugpy is not bundled for graalpy",
+			pc:          pythonContext{debugMode: "debugpy", port: 2345, wait: false, args: []string{"graalpy", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"graalpy", "-VV"}, "GraalPy 3.10.8 (Oracle GraalVM Native 23.1.0)\n"),
+			shouldFail:  true,
+			expected:    pythonContext{debugMode: "debugpy", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 10, patch: 8}, implementation: "graalpy", args: []string{"graalpy", "app.py"}, env: env{}},
+		},
+		{
+			description: "debugpy installed with graalpy app",
+			pc:          pythonContext{debugMode: "debugpy", port: 2345, wait: false, args: []string{"graalpy", "app.py"}, env: env{"WRAPPER_SKIP_ENV": "1"}},
+			commands:    RunCmdOut([]string{"graalpy", "-VV"}, "GraalPy 3.10.8 (Oracle GraalVM Native 23.1.0)\n"),
+			expected:    pythonContext{debugMode: "debugpy", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 10, patch: 8}, implementation: "graalpy", args: []string{"graalpy", "-m", "debugpy", "--listen", "2345", "app.py"}, env: env{"WRAPPER_SKIP_ENV": "1"}},
+		},
+		{
+			description: "auto selects debugpy",
+			pc:          pythonContext{debugMode: "auto", port: 2345, wait: false, args: []string{"python", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			expected:    pythonContext{debugMode: "debugpy", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", modeReason: fmt.Sprintf("bundled for cpython 3.7.4 at %q", dbgRoot+"/python/lib/python3.7/site-packages"), args: []string{"python", "-m", "debugpy", "--listen", "2345", "app.py"}, env: env{"PYTHONPATH": dbgRoot + "/python/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "auto with pycharm hint",
+			pc:          pythonContext{debugMode: "auto", port: 2345, wait: false, args: []string{"python", "app.py"}, env: env{"WRAPPER_IDE": "pycharm"}},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			expected:    pythonContext{debugMode: "pydevd-pycharm", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", modeReason: fmt.Sprintf("bundled for cpython 3.7.4 at %q", dbgRoot+"/python/pydevd-pycharm/python3.7/lib/python3.7/site-packages"), args: []string{"python", "-m", "pydevd", "--server", "--port", "2345", "--continue", "--file", "app.py"}, env: env{"WRAPPER_IDE": "pycharm", "PYTHONPATH": dbgRoot + "/python/pydevd-pycharm/python3.7/lib/python3.7/site-packages"}},
+		},
+		{
+			description: "auto with nothing available",
+			pc:          pythonContext{debugMode: "auto", port: 2345, wait: false, args: []string{"python", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.12.1\n"),
+			shouldFail:  true,
+			expected:    pythonContext{debugMode: "auto", port: 2345, wait: false, version: pythonVersion{major: 3, minor: 12, patch: 1}, implementation: "cpython", args: []string{"python", "app.py"}},
+		},
+		{
+			description: "port range is not supported with pydevd",
+			pc:          pythonContext{debugMode: "pydevd", port: 5678, portRange: portRange{first: 5678, last: 5687}, args: []string{"python", "app.py"}, env: nil},
+			commands:    RunCmdOut([]string{"python", "-VV"}, "Python 3.7.4\n"),
+			shouldFail:  true,
+			expected:    pythonContext{debugMode: "pydevd", port: 5678, portRange: portRange{first: 5678, last: 5687}, version: pythonVersion{major: 3, minor: 7, patch: 4}, implementation: "cpython", args: []string{"python", "app.py"}},
 		},
 		{
 			description: "WRAPPER_ENABLED=false",
@@ -337,7 +608,7 @@ func TestPrepare(t *testing.T) {
 				t.Error("prepare() should have failed")
 			} else if !test.shouldFail && !result {
 				t.Error("prepare() should have succeeded")
-			} else if diff := cmp.Diff(test.expected, pc, cmp.AllowUnexported(test.expected)); diff != "" {
+			} else if diff := cmp.Diff(test.expected, pc, cmp.AllowUnexported(test.expected, pythonVersion{}, portRange{})); diff != "" {
 				_t.Errorf("%T differ (-got, +want): %s", pc, diff)
 			}
 		})
@@ -354,70 +625,225 @@ func TestPathExists(t *testing.T) {
 }
 
 func TestHandlePydevModule(t *testing.T) {
-	tmp := os.TempDir()
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+	script := filepath.Join(dbgRoot, "python", "tmp", "pydevd*", "skaffold_pydevd_launch.py")
+	dir := t.TempDir()
+	pkg := filepath.Join(dir, "mypkg")
+	writeFile(t, pkg, "__main__.py", "print('hi')")
+	zipapp := writeZipapp(t, dir, "app.pyz")
 
 	tests := []struct {
 		description string
-		args        []string
+		cl          pythonCommandLine
 		shouldErr   bool
-		module      string
-		file        string
-		remaining   []string
+		expected    []string
+		// script is true if the second argument is a launch script matching the glob
+		script bool
 	}{
 		{
 			description: "plain file",
-			args:        []string{"app.py"},
-			file:        "app.py",
+			cl:          pythonCommandLine{kind: targetScript, target: "app.py"},
+			expected:    []string{"--file", "app.py"},
 		},
 		{
-			description: "-mmodule",
-			args:        []string{"-mmodule"},
-			file:        filepath.Join(tmp, "*", "skaffold_pydevd_launch.py"),
+			description: "plain file with args",
+			cl:          pythonCommandLine{kind: targetScript, target: "app.py", args: []string{"arg1", "arg2"}},
+			expected:    []string{"--file", "app.py", "arg1", "arg2"},
 		},
 		{
-			description: "-m module",
-			args:        []string{"-m", "module"},
-			file:        filepath.Join(tmp, "*", "skaffold_pydevd_launch.py"),
+			description: "zipapp",
+			cl:          pythonCommandLine{kind: targetScript, target: zipapp, args: []string{"arg1"}},
+			expected:    []string{"--file", script, "arg1"},
+			script:      true,
 		},
 		{
-			description: "- should error",
-			args:        []string{"-", "module"},
-			shouldErr:   true,
+			description: "directory",
+			cl:          pythonCommandLine{kind: targetScript, target: pkg},
+			expected:    []string{"--file", script},
+			script:      true,
 		},
 		{
-			description: "-x should error",
-			args:        []string{"-x", "module"},
-			shouldErr:   true,
+			description: "module",
+			cl:          pythonCommandLine{kind: targetModule, target: "module"},
+			expected:    []string{"--module", "--file", "module"},
 		},
 		{
-			description: "lone -m should error",
-			args:        []string{"-m"},
-			shouldErr:   true,
+			description: "module with args",
+			cl:          pythonCommandLine{kind: targetModule, target: "module", args: []string{"arg1", "arg2"}},
+			expected:    []string{"--module", "--file", "module", "arg1", "arg2"},
+		},
+		{
+			description: "command with args",
+			cl:          pythonCommandLine{kind: targetCommand, target: "import app; app.main()", args: []string{"arg1"}},
+			expected:    []string{"--file", script, "arg1"},
+			script:      true,
+		},
+		{
+			description: "stdin with args",
+			cl:          pythonCommandLine{kind: targetStdin, target: "-", args: []string{"arg1"}},
+			expected:    []string{"--file", script, "arg1"},
+			script:      true,
 		},
 		{
-			description: "no args should error",
+			description: "no target should error",
+			cl:          pythonCommandLine{kind: targetNone},
 			shouldErr:   true,
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
-			file, args, err := handlePydevModule(test.args)
+			pc := pythonContext{env: env{}}
+			args, err := pc.handlePydevModule(test.cl)
 			if test.shouldErr {
 				if err == nil {
 					t.Error("Expected an error")
 				}
-			} else {
-				if !fileMatch(t, test.file, file) {
-					t.Errorf("Wanted %q but got %q", test.file, file)
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if test.script {
+				if len(args) < 2 || !fileMatch(t, script, args[1]) {
+					t.Fatalf("Wanted launch script %q but got %q", script, args)
+				}
+				if len(pc.scripts) != 1 || pc.scripts[0] != filepath.Dir(args[1]) {
+					t.Errorf("launch script not recorded for removal: %q", pc.scripts)
+				}
+				args[1] = script
+			} else if len(pc.scripts) != 0 {
+				t.Errorf("unexpected launch scripts: %q", pc.scripts)
+			}
+			if diff := cmp.Diff(args, test.expected, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("args %T differ (-got, +want): %s", test.expected, diff)
+			}
+			pc.removeScripts()
+		})
+	}
+}
+
+func TestWriteScript(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+
+	// the helpers volume is preferred
+	dbgRoot = t.TempDir()
+	pc := pythonContext{env: env{"TMPDIR": t.TempDir()}}
+	f, err := pc.writeScript("test*", "script.py", "print('hi')")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if !fileMatch(t, filepath.Join(dbgRoot, "python", "tmp", "test*", "script.py"), f) {
+		t.Errorf("expected script under the helpers volume but got %q", f)
+	}
+
+	// fall back to $TMPDIR when the helpers volume is read-only, as simulated by a file
+	dbgRoot = writeFile(t, t.TempDir(), "dbg", "")
+	tmp := t.TempDir()
+	pc.env["TMPDIR"] = tmp
+	g, err := pc.writeScript("test*", "script.py", "print('hi')")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if !fileMatch(t, filepath.Join(tmp, "test*", "script.py"), g) {
+		t.Errorf("expected script under $TMPDIR but got %q", g)
+	}
+	if contents, err := ioutil.ReadFile(g); err != nil || string(contents) != "print('hi')" {
+		t.Errorf("unexpected script contents %q (%v)", contents, err)
+	}
+
+	pc.removeScripts()
+	if pathExists(filepath.Dir(f)) || pathExists(filepath.Dir(g)) {
+		t.Errorf("scripts should have been removed: %q, %q", f, g)
+	}
+	if !pathExists(tmp) {
+		t.Error("script location should not have been removed")
+	}
+}
+
+func TestLaunchScript(t *testing.T) {
+	tests := []struct {
+		description string
+		cl          pythonCommandLine
+		shouldErr   bool
+		contains    []string
+	}{
+		{
+			description: "module",
+			cl:          pythonCommandLine{kind: targetModule, target: "flask"},
+			contains:    []string{`runpy.run_module('flask', run_name="__main__",alter_sys=True)`},
+		},
+		{
+			description: "command",
+			cl:          pythonCommandLine{kind: targetCommand, target: `print("it's")`},
+			contains:    []string{"sys.argv[0] = '-c'", "base64.b64decode('cHJpbnQoIml0J3MiKQ==')", "'<string>'"},
+		},
+		{
+			description: "stdin",
+			cl:          pythonCommandLine{kind: targetStdin, target: "-"},
+			contains:    []string{"sys.argv[0] = '-'", "sys.stdin.read()", "'<stdin>'"},
+		},
+		{
+			description: "script",
+			cl:          pythonCommandLine{kind: targetScript, target: "app.py"},
+			contains:    []string{"base64.b64decode('YXBwLnB5')", "sys.path[0] = os.path.dirname(", "zipfile.is_zipfile(sys.argv[0])", "runpy.run_path(sys.argv[0], run_name='__main__')"},
+		},
+		{
+			description: "no target should error",
+			cl:          pythonCommandLine{kind: targetNone},
+			shouldErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			script, err := launchScript(test.cl)
+			if test.shouldErr {
+				if err == nil {
+					t.Error("Expected an error")
 				}
-				if diff := cmp.Diff(args, test.remaining, cmpopts.EquateEmpty()); diff != "" {
-					t.Errorf("remaining args %T differ (-got, +want): %s", test.remaining, diff)
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			for _, c := range test.contains {
+				if !strings.Contains(script, c) {
+					t.Errorf("script should contain %q:\n%s", c, script)
 				}
 			}
 		})
 	}
 }
 
+// writeZipapp writes a zipapp, prefixed with a shebang line, that prints a greeting.
+func writeZipapp(t *testing.T, dir, name string) string {
+	t.Helper()
+	var b bytes.Buffer
+	b.WriteString("#!/usr/bin/env python3\n")
+	w := zip.NewWriter(&b)
+	w.SetOffset(int64(b.Len()))
+	f, err := w.Create("__main__.py")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("print('hi')\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, dir, name, b.String())
+}
+
+// useEmptyDefaultPath ensures that bare executable names are not resolved on the host.
+func useEmptyDefaultPath(t *testing.T) {
+	oldDefaultPath := defaultPath
+	defaultPath = t.TempDir()
+	t.Cleanup(func() { defaultPath = oldDefaultPath })
+}
+
 func fileMatch(t *testing.T, glob, file string) bool {
 	if file == glob {
 		return true
@@ -0,0 +1,260 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// portRange is an inclusive range of ports from which each debugged python process
+// claims a port.  The zero value is an empty range.
+type portRange struct {
+	first, last uint
+}
+
+// parsePortRange parses a port range like `5678-5687`.
+func parsePortRange(s string) (portRange, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return portRange{}, fmt.Errorf("invalid port range %q: expecting first-last", s)
+	}
+	first, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil || first == 0 {
+		return portRange{}, fmt.Errorf("invalid port range %q: bad first port", s)
+	}
+	last, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil || last < first {
+		return portRange{}, fmt.Errorf("invalid port range %q: bad last port", s)
+	}
+	return portRange{first: uint(first), last: uint(last)}, nil
+}
+
+func (r portRange) isEmpty() bool {
+	return r.first == 0
+}
+
+func (r portRange) String() string {
+	return fmt.Sprintf("%d-%d", r.first, r.last)
+}
+
+// portsDir returns the directory where port allocations are recorded.
+func portsDir() string {
+	return filepath.Join(dbgRoot, "python", "ports")
+}
+
+// portClaimBootstrap defines `_skaffold_claim()` to claim a port from the range, and
+// `_skaffold_release()` to give up a port that the process could not listen on.  A port
+// is claimed by recording the claiming process in `<port>.json` in the ports directory;
+// claims held by processes that have exited, or whose lease has expired, are reclaimed.
+// A claim records the boot ID and the process start time as pids are reused.  Records
+// are only read and written under an exclusive lock, so that checking a claim and
+// replacing it is atomic, and a released record is left empty rather than removed.
+const portClaimBootstrap = `import errno
+import fcntl
+import json
+import os
+import socket
+import sys
+import time
+
+_skaffold_first, _skaffold_last = {first}, {last}
+_skaffold_dir = base64.b64decode('{dir}').decode('utf-8')
+
+def _skaffold_alive(pid):
+    try:
+        os.kill(pid, 0)
+    except OSError as e:
+        return e.errno != errno.ESRCH
+    return True
+
+def _skaffold_read(path):
+    try:
+        with open(path) as f:
+            return f.read().strip()
+    except (IOError, OSError):
+        return None
+
+def _skaffold_start_time(pid):
+    stat = _skaffold_read('/proc/%d/stat' % pid)
+    try:
+        # the start time is the 22nd field, counting from the pid
+        return int(stat.rsplit(')', 1)[1].split()[19])
+    except (AttributeError, IndexError, ValueError):
+        return None
+
+_skaffold_boot = _skaffold_read('/proc/sys/kernel/random/boot_id')
+
+def _skaffold_held(claim):
+    owner, expires = claim.get('pid'), claim.get('expires')
+    if not owner or claim.get('boot') != _skaffold_boot or (expires and expires < time.time()):
+        return False
+    return claim.get('start') == _skaffold_start_time(owner) and _skaffold_alive(owner)
+
+def _skaffold_update(port, update):
+    try:
+        os.makedirs(_skaffold_dir)
+    except OSError:
+        pass
+    try:
+        with open(os.path.join(_skaffold_dir, '%d.json' % port), 'a+') as f:
+            fcntl.flock(f.fileno(), fcntl.LOCK_EX)
+            f.seek(0)
+            try:
+                claim = json.loads(f.read() or '{}')
+            except ValueError:
+                claim = {}
+            claim = update(claim)
+            if claim is not None:
+                f.seek(0)
+                f.truncate()
+                if claim:
+                    f.write(json.dumps(claim))
+            return claim
+    except (IOError, OSError):
+        return None
+
+def _skaffold_claim(first=None, lease=None):
+    def claim(current):
+        if _skaffold_held(current):
+            return None
+        pid = os.getpid()
+        info = {'pid': pid, 'ppid': os.getppid(), 'start': _skaffold_start_time(pid), 'boot': _skaffold_boot, 'port': port, 'argv': sys.argv}
+        if lease:
+            info['expires'] = time.time() + lease
+        return info
+    for port in range(first or _skaffold_first, _skaffold_last + 1):
+        if _skaffold_update(port, claim):
+            return port
+    return None
+
+def _skaffold_release(port):
+    _skaffold_update(port, lambda claim: {} if claim.get('pid') == os.getpid() else None)
+`
+
+// forkResetBootstrap defines `_skaffold_reset()` to reset the debugpy state inherited by a
+// forked process so that it can listen on a port of its own.  The reset relies on debugpy
+// internals, and so is only attempted with the debugpy versions known to have them.
+const forkResetBootstrap = `def _skaffold_reset():
+    # a forked child inherits its parent's debugger state but not its threads,
+    # so the state must be reset before listening on a port of its own
+    try:
+        import debugpy
+        from debugpy.server import api
+        if not debugpy.__version__.startswith('1.') or not hasattr(api._settrace, 'called'):
+            raise RuntimeError('unsupported debugpy version %s' % debugpy.__version__)
+        api._settrace.called = False
+        import pydevd
+        pydevd.stoptrace()
+    except Exception as e:
+        sys.stderr.write('skaffold: unable to reset debugger in forked process %d: %s\n' % (os.getpid(), e))
+        return False
+    return True
+
+`
+
+// portRangeBootstrap follows portClaimBootstrap, forkResetBootstrap, and waitBootstrap in
+// a launch script to have the process, and any processes subsequently forked from it,
+// claim a port from the range and listen for debugpy connections.
+const portRangeBootstrap = `_skaffold_wait = {wait}
+_skaffold_subprocess = {subprocess}
+
+def _skaffold_listen():
+    import debugpy
+    # debugpy otherwise has forked processes connect to the parent's adapter
+    debugpy.configure(subProcess=_skaffold_subprocess)
+    port = _skaffold_first
+    while True:
+        port = _skaffold_claim(port)
+        if port is None:
+            sys.stderr.write('skaffold: no free debug port in %d-%d for process %d\n' % (_skaffold_first, _skaffold_last, os.getpid()))
+            return
+        try:
+            debugpy.listen(('localhost', port))
+            break
+        except Exception:
+            # the port is in use by a process that has not claimed it
+            _skaffold_release(port)
+            port += 1
+    if _skaffold_wait:
+        _skaffold_wait_for_client(debugpy.wait_for_client, debugpy.wait_for_client.cancel)
+
+def _skaffold_after_fork():
+    if _skaffold_reset():
+        _skaffold_listen()
+
+_skaffold_listen()
+if hasattr(os, 'register_at_fork'):
+    os.register_at_fork(after_in_child=_skaffold_after_fork)
+`
+
+// pythonBool returns the python literal for the boolean.
+func pythonBool(b bool) string {
+	if b {
+		return "True"
+	}
+	return "False"
+}
+
+// portClaimReplacer returns a replacer to fill in the port range for portClaimBootstrap.
+func portClaimReplacer(r portRange, replacements ...string) *strings.Replacer {
+	return strings.NewReplacer(append([]string{
+		"{first}", strconv.Itoa(int(r.first)),
+		"{last}", strconv.Itoa(int(r.last)),
+		"{dir}", base64.StdEncoding.EncodeToString([]byte(portsDir()))}, replacements...)...)
+}
+
+// checkPortRangeSupported returns an error if the debug mode cannot be used with a port range.
+func checkPortRangeSupported(mode string) error {
+	if mode != ModeDebugpy {
+		return fmt.Errorf("port ranges are only supported with %s, not %s", ModeDebugpy, mode)
+	}
+	return nil
+}
+
+// portRangeScript returns a launch script that claims a port from the range and then
+// runs the program described by the command-line.  The waiter is the waitBootstrap.
+func portRangeScript(cl pythonCommandLine, r portRange, wait, subprocess bool, waiter string) (string, error) {
+	program, err := launchScript(cl)
+	if err != nil {
+		return "", err
+	}
+	bootstrap := portClaimReplacer(r,
+		"{wait}", pythonBool(wait),
+		"{subprocess}", pythonBool(subprocess)).Replace(portClaimBootstrap + forkResetBootstrap + waiter + portRangeBootstrap)
+	return "import base64\n" + bootstrap + program, nil
+}
+
+// writePortRangeScript writes out a port-range launch script for the given command-line
+// and returns its location.
+func (pc *pythonContext) writePortRangeScript(cl pythonCommandLine, r portRange, wait, subprocess bool) (string, error) {
+	snippet, err := portRangeScript(cl, r, wait, subprocess, pc.waitBootstrapScript())
+	if err != nil {
+		return "", err
+	}
+	f, err := pc.writeScript("debugpy*", "skaffold_debugpy_ports.py", snippet)
+	if err != nil {
+		return "", err
+	}
+	logrus.Debugf("wrote port-range launch script %q for %q", f, cl.commandLine())
+	return f, nil
+}
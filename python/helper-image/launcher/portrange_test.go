@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		value     string
+		shouldErr bool
+		expected  portRange
+	}{
+		{"5678-5687", false, portRange{first: 5678, last: 5687}},
+		{"5678-5678", false, portRange{first: 5678, last: 5678}},
+		{"5678", true, portRange{}},
+		{"5687-5678", true, portRange{}},
+		{"0-10", true, portRange{}},
+		{"5678-70000", true, portRange{}},
+		{"a-b", true, portRange{}},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			result, err := parsePortRange(test.value)
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %v", result)
+				}
+			} else if err != nil {
+				t.Error("unexpected error:", err)
+			} else if result != test.expected {
+				t.Errorf("expected %v but got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestPortRangeScript(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	dbgRoot = "/dbg"
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+
+	pc := pythonContext{wait: true, waitTimeout: 30, waitTimeoutAction: WaitTimeoutExit}
+	script, err := portRangeScript(pythonCommandLine{kind: targetModule, target: "gunicorn"}, portRange{first: 5678, last: 5687}, true, false, pc.waitBootstrapScript())
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, c := range []string{
+		"_skaffold_first, _skaffold_last = 5678, 5687",
+		"_skaffold_dir = base64.b64decode('L2RiZy9weXRob24vcG9ydHM=')", // /dbg/python/ports
+		"_skaffold_wait = True",
+		"_skaffold_wait_timeout = 30",
+		"_skaffold_wait_exit = True",
+		"_skaffold_subprocess = False",
+		"debugpy.configure(subProcess=_skaffold_subprocess)",
+		"fcntl.flock(f.fileno(), fcntl.LOCK_EX)",
+		"os.register_at_fork(after_in_child=_skaffold_after_fork)",
+		`runpy.run_module('gunicorn', run_name="__main__",alter_sys=True)`,
+	} {
+		if !strings.Contains(script, c) {
+			t.Errorf("script should contain %q:\n%s", c, script)
+		}
+	}
+}
+
+func TestUpdateCommandLineWithPortRange(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+
+	pc := pythonContext{debugMode: "debugpy", port: 5678, portRange: portRange{first: 5678, last: 5687}, args: []string{"python", "-u", "-m", "gunicorn", "app:app"}, env: env{}}
+	if err := pc.updateCommandLine(context.TODO()); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(pc.args) != 4 || !fileMatch(t, filepath.Join(dbgRoot, "python", "tmp", "debugpy*", "skaffold_debugpy_ports.py"), pc.args[2]) {
+		t.Fatalf("expected python -u <launch script> app:app but got %q", pc.args)
+	}
+	if diff := cmp.Diff([]string{"python", "-u", pc.args[2], "app:app"}, pc.args); diff != "" {
+		t.Errorf("args differ (-got, +want): %s", diff)
+	}
+	script, err := ioutil.ReadFile(pc.args[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(script), "runpy.run_module('gunicorn'") {
+		t.Errorf("launch script should run gunicorn:\n%s", script)
+	}
+}
+
+func TestCheckPortRangeSupported(t *testing.T) {
+	if err := checkPortRangeSupported("debugpy"); err != nil {
+		t.Error("debugpy should support port ranges:", err)
+	}
+	for _, mode := range []string{"ptvsd", "pydevd", "pydevd-pycharm"} {
+		if err := checkPortRangeSupported(mode); err == nil {
+			t.Errorf("%s should not support port ranges", mode)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Development servers whose reloader runs the app in a child process, which is re-executed
+// when the source changes.
+const (
+	// ReloaderDjango is `manage.py runserver`, whose child has RUN_MAIN=true
+	ReloaderDjango = "django"
+	// ReloaderWerkzeug is `flask run` with reloading, whose child has WERKZEUG_RUN_MAIN=true
+	ReloaderWerkzeug = "werkzeug"
+	// ReloaderUvicorn is `uvicorn --reload`, whose child is spawned with multiprocessing
+	ReloaderUvicorn = "uvicorn"
+)
+
+// reloadRetry is how long, in seconds, the serving process retries starting the backend
+// while the port is still held on behalf of the previous serving process.
+const reloadRetry = 10
+
+// detectReloader returns the reloader used by the python command-line, or "" if none.
+// The serving process is configured through the injection hook on the PYTHONPATH, and so
+// a reloader is not handled when python ignores the PYTHONPATH: the command-line is then
+// debugged as any other.
+func detectReloader(args []string, env env) string {
+	cl, err := parsePythonCommandLine(args)
+	if err != nil {
+		return ""
+	}
+	name := cl.target
+	if cl.kind == targetScript {
+		name = strings.TrimSuffix(filepath.Base(cl.target), ".py")
+	} else if cl.kind != targetModule {
+		return ""
+	}
+	has := func(options ...string) bool {
+		for _, arg := range cl.args {
+			for _, option := range options {
+				if arg == option {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	enabled := func(name string) bool {
+		b, err := strconv.ParseBool(env[name])
+		return err == nil && b
+	}
+
+	reloader := ""
+	switch name {
+	case "manage", "django-admin", "django":
+		if len(cl.args) > 0 && cl.args[0] == "runserver" && !has("--noreload") {
+			reloader = ReloaderDjango
+		}
+	case "flask":
+		if has("run") && !has("--no-reload") && (has("--reload", "--debug") || enabled("FLASK_RUN_RELOAD") || enabled("FLASK_DEBUG")) {
+			reloader = ReloaderWerkzeug
+		}
+	case "uvicorn":
+		if has("--reload") || enabled("UVICORN_RELOAD") {
+			reloader = ReloaderUvicorn
+		}
+	case "fastapi":
+		// `fastapi dev` runs uvicorn with reloading
+		if len(cl.args) > 0 && cl.args[0] == "dev" && !has("--no-reload") {
+			reloader = ReloaderUvicorn
+		}
+	}
+	if option := cl.ignoredEnvironmentOption(); reloader != "" && option != "" {
+		logrus.Warnf("python ignores PYTHONPATH with %s and so the backend is not started in the %s reloader's serving process", option, reloader)
+		return ""
+	}
+	return reloader
+}
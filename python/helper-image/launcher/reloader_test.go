@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDetectReloader(t *testing.T) {
+	tests := []struct {
+		args     []string
+		env      env
+		expected string
+	}{
+		{[]string{"python", "manage.py", "runserver", "0.0.0.0:8000"}, nil, ReloaderDjango},
+		{[]string{"python", "manage.py", "runserver", "--noreload"}, nil, ""},
+		{[]string{"python", "manage.py", "migrate"}, nil, ""},
+		{[]string{"python", "-m", "django", "runserver"}, nil, ReloaderDjango},
+		{[]string{"python", "-E", "manage.py", "runserver"}, nil, ""},
+		{[]string{"python", "/usr/local/bin/flask", "--app", "hello", "run", "--debug"}, nil, ReloaderWerkzeug},
+		{[]string{"python", "-m", "flask", "run", "--host", "0.0.0.0"}, env{"FLASK_DEBUG": "1"}, ReloaderWerkzeug},
+		{[]string{"python", "-m", "flask", "run", "--debug", "--no-reload"}, nil, ""},
+		{[]string{"python", "-m", "flask", "run"}, nil, ""},
+		{[]string{"python", "-m", "uvicorn", "main:app", "--reload"}, nil, ReloaderUvicorn},
+		{[]string{"python", "/usr/local/bin/uvicorn", "main:app"}, env{"UVICORN_RELOAD": "true"}, ReloaderUvicorn},
+		{[]string{"python", "-m", "uvicorn", "main:app"}, nil, ""},
+		{[]string{"python", "/usr/local/bin/fastapi", "dev", "main.py"}, nil, ReloaderUvicorn},
+		{[]string{"python", "/usr/local/bin/fastapi", "run", "main.py"}, nil, ""},
+		{[]string{"python", "app.py"}, nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			if result := detectReloader(test.args, test.env); result != test.expected {
+				t.Errorf("expected %q but got %q", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestPrepareReloader(t *testing.T) {
+	useEmptyDefaultPath(t)
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+	sitePackages := writeFile(t, dbgRoot, "python/lib/python3.11/site-packages/debugpy/__init__.py", "")
+	sitePackages = filepath.Dir(filepath.Dir(sitePackages))
+
+	venv := t.TempDir()
+	writeFile(t, venv, "pyvenv.cfg", "home = /usr/bin\nversion = 3.11.4\n")
+	python := writeFile(t, venv, "bin/python3", "\x7fELF")
+
+	pc := pythonContext{debugMode: ModeDebugpy, port: 5678, args: []string{python, "manage.py", "runserver", "0.0.0.0:8000"}, env: env{}}
+	if !pc.prepare(context.TODO()) {
+		t.Fatal("expected runserver to be configured")
+	}
+	if pc.reloader != ReloaderDjango {
+		t.Errorf("expected django reloader but got %q", pc.reloader)
+	}
+	// the reloader re-executes the command-line, and so it is left untouched
+	if diff := cmp.Diff([]string{python, "manage.py", "runserver", "0.0.0.0:8000"}, pc.args); diff != "" {
+		t.Errorf("args differ (-got, +want): %s", diff)
+	}
+	if len(pc.scripts) != 1 {
+		t.Fatalf("expected the hook to be written but got %v", pc.scripts)
+	}
+	if expected := pc.scripts[0] + string(filepath.ListSeparator) + sitePackages; pc.env["PYTHONPATH"] != expected {
+		t.Errorf("expected PYTHONPATH %q but got %q", expected, pc.env["PYTHONPATH"])
+	}
+	for file, expected := range map[string]string{"sitecustomize.py": "_skaffold_reloader = True", "skaffold_debug.py": "_skaffold_retry = 10"} {
+		contents, err := ioutil.ReadFile(filepath.Join(pc.scripts[0], file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("%s should contain %q", file, expected)
+		}
+	}
+}
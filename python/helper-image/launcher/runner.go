@@ -0,0 +1,479 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runner describes a package-manager runner, like `poetry run`, that runs a command in
+// the project's python environment.  Runners are bypassed by running the command directly
+// in the environment, as activated by the runner.
+type runner struct {
+	// subcommand is the runner's subcommand that runs a command
+	subcommand string
+	// valueOptions are the options that take a value, before or after the subcommand
+	valueOptions map[string]bool
+	// unsupported are the options that prevent bypassing the runner, as the command
+	// would not run in the project's environment or would run differently
+	unsupported map[string]bool
+	// conda is true if the environment is a conda environment rather than a virtualenv
+	conda bool
+	// envPrefix is true if the command may be prefixed by the name of the environment,
+	// as with `hatch run test:pytest`, which is recorded as the envOption
+	envPrefix bool
+	// envOption is the option that selects the environment by name
+	envOption string
+	// runsPython is true if the runner runs python scripts and modules given directly,
+	// as with `uv run app.py` and `uv run -m app`
+	runsPython bool
+	// environment returns the location of the python environment
+	environment func(ctx context.Context, rc runnerCommand, env env) (string, error)
+	// check, if set, returns an error if the runner would do more than activate the
+	// environment, like loading a `.env` file
+	check func(rc runnerCommand, prefix string, env env) error
+}
+
+// bypassError is returned when a runner cannot be bypassed but its command is known, in
+// which case the command can instead be debugged from the injection hook.
+type bypassError struct {
+	runner string
+	// match is the program, module, or script name of the command's python process
+	match string
+	// python is the environment's python interpreter, or "" if not known
+	python string
+	err    error
+}
+
+func (e *bypassError) Error() string {
+	return fmt.Sprintf("could not bypass %s: %v", e.runner, e.err)
+}
+
+func (e *bypassError) Unwrap() error {
+	return e.err
+}
+
+// runners are the known package-manager runners by program name.
+var runners = map[string]*runner{
+	"uv": {
+		subcommand: "run",
+		valueOptions: stringSet("--directory", "--project", "--python", "-p", "--with", "--with-editable", "--with-requirements",
+			"--extra", "--group", "--only-group", "--no-group", "--package", "--env-file", "--index", "--default-index",
+			"--index-url", "-i", "--extra-index-url", "--find-links", "-f", "--config-file", "--cache-dir", "--color",
+			"--python-preference", "--index-strategy", "--keyring-provider", "--resolution", "--prerelease", "--exclude-newer",
+			"--link-mode", "--refresh-package", "--reinstall-package", "--upgrade-package", "-P", "--no-binary-package",
+			"--no-build-package", "--config-setting", "-C", "--allow-insecure-host", "--python-platform"),
+		unsupported: stringSet("--with", "--with-editable", "--with-requirements", "--isolated", "--script", "--gui-script",
+			"--python", "-p", "--directory", "--no-project", "--active", "--env-file"),
+		runsPython:  true,
+		environment: uvEnvironment,
+		check:       uvCheck,
+	},
+	"poetry": {
+		subcommand:   "run",
+		valueOptions: stringSet("-C", "--directory", "-P", "--project"),
+		environment:  poetryEnvironment,
+	},
+	"pipenv": {
+		subcommand:   "run",
+		valueOptions: stringSet("--python"),
+		environment:  pipenvEnvironment,
+		check:        pipenvCheck,
+	},
+	"conda": {
+		subcommand:   "run",
+		valueOptions: stringSet("-n", "--name", "-p", "--prefix", "--cwd"),
+		unsupported:  stringSet("--cwd"),
+		conda:        true,
+		environment:  condaEnvironment,
+		check:        condaCheck,
+	},
+	"hatch": {
+		subcommand:   "run",
+		valueOptions: stringSet("-e", "--env", "-p", "--project", "--data-dir", "--cache-dir", "--config"),
+		envPrefix:    true,
+		envOption:    "--env",
+		environment:  hatchEnvironment,
+	},
+}
+
+func init() {
+	// mamba and micromamba are compatible with conda
+	runners["mamba"] = runners["conda"]
+	runners["micromamba"] = runners["conda"]
+}
+
+// runnerCommand is a parsed runner command-line of the form:
+//
+//	runner [option ...] subcommand [option ...] [--] command [arg ...]
+type runnerCommand struct {
+	// program is the location of the runner
+	program string
+	// globalOptions are the options preceding the subcommand, as provided
+	globalOptions []string
+	// options are the values of the options, with "" for options without a value
+	options map[string]string
+	// command is the command to be run in the environment
+	command []string
+}
+
+// option returns the value of the first of the named options that was provided.
+func (rc runnerCommand) option(names ...string) (string, bool) {
+	for _, name := range names {
+		if v, found := rc.options[name]; found {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// findRunner returns the runner for the given program, or nil if it is not a known runner.
+func findRunner(p string) *runner {
+	return runners[filepath.Base(p)]
+}
+
+// parse parses the runner command-line, where args[0] is the runner found at p.  The
+// command is returned along with the error for an option that prevents bypassing the runner.
+func (r *runner) parse(p string, args []string) (runnerCommand, error) {
+	rc := runnerCommand{program: p, options: map[string]string{}}
+	unsupported := ""
+	subcommand := false
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" && subcommand {
+			i++
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			if subcommand {
+				break
+			}
+			if arg != r.subcommand {
+				return runnerCommand{}, fmt.Errorf("not a %q command: %q", r.subcommand, args)
+			}
+			subcommand = true
+			continue
+		}
+		start := i
+		name, value := arg, ""
+		switch {
+		case strings.HasPrefix(arg, "--") && strings.Contains(arg, "="):
+			eq := strings.IndexByte(arg, '=')
+			name, value = arg[:eq], arg[eq+1:]
+		case !strings.HasPrefix(arg, "--") && len(arg) > 2 && r.valueOptions[arg[:2]]:
+			name, value = arg[:2], arg[2:]
+		case r.valueOptions[arg]:
+			if i+1 >= len(args) {
+				return runnerCommand{}, fmt.Errorf("option %q requires a value", arg)
+			}
+			i++
+			value = args[i]
+		}
+		if r.unsupported[name] && unsupported == "" {
+			unsupported = name
+		}
+		rc.options[name] = value
+		if !subcommand {
+			rc.globalOptions = append(rc.globalOptions, args[start:i+1]...)
+		}
+	}
+	if !subcommand || i >= len(args) {
+		return runnerCommand{}, fmt.Errorf("no command to run: %q", args)
+	}
+	rc.command = append([]string(nil), args[i:]...)
+
+	if r.envPrefix {
+		if c := strings.IndexByte(rc.command[0], ':'); c > 0 && !strings.Contains(rc.command[0][:c], "/") {
+			rc.options[r.envOption] = rc.command[0][:c]
+			rc.command[0] = rc.command[0][c+1:]
+		}
+	}
+	if r.runsPython {
+		if _, found := rc.option("-m", "--module"); found {
+			rc.command = append([]string{"python", "-m"}, rc.command...)
+		} else if ext := filepath.Ext(rc.command[0]); ext == ".py" || ext == ".pyw" {
+			rc.command = append([]string{"python"}, rc.command...)
+		}
+	}
+	if unsupported != "" {
+		return rc, fmt.Errorf("cannot bypass %s with %q", filepath.Base(p), unsupported)
+	}
+	return rc, nil
+}
+
+// commandName returns the program, module, or script name by which the injection hook
+// recognizes the python process of the command.
+func commandName(command []string) string {
+	if isPythonInterpreter(command[0]) {
+		if cl, err := parsePythonCommandLine(command); err == nil && cl.target != "" {
+			if cl.kind == targetModule {
+				return cl.target
+			}
+			return filepath.Base(cl.target)
+		}
+	}
+	return filepath.Base(command[0])
+}
+
+// bypassRunner rewrites the command-line to run the runner's command directly in the
+// runner's python environment, with the environment activated as the runner would.
+// A bypassError is returned if the runner cannot be bypassed once its command is known.
+func (pc *pythonContext) bypassRunner(ctx context.Context, r *runner, p string) error {
+	rc, err := r.parse(p, pc.args)
+	if rc.command == nil {
+		return err
+	}
+	bypass := &bypassError{runner: filepath.Base(p), match: commandName(rc.command)}
+	if err != nil {
+		bypass.err = err
+		return bypass
+	}
+	prefix, err := r.environment(ctx, rc, pc.env)
+	if err != nil {
+		bypass.err = err
+		return bypass
+	}
+	bin := filepath.Join(prefix, "bin")
+	if !pathExists(bin) {
+		bypass.err = fmt.Errorf("environment %q has no bin directory", prefix)
+		return bypass
+	}
+	for _, name := range []string{"python", "python3"} {
+		if python := filepath.Join(bin, name); bypass.python == "" && pathExists(python) {
+			bypass.python = python
+		}
+	}
+	if r.check != nil {
+		if err := r.check(rc, prefix, pc.env); err != nil {
+			bypass.err = err
+			return bypass
+		}
+	}
+
+	activated := env{}
+	for k, v := range pc.env {
+		activated[k] = v
+	}
+	if r.conda {
+		activated["CONDA_PREFIX"] = prefix
+		activated["CONDA_DEFAULT_ENV"] = prefix
+		if name, found := rc.option("-n", "--name"); found {
+			activated["CONDA_DEFAULT_ENV"] = name
+		}
+	} else {
+		activated["VIRTUAL_ENV"] = prefix
+		delete(activated, "PYTHONHOME")
+	}
+	path, found := pc.env["PATH"]
+	if !found {
+		path = defaultPath
+	}
+	activated["PATH"] = bin + string(filepath.ListSeparator) + path
+
+	// the command is run by the launcher and so must be resolved in the activated PATH
+	command, err := lookPath(rc.command[0], activated)
+	if err != nil {
+		bypass.err = fmt.Errorf("%q is not a command in environment %q: %w", rc.command[0], prefix, err)
+		return bypass
+	}
+	logrus.Infof("bypassing %s to run %q in environment %q", filepath.Base(p), rc.command[0], prefix)
+	pc.env = activated
+	pc.args = append([]string{command}, rc.command[1:]...)
+	return nil
+}
+
+// uvEnvironment returns the project environment used by `uv run`: `.venv` in the project
+// root, or as set by UV_PROJECT_ENVIRONMENT.
+func uvEnvironment(_ context.Context, rc runnerCommand, env env) (string, error) {
+	dir, _ := rc.option("--project")
+	root, err := findProjectRoot(dir, "pyproject.toml")
+	if err != nil {
+		return "", err
+	}
+	venv := env["UV_PROJECT_ENVIRONMENT"]
+	if venv == "" {
+		venv = ".venv"
+	}
+	if !filepath.IsAbs(venv) {
+		venv = filepath.Join(root, venv)
+	}
+	return venv, nil
+}
+
+// uvCheck returns an error if `uv run` would load an environment file.  uv otherwise
+// syncs the environment, which is expected to be synced already should it exist.
+func uvCheck(_ runnerCommand, _ string, env env) error {
+	if f := env["UV_ENV_FILE"]; f != "" {
+		return fmt.Errorf("uv would load %q", f)
+	}
+	return nil
+}
+
+// poetryEnvironment returns the environment used by `poetry run`: an in-project `.venv`,
+// or otherwise as reported by `poetry env info --path`.
+func poetryEnvironment(ctx context.Context, rc runnerCommand, env env) (string, error) {
+	dir, _ := rc.option("-P", "--project", "-C", "--directory")
+	if root, err := findProjectRoot(dir, "pyproject.toml"); err == nil && pathExists(filepath.Join(root, ".venv")) {
+		return filepath.Join(root, ".venv"), nil
+	}
+	return runnerOutput(ctx, rc, env, "env", "info", "--path")
+}
+
+// pipenvEnvironment returns the environment used by `pipenv run`: an in-project `.venv`,
+// or otherwise as reported by `pipenv --venv`.
+func pipenvEnvironment(ctx context.Context, rc runnerCommand, env env) (string, error) {
+	pipfile := pipenvPipfile(env)
+	if venv := filepath.Join(filepath.Dir(pipfile), ".venv"); pipfile != "" && pathExists(venv) {
+		return venv, nil
+	}
+	return runnerOutput(ctx, rc, env, "--venv")
+}
+
+// pipenvPipfile returns the project's Pipfile, or "" if not found.
+func pipenvPipfile(env env) string {
+	if pipfile := env["PIPENV_PIPFILE"]; pipfile != "" {
+		return pipfile
+	}
+	if root, err := findProjectRoot("", "Pipfile"); err == nil {
+		return filepath.Join(root, "Pipfile")
+	}
+	return ""
+}
+
+// pipenvCheck returns an error if `pipenv run` would load the project's `.env` file.
+func pipenvCheck(_ runnerCommand, _ string, env env) error {
+	if skip, err := strconv.ParseBool(env["PIPENV_DONT_LOAD_ENV"]); err == nil && skip {
+		return nil
+	}
+	dotenv := env["PIPENV_DOTENV_LOCATION"]
+	if pipfile := pipenvPipfile(env); dotenv == "" && pipfile != "" {
+		dotenv = filepath.Join(filepath.Dir(pipfile), ".env")
+	}
+	if dotenv != "" && pathExists(dotenv) {
+		return fmt.Errorf("pipenv would load %q", dotenv)
+	}
+	return nil
+}
+
+// hatchEnvironment returns the environment used by `hatch run`, as reported by `hatch env find`.
+// The environment may be selected with `--env` or with an `env:` prefix to the command.
+func hatchEnvironment(ctx context.Context, rc runnerCommand, env env) (string, error) {
+	name, _ := rc.option("--env", "-e")
+	if name == "" {
+		name = env["HATCH_ENV"]
+	}
+	args := []string{"env", "find"}
+	if name != "" {
+		args = append(args, name)
+	}
+	return runnerOutput(ctx, rc, env, args...)
+}
+
+// condaEnvironment returns the environment prefix used by `conda run`: the prefix given
+// with `--prefix`, the environment named with `--name`, or otherwise the active environment.
+func condaEnvironment(_ context.Context, rc runnerCommand, env env) (string, error) {
+	if prefix, found := rc.option("-p", "--prefix"); found {
+		return prefix, nil
+	}
+	root := env["MAMBA_ROOT_PREFIX"]
+	if root == "" && env["CONDA_EXE"] != "" {
+		root = filepath.Dir(filepath.Dir(env["CONDA_EXE"]))
+	}
+	if root == "" {
+		// conda is found in <root>/bin or <root>/condabin
+		root = filepath.Dir(filepath.Dir(rc.program))
+	}
+	name, found := rc.option("-n", "--name")
+	if !found {
+		if prefix := env["CONDA_PREFIX"]; prefix != "" {
+			return prefix, nil
+		}
+		name = "base"
+	}
+	if name == "base" {
+		return root, nil
+	}
+	dirs := append(filepath.SplitList(env["CONDA_ENVS_PATH"]), filepath.Join(root, "envs"))
+	for _, dir := range dirs {
+		if prefix := filepath.Join(dir, name); dir != "" && pathExists(prefix) {
+			return prefix, nil
+		}
+	}
+	return "", fmt.Errorf("conda environment %q not found in %v", name, dirs)
+}
+
+// condaCheck returns an error if activating the conda environment would run activation
+// scripts, which packages install to set up the environment.
+func condaCheck(_ runnerCommand, prefix string, _ env) error {
+	scripts, _ := filepath.Glob(filepath.Join(prefix, "etc", "conda", "activate.d", "*"))
+	if len(scripts) > 0 {
+		return fmt.Errorf("conda would run the activation scripts in %q", filepath.Dir(scripts[0]))
+	}
+	return nil
+}
+
+// runnerOutput runs the runner with its global options and the given arguments and
+// returns the trimmed output.
+func runnerOutput(ctx context.Context, rc runnerCommand, env env, args ...string) (string, error) {
+	cmdline := append(append([]string{rc.program}, rc.globalOptions...), args...)
+	out, err := newCommand(ctx, cmdline, env).Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to determine environment with %q: %w", cmdline, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// findProjectRoot returns the closest directory, starting from dir or the working directory,
+// that contains the marker file.
+func findProjectRoot(dir, marker string) (string, error) {
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		dir = wd
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		if pathExists(filepath.Join(d, marker)) {
+			return d, nil
+		}
+		if d == filepath.Dir(d) {
+			return "", fmt.Errorf("no %s found from %q", marker, dir)
+		}
+	}
+}
+
+// stringSet returns a set of the given strings.
+func stringSet(values ...string) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
@@ -0,0 +1,356 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRunnerParse(t *testing.T) {
+	tests := []struct {
+		description   string
+		args          []string
+		shouldErr     bool
+		globalOptions []string
+		options       map[string]string
+		command       []string
+	}{
+		{
+			description: "uv run",
+			args:        []string{"uv", "run", "gunicorn", "-w", "4"},
+			options:     map[string]string{},
+			command:     []string{"gunicorn", "-w", "4"},
+		},
+		{
+			description:   "uv options",
+			args:          []string{"uv", "--project", "/app", "run", "--frozen", "--extra=web", "--group", "dev", "--", "flask", "run"},
+			globalOptions: []string{"--project", "/app"},
+			options:       map[string]string{"--project": "/app", "--frozen": "", "--extra": "web", "--group": "dev"},
+			command:       []string{"flask", "run"},
+		},
+		{
+			description: "uv script",
+			args:        []string{"uv", "run", "app.py", "--debug"},
+			options:     map[string]string{},
+			command:     []string{"python", "app.py", "--debug"},
+		},
+		{
+			description: "uv module",
+			args:        []string{"uv", "run", "-m", "app", "--debug"},
+			options:     map[string]string{"-m": ""},
+			command:     []string{"python", "-m", "app", "--debug"},
+		},
+		{
+			description: "uv run --with",
+			args:        []string{"uv", "run", "--with", "rich", "app.py"},
+			shouldErr:   true,
+		},
+		{
+			description: "uv run --isolated",
+			args:        []string{"uv", "run", "--isolated", "app.py"},
+			shouldErr:   true,
+		},
+		{
+			description: "uv sync",
+			args:        []string{"uv", "sync"},
+			shouldErr:   true,
+		},
+		{
+			description: "no command",
+			args:        []string{"poetry", "run"},
+			shouldErr:   true,
+		},
+		{
+			description:   "poetry",
+			args:          []string{"poetry", "-C", "/app", "run", "python", "app.py"},
+			globalOptions: []string{"-C", "/app"},
+			options:       map[string]string{"-C": "/app"},
+			command:       []string{"python", "app.py"},
+		},
+		{
+			description: "conda",
+			args:        []string{"conda", "run", "-nml", "--no-capture-output", "python", "app.py"},
+			options:     map[string]string{"-n": "ml", "--no-capture-output": ""},
+			command:     []string{"python", "app.py"},
+		},
+		{
+			description: "conda --cwd",
+			args:        []string{"conda", "run", "-n", "ml", "--cwd", "/app", "python", "app.py"},
+			shouldErr:   true,
+		},
+		{
+			description: "hatch environment prefix",
+			args:        []string{"hatch", "run", "test:pytest", "-x"},
+			options:     map[string]string{"--env": "test"},
+			command:     []string{"pytest", "-x"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			rc, err := findRunner(test.args[0]).parse("/usr/bin/"+test.args[0], test.args)
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %+v", rc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if diff := cmp.Diff(test.globalOptions, rc.globalOptions); diff != "" {
+				t.Errorf("global options differ (-got, +want): %s", diff)
+			}
+			if diff := cmp.Diff(test.options, rc.options); diff != "" {
+				t.Errorf("options differ (-got, +want): %s", diff)
+			}
+			if diff := cmp.Diff(test.command, rc.command); diff != "" {
+				t.Errorf("command differs (-got, +want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestRunnerEnvironment(t *testing.T) {
+	project := t.TempDir()
+	writeFile(t, project, "pyproject.toml", "[project]\nname = \"app\"\n")
+	writeFile(t, project, "Pipfile", "[packages]\n")
+	writeFile(t, project, "src/app.py", "")
+	conda := t.TempDir()
+	writeFile(t, conda, "bin/conda", "")
+	writeFile(t, conda, "envs/ml/bin/python", "")
+
+	tests := []struct {
+		description string
+		args        []string
+		env         env
+		commands    commands
+		shouldErr   bool
+		expected    string
+	}{
+		{
+			description: "uv .venv",
+			args:        []string{"uv", "run", "--project", filepath.Join(project, "src"), "app"},
+			expected:    filepath.Join(project, ".venv"),
+		},
+		{
+			description: "uv UV_PROJECT_ENVIRONMENT",
+			args:        []string{"uv", "run", "--project", project, "app"},
+			env:         env{"UV_PROJECT_ENVIRONMENT": "/opt/venv"},
+			expected:    "/opt/venv",
+		},
+		{
+			description: "uv no project",
+			args:        []string{"uv", "run", "--project", conda, "app"},
+			shouldErr:   true,
+		},
+		{
+			description: "poetry env info",
+			args:        []string{"poetry", "-P", conda, "run", "app"},
+			commands:    RunCmdOut([]string{"/usr/bin/poetry", "-P", conda, "env", "info", "--path"}, "/root/.cache/pypoetry/virtualenvs/app-x-py3.11\n"),
+			expected:    "/root/.cache/pypoetry/virtualenvs/app-x-py3.11",
+		},
+		{
+			description: "poetry failure",
+			args:        []string{"poetry", "-P", conda, "run", "app"},
+			commands:    RunCmdOutFail([]string{"/usr/bin/poetry", "-P", conda, "env", "info", "--path"}, "", 1),
+			shouldErr:   true,
+		},
+		{
+			description: "pipenv --venv",
+			args:        []string{"pipenv", "run", "app"},
+			env:         env{"PIPENV_PIPFILE": filepath.Join(project, "Pipfile")},
+			commands:    RunCmdOut([]string{"/usr/bin/pipenv", "--venv"}, "/root/.local/share/virtualenvs/app-x\n"),
+			expected:    "/root/.local/share/virtualenvs/app-x",
+		},
+		{
+			description: "hatch env find",
+			args:        []string{"hatch", "run", "test:pytest"},
+			commands:    RunCmdOut([]string{"/usr/bin/hatch", "env", "find", "test"}, "/root/.local/share/hatch/env/virtual/app/x/test\n"),
+			expected:    "/root/.local/share/hatch/env/virtual/app/x/test",
+		},
+		{
+			description: "conda prefix",
+			args:        []string{"conda", "run", "-p", "/opt/env", "app"},
+			expected:    "/opt/env",
+		},
+		{
+			description: "conda name",
+			args:        []string{"conda", "run", "-n", "ml", "app"},
+			env:         env{"CONDA_EXE": filepath.Join(conda, "bin/conda")},
+			expected:    filepath.Join(conda, "envs/ml"),
+		},
+		{
+			description: "conda base",
+			args:        []string{"conda", "run", "-n", "base", "app"},
+			env:         env{"CONDA_EXE": filepath.Join(conda, "bin/conda")},
+			expected:    conda,
+		},
+		{
+			description: "conda unknown name",
+			args:        []string{"conda", "run", "-n", "other", "app"},
+			env:         env{"CONDA_EXE": filepath.Join(conda, "bin/conda")},
+			shouldErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			test.commands.Setup(t)
+			if test.env == nil {
+				test.env = env{}
+			}
+			r := findRunner(test.args[0])
+			rc, err := r.parse("/usr/bin/"+test.args[0], test.args)
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			result, err := r.environment(context.TODO(), rc, test.env)
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %q", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if result != test.expected {
+				t.Errorf("expected %q but got %q", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestUnwrapLauncherWithRunners(t *testing.T) {
+	useEmptyDefaultPath(t)
+	project := t.TempDir()
+	writeFile(t, project, "pyproject.toml", "[project]\nname = \"app\"\n")
+	python := writeFile(t, project, ".venv/bin/python", "\x7fELF")
+	gunicorn := writeFile(t, project, ".venv/bin/gunicorn", "#!"+python+"\nprint('hi')\n")
+	bin := t.TempDir()
+	writeFile(t, bin, "uv", "\x7fELF")
+
+	pc := pythonContext{args: []string{"uv", "--project", project, "run", "gunicorn", "app:app"}, env: env{"PATH": bin, "PYTHONHOME": "/usr"}}
+	if err := pc.unwrapLauncher(context.TODO()); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if diff := cmp.Diff([]string{python, gunicorn, "app:app"}, pc.args); diff != "" {
+		t.Errorf("args differ (-got, +want): %s", diff)
+	}
+	expectedEnv := env{"PATH": filepath.Join(project, ".venv/bin") + ":" + bin, "VIRTUAL_ENV": filepath.Join(project, ".venv")}
+	if diff := cmp.Diff(expectedEnv, pc.env); diff != "" {
+		t.Errorf("env differs (-got, +want): %s", diff)
+	}
+
+	// `uv run app.py` runs the environment's python
+	pc = pythonContext{args: []string{"uv", "run", "--project", project, "app.py"}, env: env{"PATH": bin}}
+	if err := pc.unwrapLauncher(context.TODO()); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if diff := cmp.Diff([]string{python, "app.py"}, pc.args); diff != "" {
+		t.Errorf("args differ (-got, +want): %s", diff)
+	}
+
+	// a command that is not in the environment, like a hatch script, cannot be bypassed
+	pc = pythonContext{args: []string{"uv", "run", "--project", project, "lint"}, env: env{"PATH": bin}}
+	var bypass *bypassError
+	if err := pc.unwrapLauncher(context.TODO()); !errors.As(err, &bypass) {
+		t.Errorf("expected a bypass error but got %v: %v", err, pc.args)
+	} else if bypass.match != "lint" || bypass.python != python {
+		t.Errorf("expected lint in %q but got %q in %q", python, bypass.match, bypass.python)
+	}
+}
+
+func TestRunnerCheck(t *testing.T) {
+	useEmptyDefaultPath(t)
+	project := t.TempDir()
+	writeFile(t, project, "Pipfile", "")
+	writeFile(t, project, ".venv/bin/python", "\x7fELF")
+	writeFile(t, project, ".venv/bin/gunicorn", "#!"+filepath.Join(project, ".venv/bin/python")+"\n")
+	dotenv := writeFile(t, project, ".env", "DEBUG=1\n")
+	conda := t.TempDir()
+	writeFile(t, conda, "bin/python", "\x7fELF")
+	writeFile(t, conda, "etc/conda/activate.d/gdal-activate.sh", "")
+	bin := t.TempDir()
+	for _, r := range []string{"pipenv", "uv", "conda"} {
+		writeFile(t, bin, r, "\x7fELF")
+	}
+
+	tests := []struct {
+		description string
+		args        []string
+		env         env
+		match       string
+		shouldErr   bool
+	}{
+		{
+			description: "pipenv loads .env",
+			args:        []string{"pipenv", "run", "gunicorn", "app:app"},
+			env:         env{"PIPENV_PIPFILE": filepath.Join(project, "Pipfile")},
+			match:       "gunicorn",
+			shouldErr:   true,
+		},
+		{
+			description: "pipenv with PIPENV_DONT_LOAD_ENV",
+			args:        []string{"pipenv", "run", "gunicorn", "app:app"},
+			env:         env{"PIPENV_PIPFILE": filepath.Join(project, "Pipfile"), "PIPENV_DONT_LOAD_ENV": "1"},
+		},
+		{
+			description: "pipenv with PIPENV_DOTENV_LOCATION",
+			args:        []string{"pipenv", "run", "python", "-m", "app"},
+			env:         env{"PIPENV_PIPFILE": filepath.Join(project, "Pipfile"), "PIPENV_DOTENV_LOCATION": dotenv},
+			match:       "app",
+			shouldErr:   true,
+		},
+		{
+			description: "uv with UV_ENV_FILE",
+			args:        []string{"uv", "run", "--project", project, "app.py"},
+			env:         env{"UV_ENV_FILE": dotenv},
+			match:       "app.py",
+			shouldErr:   true,
+		},
+		{
+			description: "conda with activation scripts",
+			args:        []string{"conda", "run", "-p", conda, "python", "app.py"},
+			match:       "app.py",
+			shouldErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := env{"PATH": bin}
+			for k, v := range test.env {
+				e[k] = v
+			}
+			pc := pythonContext{args: test.args, env: e}
+			err := pc.unwrapLauncher(context.TODO())
+			var bypass *bypassError
+			switch {
+			case !test.shouldErr && err != nil:
+				t.Error("unexpected error:", err)
+			case test.shouldErr && !errors.As(err, &bypass):
+				t.Errorf("expected a bypass error but got %v: %v", err, pc.args)
+			case test.shouldErr && bypass.match != test.match:
+				t.Errorf("expected %q to be debugged but got %q", test.match, bypass.match)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sessionSchemaVersion is the version of the session descriptor schema, as documented
+// in the top-level README.  It must be incremented on incompatible changes.
+const sessionSchemaVersion = 1
+
+// sessionDescriptor describes a debug session set up by a launcher.  It is written to
+// `<helpers>/sessions/<pid>.json` while the app is running.  The Node wrapper writes
+// the same schema.
+type sessionDescriptor struct {
+	SchemaVersion       int      `json:"schemaVersion"`
+	Runtime             string   `json:"runtime"`
+	RuntimeVersion      string   `json:"runtimeVersion,omitempty"`
+	Implementation      string   `json:"implementation,omitempty"`
+	Protocol            string   `json:"protocol"`
+	Backend             string   `json:"backend"`
+	BackendVersion      string   `json:"backendVersion,omitempty"`
+	ModeReason          string   `json:"modeReason,omitempty"`
+	Address             string   `json:"address"`
+	Port                uint     `json:"port"`
+	PortRange           string   `json:"portRange,omitempty"`
+	Connect             bool     `json:"connect,omitempty"`
+	Wait                bool     `json:"wait"`
+	WaitTimeout         uint     `json:"waitTimeout,omitempty"`
+	WaitTimeoutAction   string   `json:"waitTimeoutAction,omitempty"`
+	PID                 int      `json:"pid"`
+	LauncherPID         int      `json:"launcherPid"`
+	WorkingDirectory    string   `json:"workingDirectory,omitempty"`
+	OriginalCommandLine []string `json:"originalCommandLine"`
+	CommandLine         []string `json:"commandLine"`
+}
+
+// backendDistributions are the python distribution names of the debugging backends,
+// as used for their `.dist-info` directories.
+var backendDistributions = map[string]string{
+	ModeDebugpy:       "debugpy",
+	ModePtvsd:         "ptvsd",
+	ModePydevd:        "pydevd",
+	ModePydevdPycharm: "pydevd_pycharm",
+}
+
+// sessionsDir returns the directory where session descriptors are written.
+func sessionsDir() string {
+	return filepath.Join(dbgRoot, "sessions")
+}
+
+// newSessionDescriptor describes the debug session for the app with the given process ID.
+func (pc *pythonContext) newSessionDescriptor(pid int) sessionDescriptor {
+	sd := sessionDescriptor{
+		SchemaVersion:       sessionSchemaVersion,
+		Runtime:             "python",
+		RuntimeVersion:      pc.version.String(),
+		Implementation:      pc.implementation,
+		Backend:             pc.debugMode,
+		BackendVersion:      pc.backendVersion(),
+		ModeReason:          pc.modeReason,
+		Port:                pc.port,
+		Wait:                pc.wait,
+		PID:                 pid,
+		LauncherPID:         os.Getpid(),
+		OriginalCommandLine: pc.originalArgs,
+		CommandLine:         pc.args,
+	}
+	switch pc.debugMode {
+	case ModeDebugpy:
+		// `--listen <port>` binds to the loopback interface
+		sd.Protocol, sd.Address = "dap", "127.0.0.1"
+	case ModePtvsd:
+		sd.Protocol, sd.Address = "dap", "localhost"
+	case ModePydevd, ModePydevdPycharm:
+		// pydevd's server binds to all interfaces
+		sd.Protocol, sd.Address = "pydevd", "0.0.0.0"
+	}
+	if !pc.portRange.isEmpty() {
+		sd.PortRange = pc.portRange.String()
+		sd.Address = "localhost"
+	}
+	if pc.timedWait() {
+		sd.WaitTimeout, sd.WaitTimeoutAction = pc.waitTimeout, pc.waitTimeoutAction
+	}
+	if pc.connect != "" {
+		// the backend connects to the debugger
+		sd.Connect = true
+		sd.Address = pc.connectHost()
+	}
+	if wd, err := os.Getwd(); err == nil {
+		sd.WorkingDirectory = wd
+	}
+	return sd
+}
+
+// backendVersion returns the version of the backend from its `.dist-info` directory,
+// looking first in the bundled location and then where installed with the app.
+func (pc *pythonContext) backendVersion() string {
+	dist := backendDistributions[pc.debugMode]
+	if dist == "" {
+		return ""
+	}
+	dirs := []string{pc.libraryPath(pc.debugMode)}
+	if where := pc.findInstalledBackend(pc.debugMode); where != "" {
+		dirs = append(dirs, where)
+	}
+	for _, dir := range dirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, dist+"-*.dist-info"))
+		for _, m := range matches {
+			version := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), dist+"-"), ".dist-info")
+			if version != "" {
+				return version
+			}
+		}
+	}
+	return ""
+}
+
+// writeSessionDescriptor writes the session descriptor and returns its location.
+func writeSessionDescriptor(sd sessionDescriptor) (string, error) {
+	data, err := json.MarshalIndent(sd, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	dir := sessionsDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("unable to create sessions directory: %w", err)
+	}
+	f := filepath.Join(dir, fmt.Sprintf("%d.json", sd.PID))
+	if err := writeFileAtomic(f, data); err != nil {
+		return "", fmt.Errorf("unable to write session descriptor: %w", err)
+	}
+	logrus.Debugf("wrote session descriptor %q", f)
+	return f, nil
+}
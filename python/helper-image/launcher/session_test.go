@@ -0,0 +1,125 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestNewSessionDescriptor(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+	useEmptyDefaultPath(t)
+	writeFile(t, dbgRoot, "python/lib/python3.9/site-packages/debugpy-1.6.7.dist-info/METADATA", "")
+	writeFile(t, dbgRoot, "python/pydevd/python3.9/lib/python3.9/site-packages/pydevd-2.9.5.dist-info/METADATA", "")
+	// pydevd-pycharm installed with the app
+	appLib := t.TempDir()
+	writeFile(t, appLib, "pydevd_pycharm.py", "")
+	writeFile(t, appLib, "pydevd_pycharm-231.9011.38.dist-info/METADATA", "")
+
+	py39 := pythonVersion{major: 3, minor: 9, patch: 1}
+	tests := []struct {
+		description string
+		pc          pythonContext
+		expected    sessionDescriptor
+	}{
+		{
+			description: "debugpy",
+			pc:          pythonContext{debugMode: "debugpy", port: 5678, version: py39, implementation: "cpython", originalArgs: []string{"app.py"}, args: []string{"python", "-m", "debugpy", "--listen", "5678", "app.py"}},
+			expected:    sessionDescriptor{Runtime: "python", RuntimeVersion: "3.9.1", Implementation: "cpython", Protocol: "dap", Backend: "debugpy", BackendVersion: "1.6.7", Address: "127.0.0.1", Port: 5678, OriginalCommandLine: []string{"app.py"}, CommandLine: []string{"python", "-m", "debugpy", "--listen", "5678", "app.py"}},
+		},
+		{
+			description: "debugpy chosen by auto mode",
+			pc:          pythonContext{debugMode: "debugpy", modeReason: "bundled for cpython 3.9.1", port: 5678, version: py39, implementation: "cpython", args: []string{"python", "app.py"}},
+			expected:    sessionDescriptor{Runtime: "python", RuntimeVersion: "3.9.1", Implementation: "cpython", Protocol: "dap", Backend: "debugpy", BackendVersion: "1.6.7", ModeReason: "bundled for cpython 3.9.1", Address: "127.0.0.1", Port: 5678, CommandLine: []string{"python", "app.py"}},
+		},
+		{
+			description: "debugpy with port range",
+			pc:          pythonContext{debugMode: "debugpy", port: 5678, portRange: portRange{5678, 5680}, wait: true, waitTimeout: 30, waitTimeoutAction: "exit", version: py39, implementation: "cpython", args: []string{"python", "/tmp/x.py"}},
+			expected:    sessionDescriptor{Runtime: "python", RuntimeVersion: "3.9.1", Implementation: "cpython", Protocol: "dap", Backend: "debugpy", BackendVersion: "1.6.7", Address: "localhost", Port: 5678, PortRange: "5678-5680", Wait: true, WaitTimeout: 30, WaitTimeoutAction: "exit", CommandLine: []string{"python", "/tmp/x.py"}},
+		},
+		{
+			description: "debugpy connect",
+			pc:          pythonContext{debugMode: "debugpy", port: 5678, connect: "ide:5678", version: py39, implementation: "cpython", args: []string{"python", "-m", "debugpy", "--connect", "ide:5678", "app.py"}},
+			expected:    sessionDescriptor{Runtime: "python", RuntimeVersion: "3.9.1", Implementation: "cpython", Protocol: "dap", Backend: "debugpy", BackendVersion: "1.6.7", Address: "ide", Port: 5678, Connect: true, CommandLine: []string{"python", "-m", "debugpy", "--connect", "ide:5678", "app.py"}},
+		},
+		{
+			description: "ptvsd without version information",
+			pc:          pythonContext{debugMode: "ptvsd", port: 5678, version: py39, implementation: "cpython", args: []string{"python"}},
+			expected:    sessionDescriptor{Runtime: "python", RuntimeVersion: "3.9.1", Implementation: "cpython", Protocol: "dap", Backend: "ptvsd", Address: "localhost", Port: 5678, CommandLine: []string{"python"}},
+		},
+		{
+			description: "pydevd",
+			pc:          pythonContext{debugMode: "pydevd", port: 5678, version: py39, implementation: "cpython", args: []string{"python"}},
+			expected:    sessionDescriptor{Runtime: "python", RuntimeVersion: "3.9.1", Implementation: "cpython", Protocol: "pydevd", Backend: "pydevd", BackendVersion: "2.9.5", Address: "0.0.0.0", Port: 5678, CommandLine: []string{"python"}},
+		},
+		{
+			description: "pydevd-pycharm installed with app",
+			pc:          pythonContext{debugMode: "pydevd-pycharm", port: 5678, version: py39, implementation: "cpython", args: []string{"python"}, env: env{"PYTHONPATH": appLib}},
+			expected:    sessionDescriptor{Runtime: "python", RuntimeVersion: "3.9.1", Implementation: "cpython", Protocol: "pydevd", Backend: "pydevd-pycharm", BackendVersion: "231.9011.38", Address: "0.0.0.0", Port: 5678, CommandLine: []string{"python"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			result := test.pc.newSessionDescriptor(1234)
+			test.expected.SchemaVersion = sessionSchemaVersion
+			test.expected.PID = 1234
+			test.expected.LauncherPID = os.Getpid()
+			test.expected.WorkingDirectory, _ = os.Getwd()
+			if diff := cmp.Diff(test.expected, result); diff != "" {
+				t.Errorf("%T differ (-got, +want): %s", result, diff)
+			}
+		})
+	}
+}
+
+func TestWriteSessionDescriptor(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+
+	sd := sessionDescriptor{SchemaVersion: sessionSchemaVersion, Runtime: "python", Protocol: "dap", Backend: "debugpy", Address: "127.0.0.1", Port: 5678, PID: 42, CommandLine: []string{"python", "app.py"}}
+	f, err := writeSessionDescriptor(sd)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if f != filepath.Join(dbgRoot, "sessions", "42.json") {
+		t.Errorf("unexpected location %q", f)
+	}
+	data, err := ioutil.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result sessionDescriptor
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal("invalid descriptor:", err)
+	}
+	if diff := cmp.Diff(sd, result, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("%T differ (-got, +want): %s", result, diff)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dbgRoot, "sessions", ".42.json-*")); len(matches) > 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	shell "github.com/kballard/go-shellquote"
+)
+
+// maxUnwrapDepth bounds the number of scripts and `env` invocations that are unwrapped.
+// Linux allows at most 4 levels of interpreter scripts; we allow some extra for `env`.
+const maxUnwrapDepth = 8
+
+// defaultPath is the search path used by execvp(3) when PATH is not set.
+var defaultPath = "/bin:/usr/bin"
+
+// lookPath resolves an executable as execvp(3) and env(1) would: names containing a
+// slash are used as-is and other names are searched in the PATH of the given environment.
+func lookPath(file string, env env) (string, error) {
+	if strings.Contains(file, "/") {
+		if _, err := os.Stat(file); err != nil {
+			return "", fmt.Errorf("could not access launcher %q: %w", file, err)
+		}
+		return file, nil
+	}
+	path, found := env["PATH"]
+	if !found {
+		path = defaultPath
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "." // empty entries are the current directory
+		}
+		p := filepath.Join(dir, file)
+		if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0 {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("could not find launcher %q in PATH", file)
+}
+
+// readShebang returns the interpreter and optional argument from the script's `#!` line,
+// or nil if the file has no shebang.  Like the kernel, everything following the interpreter
+// is treated as a single argument.
+func readShebang(p string) ([]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("could not open launcher %q: %w", p, err)
+	}
+	defer f.Close()
+
+	header := make([]byte, 1024)
+	n, err := f.Read(header)
+	if err == io.EOF || n < 2 {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading file header from %q: %w", p, err)
+	} else if string(header[0:2]) != "#!" {
+		return nil, nil
+	}
+	line := strings.SplitN(string(header[2:n]), "\n", 2)[0]
+	line = strings.Trim(line, " \t\r")
+	if line == "" {
+		return nil, fmt.Errorf("%q has an empty shebang", p)
+	}
+	if i := strings.IndexAny(line, " \t"); i > 0 {
+		if arg := strings.Trim(line[i:], " \t"); arg != "" {
+			return []string{line[:i], arg}, nil
+		}
+		return []string{line[:i]}, nil
+	}
+	return []string{line}, nil
+}
+
+// envCommand is a parsed `env` command-line.
+type envCommand struct {
+	// ignoreEnvironment is true if the command should start with an empty environment (`-i`)
+	ignoreEnvironment bool
+	// unset are the variables to be removed (`-u NAME`)
+	unset []string
+	// assignments are the NAME=VALUE pairs to be set
+	assignments []string
+	// command is the resulting command-line
+	command []string
+}
+
+// parseEnvCommand parses an `env` command-line, where args[0] is env.  The `-S` option
+// (as used in `#!/usr/bin/env -S python -u`) is split using shell quoting rules.
+func parseEnvCommand(args []string) (envCommand, error) {
+	var ec envCommand
+	i := 1
+	for i < len(args) {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			i++
+			return ec.withCommand(args[i:])
+		case arg == "-" || arg == "-i" || arg == "--ignore-environment":
+			ec.ignoreEnvironment = true
+		case arg == "-u" || arg == "--unset":
+			if i+1 >= len(args) {
+				return envCommand{}, fmt.Errorf("env option %q requires an argument", arg)
+			}
+			i++
+			ec.unset = append(ec.unset, args[i])
+		case strings.HasPrefix(arg, "--unset="):
+			ec.unset = append(ec.unset, strings.TrimPrefix(arg, "--unset="))
+		case strings.HasPrefix(arg, "-u"):
+			ec.unset = append(ec.unset, arg[2:])
+		case arg == "-S" || arg == "--split-string" || strings.HasPrefix(arg, "--split-string=") || strings.HasPrefix(arg, "-S"):
+			var s string
+			switch {
+			case strings.HasPrefix(arg, "--split-string="):
+				s = strings.TrimPrefix(arg, "--split-string=")
+			case arg == "-S" || arg == "--split-string":
+				if i+1 >= len(args) {
+					return envCommand{}, fmt.Errorf("env option %q requires an argument", arg)
+				}
+				i++
+				s = args[i]
+			default:
+				s = arg[2:]
+			}
+			split, err := shell.Split(s)
+			if err != nil {
+				return envCommand{}, fmt.Errorf("unable to split env string %q: %w", s, err)
+			}
+			// the split string replaces the option and is then processed as if given directly
+			args = append(append(append([]string{}, args[:i+1]...), split...), args[i+1:]...)
+		case arg == "-v" || arg == "--debug":
+			// ignore
+		case strings.HasPrefix(arg, "-"):
+			return envCommand{}, fmt.Errorf("unsupported env option %q", arg)
+		case strings.Contains(arg, "="):
+			ec.assignments = append(ec.assignments, arg)
+		default:
+			return ec.withCommand(args[i:])
+		}
+		i++
+	}
+	return envCommand{}, fmt.Errorf("env has no command: %q", args)
+}
+
+func (ec envCommand) withCommand(command []string) (envCommand, error) {
+	if len(command) == 0 {
+		return envCommand{}, fmt.Errorf("env has no command")
+	}
+	ec.command = command
+	return ec, nil
+}
+
+// apply returns the environment that results from applying the env options to the
+// given environment.
+func (ec envCommand) apply(e env) env {
+	result := env{}
+	if !ec.ignoreEnvironment {
+		for k, v := range e {
+			result[k] = v
+		}
+	}
+	for _, k := range ec.unset {
+		delete(result, k)
+	}
+	for _, a := range ec.assignments {
+		kv := strings.SplitN(a, "=", 2)
+		result[kv[0]] = kv[1]
+	}
+	return result
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestReadShebang(t *testing.T) {
+	tests := []struct {
+		description string
+		contents    string
+		shouldErr   bool
+		expected    []string
+	}{
+		{"empty file", "", false, nil},
+		{"no shebang", "print('hi')\n", false, nil},
+		{"interpreter", "#!/usr/bin/python3\nprint('hi')", false, []string{"/usr/bin/python3"}},
+		{"interpreter with spaces", "#! /usr/bin/python3  \n", false, []string{"/usr/bin/python3"}},
+		{"interpreter with CRLF", "#!/usr/bin/python3\r\n", false, []string{"/usr/bin/python3"}},
+		{"interpreter with arg", "#!/bin/sh -x\n", false, []string{"/bin/sh", "-x"}},
+		{"args are a single argument", "#!/usr/bin/env -S python3 -u\n", false, []string{"/usr/bin/env", "-S python3 -u"}},
+		{"no newline", "#!/usr/bin/python3", false, []string{"/usr/bin/python3"}},
+		{"empty shebang", "#!\n", true, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "script")
+			if err := ioutil.WriteFile(p, []byte(test.contents), 0755); err != nil {
+				t.Fatal(err)
+			}
+			result, err := readShebang(p)
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %q", result)
+				}
+			} else if err != nil {
+				t.Error("unexpected error:", err)
+			} else if diff := cmp.Diff(test.expected, result); diff != "" {
+				t.Errorf("shebang differs (-got, +want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestParseEnvCommand(t *testing.T) {
+	tests := []struct {
+		description string
+		args        []string
+		shouldErr   bool
+		expected    envCommand
+	}{
+		{"command", []string{"env", "python", "app.py"}, false, envCommand{command: []string{"python", "app.py"}}},
+		{"assignments", []string{"env", "A=B", "C=", "python"}, false, envCommand{assignments: []string{"A=B", "C="}, command: []string{"python"}}},
+		{"ignore environment", []string{"env", "-i", "A=B", "python"}, false, envCommand{ignoreEnvironment: true, assignments: []string{"A=B"}, command: []string{"python"}}},
+		{"ignore environment (-)", []string{"env", "-", "python"}, false, envCommand{ignoreEnvironment: true, command: []string{"python"}}},
+		{"unset", []string{"env", "-u", "A", "-uB", "--unset=C", "python"}, false, envCommand{unset: []string{"A", "B", "C"}, command: []string{"python"}}},
+		{"split string", []string{"env", "-S", "A=B python -u", "app.py"}, false, envCommand{assignments: []string{"A=B"}, command: []string{"python", "-u", "app.py"}}},
+		{"split string as single shebang arg", []string{"/usr/bin/env", "-S python3 -u", "app.py"}, false, envCommand{command: []string{"python3", "-u", "app.py"}}},
+		{"split string with quotes", []string{"env", "--split-string=python -c 'import app'"}, false, envCommand{command: []string{"python", "-c", "import app"}}},
+		{"end of options", []string{"env", "--", "-odd"}, false, envCommand{command: []string{"-odd"}}},
+		{"no command", []string{"env", "A=B"}, true, envCommand{}},
+		{"unsupported option", []string{"env", "-C", "/tmp", "python"}, true, envCommand{}},
+		{"missing unset argument", []string{"env", "-u"}, true, envCommand{}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			result, err := parseEnvCommand(test.args)
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %v", result)
+				}
+			} else if err != nil {
+				t.Error("unexpected error:", err)
+			} else if diff := cmp.Diff(test.expected, result, cmp.AllowUnexported(test.expected), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("%T differ (-got, +want): %s", result, diff)
+			}
+		})
+	}
+}
+
+func TestEnvCommandApply(t *testing.T) {
+	initial := env{"A": "1", "B": "2"}
+	tests := []struct {
+		description string
+		ec          envCommand
+		expected    env
+	}{
+		{"no changes", envCommand{}, env{"A": "1", "B": "2"}},
+		{"assignment", envCommand{assignments: []string{"A=x=y", "C=3"}}, env{"A": "x=y", "B": "2", "C": "3"}},
+		{"unset", envCommand{unset: []string{"A"}}, env{"B": "2"}},
+		{"ignore environment", envCommand{ignoreEnvironment: true, assignments: []string{"C=3"}}, env{"C": "3"}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			result := test.ec.apply(initial)
+			if diff := cmp.Diff(test.expected, result); diff != "" {
+				t.Errorf("env differs (-got, +want): %s", diff)
+			}
+		})
+	}
+	if diff := cmp.Diff(env{"A": "1", "B": "2"}, initial); diff != "" {
+		t.Errorf("original env should be unchanged (-got, +want): %s", diff)
+	}
+}
+
+func TestLookPath(t *testing.T) {
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "exe")
+	if err := ioutil.WriteFile(exe, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "noexec"), []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := t.TempDir() + string(filepath.ListSeparator) + dir
+
+	tests := []struct {
+		description string
+		file        string
+		env         env
+		shouldErr   bool
+		expected    string
+	}{
+		{"absolute path", exe, nil, false, exe},
+		{"absolute path not found", filepath.Join(dir, "missing"), nil, true, ""},
+		{"found in PATH", "exe", env{"PATH": path}, false, exe},
+		{"not executable", "noexec", env{"PATH": path}, true, ""},
+		{"directory", "subdir", env{"PATH": path}, true, ""},
+		{"not in PATH", "exe", env{"PATH": t.TempDir()}, true, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			result, err := lookPath(test.file, test.env)
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %q", result)
+				}
+			} else if err != nil {
+				t.Error("unexpected error:", err)
+			} else if result != test.expected {
+				t.Errorf("expected %q but got %q", test.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,530 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	shell "github.com/kballard/go-shellquote"
+	"github.com/sirupsen/logrus"
+)
+
+// for testing
+var executable = os.Executable
+
+// maxShellScriptSize is the largest entrypoint script that will be examined.  The script
+// is passed as a `-c` argument and so must fit within the kernel's MAX_ARG_STRLEN.
+// Larger scripts are run as-is, without debugging.
+const maxShellScriptSize = 64 * 1024
+
+// shells are the POSIX-like shells whose command strings and scripts are examined.
+var shells = map[string]bool{"sh": true, "ash": true, "dash": true, "bash": true, "ksh": true, "mksh": true, "zsh": true}
+
+// shellReservedWords are words that may prefix a command in compound commands.
+var shellReservedWords = map[string]bool{
+	"!": true, "{": true, "}": true, "if": true, "then": true, "else": true, "elif": true, "fi": true,
+	"do": true, "done": true, "while": true, "until": true, "time": true, "esac": true,
+}
+
+// isShell returns true if the given executable appears to be a shell.
+func isShell(p string) bool {
+	return shells[filepath.Base(p)]
+}
+
+// shellCommandLine is a parsed shell invocation of the form:
+//
+//	sh [option ...] -c command_string [command_name [argument ...]]
+//	sh [option ...] script [argument ...]
+type shellCommandLine struct {
+	// shell is the shell executable
+	shell string
+	// options are the shell options other than `-c`
+	options []string
+	// command is the command string, or the contents of the script
+	command string
+	// script is the script file, if provided
+	script string
+	// args are the values of $0 and the positional parameters
+	args []string
+}
+
+// parseShellCommandLine parses a shell command-line, where args[0] is the shell.  Scripts
+// are read so that they can be treated as command strings.
+func parseShellCommandLine(args []string) (shellCommandLine, error) {
+	sh := shellCommandLine{shell: args[0]}
+	hasCommand := false
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || arg == "-" {
+			i++
+			break
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			break
+		}
+		if strings.HasPrefix(arg, "--") {
+			// bash long options: only --rcfile and --init-file take an argument
+			sh.options = append(sh.options, arg)
+			if (arg == "--rcfile" || arg == "--init-file") && i+1 < len(args) {
+				i++
+				sh.options = append(sh.options, args[i])
+			}
+			continue
+		}
+		if strings.ContainsRune(arg, 's') && arg[0] == '-' {
+			return shellCommandLine{}, fmt.Errorf("shell reads commands from stdin: %q", args)
+		}
+		if strings.ContainsRune(arg, 'c') && arg[0] == '-' {
+			hasCommand = true
+			if arg = strings.Replace(arg, "c", "", 1); arg == "-" {
+				continue
+			}
+		}
+		sh.options = append(sh.options, arg)
+		if strings.ContainsRune(arg[1:], 'o') && i+1 < len(args) {
+			// -o option-name
+			i++
+			sh.options = append(sh.options, args[i])
+		}
+	}
+	if i >= len(args) {
+		return shellCommandLine{}, fmt.Errorf("no shell command or script: %q", args)
+	}
+
+	if hasCommand {
+		sh.command = args[i]
+		sh.args = args[i+1:]
+		return sh, nil
+	}
+
+	sh.script = args[i]
+	contents, err := ioutil.ReadFile(sh.script)
+	if err != nil {
+		return shellCommandLine{}, fmt.Errorf("unable to read shell script: %w", err)
+	}
+	if len(contents) > maxShellScriptSize {
+		return shellCommandLine{}, fmt.Errorf("shell script %q is too large to rewrite (%d bytes, at most %d): running it without debugging", sh.script, len(contents), maxShellScriptSize)
+	}
+	if strings.ContainsRune(string(contents), 0) {
+		return shellCommandLine{}, fmt.Errorf("%q does not appear to be a shell script", sh.script)
+	}
+	sh.command = string(contents)
+	sh.args = args[i:] // script becomes $0
+	return sh, nil
+}
+
+// commandLine returns a command-line that executes the given command string with the
+// same options and parameters.  Scripts are run as command strings with `$0` set to
+// the original script location, so that `dirname "$0"` still finds the script's directory.
+// Shells do not set BASH_SOURCE, and may not set LINENO, for a command string.
+func (sh shellCommandLine) commandLine(command string) []string {
+	cmdline := append([]string{sh.shell}, sh.options...)
+	cmdline = append(cmdline, "-c", command)
+	return append(cmdline, sh.args...)
+}
+
+// shellWord is a word in a shell command, as found in the original text.
+type shellWord struct {
+	text       string
+	start, end int
+	// redirect is true for redirection operators and their targets
+	redirect bool
+}
+
+// literal returns true if the word contains no quoting, expansions, or substitutions.
+func (w shellWord) literal() bool {
+	return !strings.ContainsAny(w.text, "'\"\\$`*?[~")
+}
+
+// shellCommand is a simple command found in a shell command string.
+type shellCommand struct {
+	words []shellWord
+}
+
+// commandWords returns the words of the command, excluding redirections, leading
+// reserved words, and leading variable assignments.
+func (c shellCommand) commandWords() []shellWord {
+	var words []shellWord
+	for _, w := range c.words {
+		if !w.redirect {
+			words = append(words, w)
+		}
+	}
+	for len(words) > 0 && (shellReservedWords[words[0].text] || isAssignment(words[0].text)) {
+		words = words[1:]
+	}
+	return words
+}
+
+// execWords returns the command executed by an `exec`, or nil if this command is not an exec.
+func (c shellCommand) execWords() []shellWord {
+	words := c.commandWords()
+	if len(words) == 0 || words[0].text != "exec" {
+		return nil
+	}
+	words = words[1:]
+	for len(words) > 0 && strings.HasPrefix(words[0].text, "-") {
+		if words[0].text == "--" {
+			return words[1:]
+		}
+		if strings.Contains(words[0].text, "a") && len(words) > 1 {
+			words = words[1:] // -a name
+		}
+		words = words[1:]
+	}
+	return words
+}
+
+func isAssignment(word string) bool {
+	eq := strings.IndexByte(word, '=')
+	if eq <= 0 {
+		return false
+	}
+	for i, c := range word[:eq] {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseShellCommands splits a shell command string into its simple commands.  This is not a
+// full shell parser: it understands quoting, substitutions, comments, redirections, here-documents,
+// `case` patterns, and command separators, which suffices to find the commands in typical
+// entrypoint scripts.
+func parseShellCommands(s string) ([]shellCommand, error) {
+	var commands []shellCommand
+	var current shellCommand
+	var heredocs []string // pending here-document delimiters
+	wordStart := -1
+	redirectNext := false
+	// casePattern is true where a `case` pattern, terminated by `)`, is expected
+	casePattern := false
+
+	endWord := func(end int) {
+		if wordStart >= 0 {
+			current.words = append(current.words, shellWord{text: s[wordStart:end], start: wordStart, end: end, redirect: redirectNext})
+			if redirectNext && len(heredocs) > 0 && heredocs[len(heredocs)-1] == "" {
+				heredocs[len(heredocs)-1] = strings.Trim(s[wordStart:end], `'"\`)
+			}
+			redirectNext = false
+			wordStart = -1
+		}
+	}
+	endCommand := func(terminator byte) {
+		switch {
+		case len(current.words) == 0:
+		case current.words[0].text == "case":
+			// `case word in`, possibly followed by the first pattern
+			casePattern = terminator != ')'
+		case casePattern && terminator == ')':
+			casePattern = false
+		default:
+			if current.words[0].text == "esac" {
+				casePattern = false
+			}
+			commands = append(commands, current)
+		}
+		current = shellCommand{}
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			endWord(i)
+
+		case c == '\n':
+			endWord(i)
+			endCommand(c)
+			// skip any here-document bodies
+			for _, delim := range heredocs {
+				for i+1 < len(s) {
+					eol := strings.IndexByte(s[i+1:], '\n')
+					line := s[i+1:]
+					if eol >= 0 {
+						line = s[i+1 : i+1+eol]
+					} else {
+						eol = len(line)
+					}
+					i += eol + 1
+					if strings.TrimLeft(line, "\t") == delim {
+						break
+					}
+				}
+			}
+			heredocs = nil
+
+		case c == ';' || c == '&' || c == '|' || c == '(' || c == ')':
+			endWord(i)
+			endCommand(c)
+			if c == ';' && i+1 < len(s) && s[i+1] == ';' {
+				// `;;` ends a `case` item and is followed by the next pattern
+				casePattern = true
+				i++
+			}
+
+		case c == '#' && wordStart < 0:
+			// comment to end of line
+			if eol := strings.IndexByte(s[i:], '\n'); eol >= 0 {
+				i += eol - 1
+			} else {
+				i = len(s)
+			}
+
+		case c == '<' || c == '>':
+			// a numeric word like `2` in `2>&1` is a file descriptor and part of the operator
+			if wordStart >= 0 && strings.Trim(s[wordStart:i], "0123456789") != "" {
+				endWord(i)
+			}
+			if wordStart < 0 {
+				wordStart = i
+			}
+			j := i + 1
+			for j < len(s) && strings.IndexByte("<>&|-", s[j]) >= 0 {
+				j++
+			}
+			op := strings.TrimLeft(s[wordStart:j], "0123456789")
+			redirectNext = true
+			endWord(j)
+			i = j - 1
+			if strings.HasPrefix(op, "<<") && !strings.HasPrefix(op, "<<<") {
+				heredocs = append(heredocs, "")
+			}
+			// the following word is the redirection target, except for `>&-`
+			redirectNext = !strings.HasSuffix(op, "&-")
+
+		default:
+			if wordStart < 0 {
+				wordStart = i
+			}
+			end, err := skipShellToken(s, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end - 1
+		}
+	}
+	if len(heredocs) > 0 && heredocs[len(heredocs)-1] == "" {
+		return nil, fmt.Errorf("missing here-document delimiter")
+	}
+	endWord(len(s))
+	endCommand(0)
+	return commands, nil
+}
+
+// skipShellToken returns the index following the quoted string, substitution,
+// escape, or single character found at s[i].
+func skipShellToken(s string, i int) (int, error) {
+	switch s[i] {
+	case '\\':
+		return min(i+2, len(s)), nil
+	case '\'':
+		if end := strings.IndexByte(s[i+1:], '\''); end >= 0 {
+			return i + 1 + end + 1, nil
+		}
+		return 0, fmt.Errorf("unterminated single quote")
+	case '"':
+		for j := i + 1; j < len(s); j++ {
+			switch s[j] {
+			case '\\':
+				j++
+			case '"':
+				return j + 1, nil
+			case '$', '`':
+				end, err := skipShellToken(s, j)
+				if err != nil {
+					return 0, err
+				}
+				j = end - 1
+			}
+		}
+		return 0, fmt.Errorf("unterminated double quote")
+	case '`':
+		for j := i + 1; j < len(s); j++ {
+			switch s[j] {
+			case '\\':
+				j++
+			case '`':
+				return j + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("unterminated backquote")
+	case '$':
+		if i+1 < len(s) && (s[i+1] == '(' || s[i+1] == '{') {
+			open, close := s[i+1], byte(')')
+			if open == '{' {
+				close = '}'
+			}
+			depth := 0
+			for j := i + 1; j < len(s); j++ {
+				switch s[j] {
+				case open:
+					depth++
+				case close:
+					if depth--; depth == 0 {
+						return j + 1, nil
+					}
+				case '\'', '"', '`', '\\':
+					end, err := skipShellToken(s, j)
+					if err != nil {
+						return 0, err
+					}
+					j = end - 1
+				}
+			}
+			return 0, fmt.Errorf("unterminated substitution")
+		}
+	}
+	return i + 1, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// findLaunchCommand returns the words of the command that launches the app: the
+// last `exec`d command, or otherwise the final command.
+func findLaunchCommand(commands []shellCommand) []shellWord {
+	for i := len(commands) - 1; i >= 0; i-- {
+		if words := commands[i].execWords(); len(words) > 0 {
+			return words
+		}
+	}
+	for i := len(commands) - 1; i >= 0; i-- {
+		if words := commands[i].commandWords(); len(words) > 0 {
+			return words
+		}
+	}
+	return nil
+}
+
+// updateShellCommandLine rewrites a shell invocation so that the command that launches the
+// app is instead run through this launcher.  The shell expands any variables and parameters
+// (e.g., `exec "$@"`) and the launcher then configures the resulting python command-line
+// as usual.  The remainder of the shell command or script is left untouched.
+func (pc *pythonContext) updateShellCommandLine(ctx context.Context) error {
+	sh, err := parseShellCommandLine(pc.args)
+	if err != nil {
+		return err
+	}
+	commands, err := parseShellCommands(sh.command)
+	if err != nil {
+		return fmt.Errorf("unable to parse shell command: %w", err)
+	}
+	words := findLaunchCommand(commands)
+	if len(words) == 0 {
+		return fmt.Errorf("no command found in shell command %q", sh.command)
+	}
+	// commands with expansions (e.g., `"$@"`) are left for the launcher to examine
+	if words[0].literal() && !pc.isPythonCommand(ctx, words[0].text) {
+		return fmt.Errorf("shell command %q does not launch python", sh.command[words[0].start:words[len(words)-1].end])
+	}
+
+	launcher, err := pc.launcherCommandLine()
+	if err != nil {
+		return err
+	}
+	at := words[0].start
+	command := sh.command[:at] + shell.Join(launcher...) + " -- " + sh.command[at:]
+	logrus.Debugf("rewrote shell command %q -> %q", sh.command, command)
+	pc.args = sh.commandLine(command)
+	return nil
+}
+
+// isPythonCommand returns true if the command resolves to python or to a python script.
+func (pc *pythonContext) isPythonCommand(ctx context.Context, command string) bool {
+	probe := pythonContext{args: []string{command}, env: pc.env}
+	if err := probe.unwrapLauncher(ctx); err != nil {
+		logrus.Debugf("unable to resolve %q: %v", command, err)
+		return false
+	}
+	return isPythonInterpreter(probe.args[0])
+}
+
+// launcherCommandLine returns the command-line to re-invoke this launcher with the
+// current configuration.
+func (pc *pythonContext) launcherCommandLine() ([]string, error) {
+	exe, err := executable()
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine launcher location: %w", err)
+	}
+	cmdline := []string{exe, "--helpers", dbgRoot}
+	// pass on the explicitly-set flags
+	pass := func(name string) bool { return pc.configFile == "" || pc.flags[name] }
+	if pc.configFile != "" {
+		// the nested launcher also reads the configuration file so as to apply any
+		// overrides for the command that it launches
+		cmdline = append(cmdline, "--config", pc.configFile)
+		if pc.statusAddress != "" {
+			// this launcher serves the status
+			cmdline = append(cmdline, "--status-address=")
+		}
+	}
+	if pass("mode") {
+		cmdline = append(cmdline, "--mode", pc.debugMode)
+	}
+	if pc.connect != "" {
+		if pass("connect") {
+			cmdline = append(cmdline, "--connect", pc.connect)
+		}
+	} else if pc.configFile != "" && pc.flags["connect"] {
+		cmdline = append(cmdline, "--connect=")
+	}
+	if !pc.portRange.isEmpty() {
+		if pass("port-range") {
+			cmdline = append(cmdline, "--port-range", pc.portRange.String())
+		}
+	} else if pass("port") && pc.connect == "" {
+		cmdline = append(cmdline, "--port", strconv.Itoa(int(pc.port)))
+	}
+	if pc.wait && pass("wait") {
+		cmdline = append(cmdline, "--wait")
+	} else if !pc.wait && pc.configFile != "" && pc.flags["wait"] {
+		cmdline = append(cmdline, "--wait=false")
+	}
+	if pc.waitTimeout != 0 && pass("wait-timeout") {
+		cmdline = append(cmdline, "--wait-timeout", strconv.Itoa(int(pc.waitTimeout)))
+	} else if pc.waitTimeout == 0 && pc.configFile != "" && pc.flags["wait-timeout"] {
+		cmdline = append(cmdline, "--wait-timeout=0")
+	}
+	if pc.waitTimeoutAction == WaitTimeoutExit && pass("wait-timeout-action") {
+		cmdline = append(cmdline, "--wait-timeout-action", WaitTimeoutExit)
+	} else if pc.waitTimeoutAction != WaitTimeoutExit && pc.configFile != "" && pc.flags["wait-timeout-action"] {
+		cmdline = append(cmdline, "--wait-timeout-action", WaitTimeoutContinue)
+	}
+	if pc.subprocess && pass("subprocess") {
+		cmdline = append(cmdline, "--subprocess")
+	} else if !pc.subprocess && pc.configFile != "" && pc.flags["subprocess"] {
+		cmdline = append(cmdline, "--subprocess=false")
+	}
+	if !pc.inject && pc.configFile != "" && pc.flags["inject"] {
+		// an injecting launcher leaves shell commands untouched
+		cmdline = append(cmdline, "--inject=false")
+	}
+	return cmdline, nil
+}
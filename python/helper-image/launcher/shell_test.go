@@ -0,0 +1,312 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestParseShellCommandLine(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "entrypoint.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nexec python app.py\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	large := filepath.Join(t.TempDir(), "large.sh")
+	if err := ioutil.WriteFile(large, []byte("#!/bin/sh\n"+strings.Repeat("# padding\n", maxShellScriptSize/10)+"exec python app.py\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		args        []string
+		shouldErr   bool
+		expected    shellCommandLine
+	}{
+		{"command string", []string{"sh", "-c", "exec python app.py"}, false, shellCommandLine{shell: "sh", command: "exec python app.py"}},
+		{"command string with args", []string{"sh", "-c", "exec \"$@\"", "name", "python"}, false, shellCommandLine{shell: "sh", command: "exec \"$@\"", args: []string{"name", "python"}}},
+		{"combined options", []string{"bash", "-xec", "python app.py"}, false, shellCommandLine{shell: "bash", options: []string{"-xe"}, command: "python app.py"}},
+		{"option with argument", []string{"bash", "-o", "pipefail", "-c", "python app.py"}, false, shellCommandLine{shell: "bash", options: []string{"-o", "pipefail"}, command: "python app.py"}},
+		{"long options", []string{"bash", "--noprofile", "--rcfile", "x", "-c", "python app.py"}, false, shellCommandLine{shell: "bash", options: []string{"--noprofile", "--rcfile", "x"}, command: "python app.py"}},
+		{"script", []string{"/bin/sh", "-e", script, "arg"}, false, shellCommandLine{shell: "/bin/sh", options: []string{"-e"}, command: "#!/bin/sh\nexec python app.py\n", script: script, args: []string{script, "arg"}}},
+		{"script too large", []string{"/bin/sh", large}, true, shellCommandLine{}},
+		{"missing script", []string{"/bin/sh", filepath.Join(t.TempDir(), "missing")}, true, shellCommandLine{}},
+		{"missing command", []string{"sh", "-c"}, true, shellCommandLine{}},
+		{"stdin", []string{"sh", "-s"}, true, shellCommandLine{}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			result, err := parseShellCommandLine(test.args)
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %v", result)
+				}
+			} else if err != nil {
+				t.Error("unexpected error:", err)
+			} else if diff := cmp.Diff(test.expected, result, cmp.AllowUnexported(test.expected), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("%T differ (-got, +want): %s", result, diff)
+			}
+		})
+	}
+}
+
+func TestShellScriptCommandLine(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell:", err)
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "entrypoint.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$(dirname \"$0\") $1\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	sh, err := parseShellCommandLine([]string{"sh", script, "arg"})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	cmdline := sh.commandLine(sh.command)
+	out, err := exec.Command(cmdline[0], cmdline[1:]...).Output()
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if expected := dir + " arg\n"; string(out) != expected {
+		t.Errorf("expected %q but got %q", expected, out)
+	}
+}
+
+func TestFindLaunchCommand(t *testing.T) {
+	tests := []struct {
+		description string
+		command     string
+		shouldErr   bool
+		expected    []string
+	}{
+		{"simple", "python app.py", false, []string{"python", "app.py"}},
+		{"final command", "python manage.py migrate && python manage.py runserver", false, []string{"python", "manage.py", "runserver"}},
+		{"exec", "python manage.py migrate && exec gunicorn app:app; echo done", false, []string{"gunicorn", "app:app"}},
+		{"exec with options", "exec -a myapp python app.py", false, []string{"python", "app.py"}},
+		{"exec parameters", `set -e; exec "$@"`, false, []string{`"$@"`}},
+		{"assignments", "PORT=8080 DEBUG=1 python app.py", false, []string{"python", "app.py"}},
+		{"redirections", "python app.py >/tmp/log 2>&1 </dev/null", false, []string{"python", "app.py"}},
+		{"redirections with spaces", "exec python app.py > /tmp/log 2> /tmp/err", false, []string{"python", "app.py"}},
+		{"quoting", `exec python -c 'import app; app.main()' "a b" c\ d`, false, []string{"python", "-c", `'import app; app.main()'`, `"a b"`, `c\ d`}},
+		{"substitutions", `exec python "$(dirname "$0")/app.py" ${PORT:-8080} ` + "`date`", false, []string{"python", `"$(dirname "$0")/app.py"`, "${PORT:-8080}", "`date`"}},
+		{"comments", "exec python app.py # exec other\n# exec other", false, []string{"python", "app.py"}},
+		{"compound commands", "if [ -n \"$X\" ]; then\n  exec python a.py\nelse\n  exec python b.py\nfi\n", false, []string{"python", "b.py"}},
+		{"here-document", "cat <<EOF >config\nexec other\nEOF\nexec python app.py\n", false, []string{"python", "app.py"}},
+		{"case", "case \"$1\" in\n  serve|web) python app.py ;;\n  *) exec \"$@\" ;;\nesac\n", false, []string{`"$@"`}},
+		{"case without exec", "case \"$1\" in\n  (serve) python app.py ;;\nesac\n", false, []string{"python", "app.py"}},
+		{"case on one line", "case $MODE in serve) python app.py;; esac", false, []string{"python", "app.py"}},
+		{"subshell", "(cd /app && python app.py)", false, []string{"python", "app.py"}},
+		{"here-document is skipped", "exec python app.py\ncat <<-'EOF'\n\texec other\n\tEOF\n", false, []string{"python", "app.py"}},
+		{"empty", "# nothing here\n", false, nil},
+		{"unterminated quote", "exec python 'app.py", true, nil},
+		{"unterminated substitution", "exec python $(dirname", true, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			commands, err := parseShellCommands(test.command)
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %v", commands)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			var result []string
+			for _, w := range findLaunchCommand(commands) {
+				if w.text != test.command[w.start:w.end] {
+					t.Errorf("word %q does not match original text %q", w.text, test.command[w.start:w.end])
+				}
+				result = append(result, w.text)
+			}
+			if diff := cmp.Diff(test.expected, result); diff != "" {
+				t.Errorf("launch command differs (-got, +want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestUpdateShellCommandLine(t *testing.T) {
+	oldExecutable := executable
+	executable = func() (string, error) { return "/dbg/python/launcher", nil }
+	t.Cleanup(func() { executable = oldExecutable })
+	oldDbgRoot := dbgRoot
+	dbgRoot = "/dbg"
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+
+	bin := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(bin, "gunicorn"), []byte("#!/usr/local/bin/python3.9\nimport gunicorn\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "nginx"), []byte{0x7f, 'E', 'L', 'F'}, 0755); err != nil {
+		t.Fatal(err)
+	}
+	entrypoint := filepath.Join(t.TempDir(), "docker-entrypoint.sh")
+	if err := ioutil.WriteFile(entrypoint, []byte("#!/bin/sh\nset -e\necho starting\nexec python3 -u app.py \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	launcher := "/dbg/python/launcher --helpers /dbg --mode debugpy --port 5678 -- "
+
+	tests := []struct {
+		description string
+		args        []string
+		wait        bool
+		waitTimeout uint
+		waitAction  string
+		subprocess  bool
+		portRange   portRange
+		connect     string
+		configFile  string
+		flags       map[string]bool
+		status      string
+		shouldErr   bool
+		expected    []string
+	}{
+		{
+			description: "sh -c",
+			args:        []string{"sh", "-c", "python manage.py migrate && exec gunicorn app:app"},
+			expected:    []string{"sh", "-c", "python manage.py migrate && exec " + launcher + "gunicorn app:app"},
+		},
+		{
+			description: "sh -c with wait",
+			args:        []string{"sh", "-c", "python app.py"},
+			wait:        true,
+			expected:    []string{"sh", "-c", "/dbg/python/launcher --helpers /dbg --mode debugpy --port 5678 --wait -- python app.py"},
+		},
+		{
+			description: "sh -c with subprocesses",
+			args:        []string{"sh", "-c", "exec python -m celery worker"},
+			subprocess:  true,
+			expected:    []string{"sh", "-c", "exec /dbg/python/launcher --helpers /dbg --mode debugpy --port 5678 --subprocess -- python -m celery worker"},
+		},
+		{
+			description: "sh -c with parameters",
+			args:        []string{"bash", "-ec", `exec "$@"`, "name", "python", "app.py"},
+			expected:    []string{"bash", "-e", "-c", `exec ` + launcher + `"$@"`, "name", "python", "app.py"},
+		},
+		{
+			description: "assignments are preserved",
+			args:        []string{"sh", "-c", "PORT=80 python app.py"},
+			expected:    []string{"sh", "-c", "PORT=80 " + launcher + "python app.py"},
+		},
+		{
+			description: "entrypoint script",
+			args:        []string{"/bin/sh", entrypoint, "--port", "80"},
+			expected:    []string{"/bin/sh", "-c", "#!/bin/sh\nset -e\necho starting\nexec " + launcher + "python3 -u app.py \"$@\"\n", entrypoint, "--port", "80"},
+		},
+		{
+			description: "sh -c with port range",
+			args:        []string{"sh", "-c", "exec gunicorn app:app"},
+			portRange:   portRange{first: 5678, last: 5687},
+			expected:    []string{"sh", "-c", "exec /dbg/python/launcher --helpers /dbg --mode debugpy --port-range 5678-5687 -- gunicorn app:app"},
+		},
+		{
+			description: "sh -c with connect",
+			args:        []string{"sh", "-c", "exec python app.py"},
+			connect:     "ide.example.com:5678",
+			expected:    []string{"sh", "-c", "exec /dbg/python/launcher --helpers /dbg --mode debugpy --connect ide.example.com:5678 -- python app.py"},
+		},
+		{
+			description: "sh -c with configuration file and no connect",
+			args:        []string{"sh", "-c", "exec python app.py"},
+			configFile:  "/dbg/python/launcher.json",
+			flags:       map[string]bool{"connect": true},
+			expected:    []string{"sh", "-c", "exec /dbg/python/launcher --helpers /dbg --config /dbg/python/launcher.json --connect= -- python app.py"},
+		},
+		{
+			description: "sh -c with configuration file and no injection",
+			args:        []string{"sh", "-c", "exec python app.py"},
+			configFile:  "/dbg/python/launcher.json",
+			flags:       map[string]bool{"inject": true},
+			expected:    []string{"sh", "-c", "exec /dbg/python/launcher --helpers /dbg --config /dbg/python/launcher.json --inject=false -- python app.py"},
+		},
+		{
+			description: "sh -c with configuration file",
+			args:        []string{"sh", "-c", "exec gunicorn app:app"},
+			configFile:  "/dbg/python/launcher.json",
+			expected:    []string{"sh", "-c", "exec /dbg/python/launcher --helpers /dbg --config /dbg/python/launcher.json -- gunicorn app:app"},
+		},
+		{
+			description: "sh -c with configuration file and flags",
+			args:        []string{"sh", "-c", "exec gunicorn app:app"},
+			wait:        true,
+			configFile:  "/dbg/python/launcher.json",
+			flags:       map[string]bool{"mode": true, "wait": true, "status-address": true},
+			status:      ":5680",
+			expected:    []string{"sh", "-c", "exec /dbg/python/launcher --helpers /dbg --config /dbg/python/launcher.json --status-address= --mode debugpy --wait -- gunicorn app:app"},
+		},
+		{
+			description: "sh -c with wait timeout",
+			args:        []string{"sh", "-c", "exec python app.py"},
+			wait:        true,
+			waitTimeout: 30,
+			waitAction:  "exit",
+			expected:    []string{"sh", "-c", "exec /dbg/python/launcher --helpers /dbg --mode debugpy --port 5678 --wait --wait-timeout 30 --wait-timeout-action exit -- python app.py"},
+		},
+		{
+			description: "sh -c with configuration file and no wait",
+			args:        []string{"sh", "-c", "exec gunicorn app:app"},
+			configFile:  "/dbg/python/launcher.json",
+			flags:       map[string]bool{"port": true, "wait": true},
+			expected:    []string{"sh", "-c", "exec /dbg/python/launcher --helpers /dbg --config /dbg/python/launcher.json --port 5678 --wait=false -- gunicorn app:app"},
+		},
+		{
+			description: "sh -c with configuration file and no subprocesses",
+			args:        []string{"sh", "-c", "exec python -m celery worker"},
+			configFile:  "/dbg/python/launcher.json",
+			flags:       map[string]bool{"subprocess": true},
+			expected:    []string{"sh", "-c", "exec /dbg/python/launcher --helpers /dbg --config /dbg/python/launcher.json --subprocess=false -- python -m celery worker"},
+		},
+		{
+			description: "non-python command",
+			args:        []string{"sh", "-c", "python manage.py migrate && exec nginx"},
+			shouldErr:   true,
+		},
+		{
+			description: "unknown command",
+			args:        []string{"sh", "-c", "exec not-found"},
+			shouldErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			pc := pythonContext{debugMode: "debugpy", port: 5678, wait: test.wait, waitTimeout: test.waitTimeout, waitTimeoutAction: test.waitAction, subprocess: test.subprocess, portRange: test.portRange, connect: test.connect, configFile: test.configFile, flags: test.flags, statusAddress: test.status, args: test.args, env: env{"PATH": bin}}
+			err := pc.updateShellCommandLine(context.TODO())
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %q", pc.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if diff := cmp.Diff(test.expected, pc.args); diff != "" {
+				t.Errorf("args differ (-got, +want): %s", diff)
+			}
+		})
+	}
+}
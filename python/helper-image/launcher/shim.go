@@ -0,0 +1,191 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// versionManager describes a python version manager, like pyenv, that places shims for
+// the python interpreter and installed scripts in `<root>/shims`.  A shim runs the
+// program from the python installation selected for the current directory.
+type versionManager struct {
+	name string
+	// marker identifies the manager's shim scripts
+	marker string
+	// rootEnv is the environment variable that locates the manager's root
+	rootEnv string
+	// versionEnv is the environment variable that selects the python version
+	versionEnv string
+	// versionFiles select the python version, and are searched from the working
+	// directory upwards and then in the home directory
+	versionFiles []string
+	// globalVersionFile, if set, selects the python version under the root
+	globalVersionFile string
+	// installs is the location of the python installations under the root
+	installs string
+}
+
+// versionManagers are the version managers whose shims are resolved.
+var versionManagers = []versionManager{
+	{name: "pyenv", marker: `pyenv" exec`, rootEnv: "PYENV_ROOT", versionEnv: "PYENV_VERSION", versionFiles: []string{".python-version"}, globalVersionFile: "version", installs: "versions"},
+	{name: "asdf", marker: "asdf exec", rootEnv: "ASDF_DATA_DIR", versionEnv: "ASDF_PYTHON_VERSION", versionFiles: []string{".tool-versions"}, installs: "installs/python"},
+	{name: "mise", marker: "mise x", rootEnv: "MISE_DATA_DIR", versionEnv: "MISE_PYTHON_VERSION", versionFiles: []string{".tool-versions", ".python-version"}, installs: "installs/python"},
+}
+
+// shimManager returns the version manager of the shim at the given location, or nil
+// if it is not a shim.  Shims are scripts that run the manager, though mise's shims
+// are normally links to mise itself.
+func shimManager(p string) *versionManager {
+	if filepath.Base(filepath.Dir(p)) != "shims" {
+		return nil
+	}
+	real, _ := filepath.EvalSymlinks(p)
+	f, err := os.Open(p)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	// shims are short scripts
+	head := make([]byte, 1024)
+	n, _ := f.Read(head)
+	for i, m := range versionManagers {
+		if filepath.Base(real) == m.name || strings.Contains(string(head[:n]), m.marker) {
+			return &versionManagers[i]
+		}
+	}
+	return nil
+}
+
+// resolve returns the location of the program that the shim would run.  The version
+// manager is asked where the program is found, and otherwise the selected python version
+// is determined from the version manager's environment variable and version files.
+func (m versionManager) resolve(ctx context.Context, shim string, env env) (string, error) {
+	program := filepath.Base(shim)
+	root := filepath.Dir(filepath.Dir(shim))
+	if exe := m.executable(shim, root, env); exe != "" {
+		// the shim sets the root for the manager
+		whichEnv := map[string]string{m.rootEnv: root}
+		for k, v := range env {
+			whichEnv[k] = v
+		}
+		out, err := newCommand(ctx, []string{exe, "which", program}, whichEnv).Output()
+		if p := strings.TrimSpace(string(out)); err == nil && p != "" && pathExists(p) {
+			return p, nil
+		}
+		logrus.Debugf("`%s which %s` failed: %v", m.name, program, err)
+	}
+
+	version, source := m.selectedVersion(root, env)
+	if version == "" {
+		return "", fmt.Errorf("no python version selected")
+	}
+	if version == "system" {
+		return "", fmt.Errorf("system python selected by %s", source)
+	}
+	p := filepath.Join(root, m.installs, version, "bin", program)
+	if !pathExists(p) {
+		return "", fmt.Errorf("python %s selected by %s is not installed at %q", version, source, p)
+	}
+	logrus.Debugf("python %s selected by %s", version, source)
+	return p, nil
+}
+
+// executable returns the location of the version manager's executable, or "" if not found.
+func (m versionManager) executable(shim, root string, env env) string {
+	if real, err := filepath.EvalSymlinks(shim); err == nil && filepath.Base(real) == m.name {
+		return real
+	}
+	if p, err := lookPath(m.name, env); err == nil {
+		return p
+	}
+	for _, p := range []string{filepath.Join(root, "bin", m.name), filepath.Join(root, "libexec", m.name)} {
+		if pathExists(p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// selectedVersion returns the selected python version and what selected it.
+func (m versionManager) selectedVersion(root string, env env) (string, string) {
+	if v := firstField(env[m.versionEnv]); v != "" {
+		return v, m.versionEnv
+	}
+	var dirs []string
+	if wd, err := os.Getwd(); err == nil {
+		for dir := wd; ; dir = filepath.Dir(dir) {
+			dirs = append(dirs, dir)
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+	if home := env["HOME"]; home != "" {
+		dirs = append(dirs, home)
+	}
+	for _, dir := range dirs {
+		for _, name := range m.versionFiles {
+			f := filepath.Join(dir, name)
+			if v := readVersionFile(f); v != "" {
+				return v, f
+			}
+		}
+	}
+	if m.globalVersionFile != "" {
+		f := filepath.Join(root, m.globalVersionFile)
+		if v := readVersionFile(f); v != "" {
+			return v, f
+		}
+	}
+	return "", ""
+}
+
+// readVersionFile returns the first python version in a `.python-version` file, or the
+// first version of the `python` entry of a `.tool-versions` file.
+func readVersionFile(f string) string {
+	data, err := ioutil.ReadFile(f)
+	if err != nil {
+		return ""
+	}
+	if filepath.Base(f) != ".tool-versions" {
+		return firstField(string(data))
+	}
+	s := bufio.NewScanner(strings.NewReader(string(data)))
+	for s.Scan() {
+		if fields := strings.Fields(s.Text()); len(fields) > 1 && fields[0] == "python" {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// firstField returns the first whitespace-separated field, or "" if there is none.
+func firstField(s string) string {
+	if fields := strings.Fields(s); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
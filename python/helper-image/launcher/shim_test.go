@@ -0,0 +1,208 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const pyenvShim = `#!/usr/bin/env bash
+set -e
+[ -n "$PYENV_DEBUG" ] && set -x
+
+program="${0##*/}"
+
+export PYENV_ROOT="/root/.pyenv"
+exec "/root/.pyenv/libexec/pyenv" exec "$program" "$@"
+`
+
+const asdfShim = `#!/usr/bin/env bash
+# asdf-plugin: python 3.11.4
+exec /root/.asdf/bin/asdf exec "python" "$@" # asdf_allow: ' asdf '
+`
+
+func TestShimManager(t *testing.T) {
+	dir := t.TempDir()
+	mise := writeFile(t, dir, "mise/bin/mise", "\x7fELF")
+	if err := os.MkdirAll(filepath.Join(dir, "mise/shims"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(mise, filepath.Join(dir, "mise/shims/python")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		path        string
+		expected    string
+	}{
+		{"pyenv", writeFile(t, dir, ".pyenv/shims/python", pyenvShim), "pyenv"},
+		{"asdf", writeFile(t, dir, ".asdf/shims/gunicorn", asdfShim), "asdf"},
+		{"mise", filepath.Join(dir, "mise/shims/python"), "mise"},
+		{"not in shims", writeFile(t, dir, ".pyenv/bin/python", pyenvShim), ""},
+		{"other script", writeFile(t, dir, "other/shims/python", "#!/bin/sh\nexec /usr/bin/python3 \"$@\"\n"), ""},
+		{"missing", filepath.Join(dir, "missing/shims/python"), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			result := ""
+			if m := shimManager(test.path); m != nil {
+				result = m.name
+			}
+			if result != test.expected {
+				t.Errorf("expected %q but got %q", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestResolveShim(t *testing.T) {
+	useEmptyDefaultPath(t)
+	home := t.TempDir()
+	writeFile(t, home, ".tool-versions", "nodejs 20.1.0\npython 3.10.13 3.9.18\n")
+
+	t.Run("pyenv which", func(t *testing.T) {
+		root := t.TempDir()
+		shim := writeFile(t, root, "shims/python", pyenvShim)
+		pyenv := writeFile(t, root, "bin/pyenv", "#!/bin/sh\n")
+		python := writeFile(t, root, "versions/3.11.4/bin/python", "")
+		RunCmdOut([]string{pyenv, "which", "python"}, python+"\n").Setup(t)
+
+		result, err := versionManagers[0].resolve(context.TODO(), shim, env{})
+		if err != nil || result != python {
+			t.Errorf("expected %q but got %q (%v)", python, result, err)
+		}
+	})
+
+	tests := []struct {
+		description string
+		manager     int
+		files       map[string]string
+		env         env
+		shouldErr   bool
+		expected    string
+	}{
+		{
+			description: "PYENV_VERSION",
+			manager:     0,
+			files:       map[string]string{"version": "3.9.18\n"},
+			env:         env{"PYENV_VERSION": "3.11.4"},
+			expected:    "versions/3.11.4/bin/gunicorn",
+		},
+		{
+			description: "pyenv global version",
+			manager:     0,
+			files:       map[string]string{"version": "3.9.18\n"},
+			expected:    "versions/3.9.18/bin/gunicorn",
+		},
+		{
+			description: "pyenv system",
+			manager:     0,
+			files:       map[string]string{"version": "system\n"},
+			shouldErr:   true,
+		},
+		{
+			description: "pyenv not installed",
+			manager:     0,
+			env:         env{"PYENV_VERSION": "3.12.0"},
+			shouldErr:   true,
+		},
+		{
+			description: "asdf .tool-versions",
+			manager:     1,
+			env:         env{"HOME": home},
+			expected:    "installs/python/3.10.13/bin/gunicorn",
+		},
+		{
+			description: "no version",
+			manager:     1,
+			shouldErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			root := t.TempDir()
+			shim := writeFile(t, root, "shims/gunicorn", pyenvShim)
+			for _, v := range []string{"3.11.4", "3.10.13", "3.9.18"} {
+				writeFile(t, root, "versions/"+v+"/bin/gunicorn", "")
+				writeFile(t, root, "installs/python/"+v+"/bin/gunicorn", "")
+			}
+			for name, contents := range test.files {
+				writeFile(t, root, name, contents)
+			}
+			if test.env == nil {
+				test.env = env{}
+			}
+			result, err := versionManagers[test.manager].resolve(context.TODO(), shim, test.env)
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %q", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if diff := cmp.Diff(filepath.Join(root, test.expected), result); diff != "" {
+				t.Errorf("resolved shim differs (-got, +want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestUnwrapLauncherWithShims(t *testing.T) {
+	useEmptyDefaultPath(t)
+	root := t.TempDir()
+	writeFile(t, root, "shims/python", pyenvShim)
+	writeFile(t, root, "shims/gunicorn", pyenvShim)
+	python := writeFile(t, root, "versions/3.11.4/bin/python3.11", "\x7fELF")
+	writeFile(t, root, "versions/3.11.4/bin/gunicorn", "#!"+python+"\nprint('hi')\n")
+	e := env{"PATH": filepath.Join(root, "shims"), "PYENV_VERSION": "3.11.4"}
+
+	pc := pythonContext{args: []string{"gunicorn", "app:app"}, env: e}
+	if err := pc.unwrapLauncher(context.TODO()); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	expected := []string{python, filepath.Join(root, "versions/3.11.4/bin/gunicorn"), "app:app"}
+	if diff := cmp.Diff(expected, pc.args); diff != "" {
+		t.Errorf("args differ (-got, +want): %s", diff)
+	}
+
+	// the python shim is resolved so that the version is detected from the real interpreter
+	writeFile(t, root, "versions/3.11.4/bin/python", "\x7fELF")
+	pc = pythonContext{args: []string{"python", "app.py"}, env: e}
+	if err := pc.unwrapLauncher(context.TODO()); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if diff := cmp.Diff([]string{filepath.Join(root, "versions/3.11.4/bin/python"), "app.py"}, pc.args); diff != "" {
+		t.Errorf("args differ (-got, +want): %s", diff)
+	}
+
+	// an unresolvable python shim is used as-is
+	pc = pythonContext{args: []string{"python", "app.py"}, env: env{"PATH": filepath.Join(root, "shims"), "PYENV_VERSION": "system"}}
+	if err := pc.unwrapLauncher(context.TODO()); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if diff := cmp.Diff([]string{"python", "app.py"}, pc.args); diff != "" {
+		t.Errorf("args differ (-got, +want): %s", diff)
+	}
+}
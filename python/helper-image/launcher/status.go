@@ -0,0 +1,414 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// procNetTCP are the kernel socket tables examined to determine backend readiness
+// and attached clients.  Examining the tables avoids connecting to the backend:
+// pydevd accepts only a single connection.
+var procNetTCP = []string{"/proc/net/tcp", "/proc/net/tcp6"} // for testing
+
+// procRoot is where the app's processes and their open sockets are found.  Only the
+// sockets held by the app's processes are considered, as another process in the
+// container may use the same port.
+var procRoot = "/proc" // for testing
+
+// TCP states from include/net/tcp_states.h
+const (
+	tcpEstablished = 0x01
+	tcpListen      = 0x0A
+)
+
+// launchStatus is the status reported by the status endpoint.
+type launchStatus struct {
+	Configured bool   `json:"configured"`
+	Mode       string `json:"mode"`
+	ModeReason string `json:"modeReason,omitempty"`
+	Port       uint   `json:"port"`
+	PortRange  string `json:"portRange,omitempty"`
+	// Connect is the address of the debugger to which the backend connects, if any
+	Connect        string   `json:"connect,omitempty"`
+	PythonVersion  string   `json:"pythonVersion,omitempty"`
+	Implementation string   `json:"implementation,omitempty"`
+	CommandLine    []string `json:"commandLine"`
+	PID            int      `json:"pid,omitempty"`
+	Running        bool     `json:"running"`
+	ExitCode       *int     `json:"exitCode,omitempty"`
+	// Signal is the signal that killed the app, if any
+	Signal string `json:"signal,omitempty"`
+	// Ready is true if the backend is accepting connections, or has connected to the
+	// debugger with Connect
+	Ready bool `json:"ready"`
+	// Clients is the number of connected debugger clients
+	Clients int `json:"clients"`
+	// Workers are the processes that have claimed ports from the port range
+	Workers []workerStatus `json:"workers,omitempty"`
+}
+
+// workerStatus describes a process that has claimed a port from the port range.
+type workerStatus struct {
+	PID     int  `json:"pid"`
+	PPID    int  `json:"ppid"`
+	Port    uint `json:"port"`
+	Ready   bool `json:"ready"`
+	Clients int  `json:"clients"`
+}
+
+// statusServer serves the launch status as JSON over HTTP from the supervising launcher.
+type statusServer struct {
+	mu     sync.Mutex
+	status launchStatus
+}
+
+// newStatusServer creates a status server for the launch context.  configured should be
+// false if the app is being launched without debugging.
+func newStatusServer(pc *pythonContext, configured bool) *statusServer {
+	s := launchStatus{
+		Configured:     configured,
+		Mode:           pc.debugMode,
+		ModeReason:     pc.modeReason,
+		Port:           pc.port,
+		Connect:        pc.connect,
+		Implementation: pc.implementation,
+		CommandLine:    pc.args,
+	}
+	if !pc.portRange.isEmpty() {
+		s.PortRange = pc.portRange.String()
+	}
+	if pc.version != (pythonVersion{}) {
+		s.PythonVersion = pc.version.String()
+	}
+	return &statusServer{status: s}
+}
+
+// serve starts serving the status at the given address in the background.
+func (s *statusServer) serve(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("unable to serve status on %q: %w", address, err)
+	}
+	logrus.Infof("serving launch status on %s", l.Addr())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/readyz", s.handleReady)
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			logrus.Warn("status server failed: ", err)
+		}
+	}()
+	return nil
+}
+
+// started records the process ID of the launched app.
+func (s *statusServer) started(pid int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.PID = pid
+	s.status.Running = true
+}
+
+// exited records the exit of the launched app.
+func (s *statusServer) exited(ws syscall.WaitStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Running = false
+	code := exitCode(ws)
+	s.status.ExitCode = &code
+	if ws.Signaled() {
+		s.status.Signal = ws.Signal().String()
+	}
+}
+
+// current returns the current status, probing the backend ports.
+func (s *statusServer) current() launchStatus {
+	s.mu.Lock()
+	status := s.status
+	s.mu.Unlock()
+
+	var owned map[uint64]bool
+	if status.Running {
+		var err error
+		if owned, err = processTreeSockets(status.PID); err != nil {
+			logrus.Debug("unable to examine the app's sockets, considering all sockets: ", err)
+		}
+	}
+	sockets, err := readSocketTables(owned)
+	if err != nil {
+		logrus.Debug("unable to examine sockets: ", err)
+	}
+	if status.PortRange != "" {
+		status.Workers = readWorkers()
+		for i := range status.Workers {
+			status.Workers[i].Ready, status.Workers[i].Clients = sockets.probe(status.Workers[i].Port)
+		}
+	}
+	switch {
+	case !status.Configured || !status.Running:
+	case status.Connect != "":
+		// the backend connects to the debugger rather than accepting connections
+		status.Clients = sockets.connected(status.Port)
+		status.Ready = status.Clients > 0
+	case status.PortRange != "":
+		// ready once any of the processes is listening on its port
+		for _, w := range status.Workers {
+			status.Ready = status.Ready || w.Ready
+			status.Clients += w.Clients
+		}
+	default:
+		status.Ready, status.Clients = sockets.probe(status.Port)
+	}
+	return status
+}
+
+func (s *statusServer) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	writeStatus(w, http.StatusOK, s.current())
+}
+
+// handleReady responds with 200 OK if the backend is ready, for use as a readiness probe.
+func (s *statusServer) handleReady(w http.ResponseWriter, _ *http.Request) {
+	status := s.current()
+	if status.Configured && !status.Ready {
+		writeStatus(w, http.StatusServiceUnavailable, status)
+		return
+	}
+	writeStatus(w, http.StatusOK, status)
+}
+
+func writeStatus(w http.ResponseWriter, code int, status launchStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logrus.Debug("unable to write status: ", err)
+	}
+}
+
+// readWorkers returns the processes that have claimed ports from the port range,
+// ordered by port.
+func readWorkers() []workerStatus {
+	files, err := filepath.Glob(filepath.Join(portsDir(), "*.json"))
+	if err != nil {
+		return nil
+	}
+	var workers []workerStatus
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var w workerStatus
+		if err := json.Unmarshal(data, &w); err != nil || w.Port == 0 {
+			continue
+		}
+		workers = append(workers, w)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].Port < workers[j].Port })
+	return workers
+}
+
+// socketTable records the states of the sockets by their local and remote ports.
+type socketTable struct {
+	local, remote map[uint][]int
+}
+
+// probe returns whether a socket is listening on the port, and the number of
+// established connections to the port.
+func (t socketTable) probe(port uint) (bool, int) {
+	listening := false
+	established := 0
+	for _, state := range t.local[port] {
+		switch state {
+		case tcpListen:
+			listening = true
+		case tcpEstablished:
+			established++
+		}
+	}
+	return listening, established
+}
+
+// connected returns the number of established connections to the remote port,
+// such as those made by a backend that connects to the debugger.
+func (t socketTable) connected(port uint) int {
+	established := 0
+	for _, state := range t.remote[port] {
+		if state == tcpEstablished {
+			established++
+		}
+	}
+	return established
+}
+
+// readSocketTables reads the kernel TCP socket tables.  If owned is not nil, only the
+// sockets with those inodes are included.
+func readSocketTables(owned map[uint64]bool) (socketTable, error) {
+	table := socketTable{local: map[uint][]int{}, remote: map[uint][]int{}}
+	var lastErr error
+	found := false
+	for _, p := range procNetTCP {
+		f, err := os.Open(p)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		found = true
+		parseSocketTable(f, table, owned)
+		f.Close()
+	}
+	if !found {
+		return table, lastErr
+	}
+	return table, nil
+}
+
+// parseSocketTable parses a `/proc/net/tcp` table, where each line is like:
+//
+//	sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+//	0: 0100007F:162E 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 ...
+func parseSocketTable(r io.Reader, table socketTable, owned map[uint64]bool) {
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // skip header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		if owned != nil {
+			inode, err := strconv.ParseUint(fields[9], 10, 64)
+			if err != nil || !owned[inode] {
+				continue
+			}
+		}
+		local, ok := parseSocketPort(fields[1])
+		if !ok {
+			continue
+		}
+		remote, ok := parseSocketPort(fields[2])
+		if !ok {
+			continue
+		}
+		state, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			continue
+		}
+		table.local[local] = append(table.local[local], int(state))
+		table.remote[remote] = append(table.remote[remote], int(state))
+	}
+}
+
+// parseSocketPort returns the port of a socket table address like `0100007F:162E`.
+func parseSocketPort(address string) (uint, bool) {
+	i := strings.LastIndex(address, ":")
+	if i < 0 {
+		return 0, false
+	}
+	port, err := strconv.ParseUint(address[i+1:], 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint(port), true
+}
+
+// processTreeSockets returns the inodes of the sockets held open by the process and
+// its descendants, as found from the `socket:[inode]` links under `/proc/<pid>/fd`.
+func processTreeSockets(pid int) (map[uint64]bool, error) {
+	pids, err := processTree(pid)
+	if err != nil {
+		return nil, err
+	}
+	inodes := map[uint64]bool{}
+	for i, p := range pids {
+		fdDir := filepath.Join(procRoot, strconv.Itoa(p), "fd")
+		f, err := os.Open(fdDir)
+		if err != nil {
+			if i == 0 {
+				// the app's own sockets are inaccessible
+				return nil, err
+			}
+			continue // the process may have exited
+		}
+		fds, _ := f.Readdirnames(-1)
+		f.Close()
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd))
+			if err != nil || !strings.HasPrefix(link, "socket:[") || !strings.HasSuffix(link, "]") {
+				continue
+			}
+			if inode, err := strconv.ParseUint(link[len("socket:["):len(link)-1], 10, 64); err == nil {
+				inodes[inode] = true
+			}
+		}
+	}
+	return inodes, nil
+}
+
+// processTree returns the process followed by its descendants, as found from the
+// parent pids recorded in `/proc/<pid>/stat`.
+func processTree(pid int) ([]int, error) {
+	if _, err := os.Stat(filepath.Join(procRoot, strconv.Itoa(pid))); err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	children := map[int][]int{}
+	for _, e := range entries {
+		child, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := ioutil.ReadFile(filepath.Join(procRoot, e.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// the command name may contain spaces and parentheses: `pid (comm) state ppid ...`
+		i := strings.LastIndex(string(stat), ")")
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		if ppid, err := strconv.Atoi(fields[1]); err == nil {
+			children[ppid] = append(children[ppid], child)
+		}
+	}
+	tree := []int{pid}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+	return tree, nil
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// a listener on 127.0.0.1:5678 (0x162E) with one connection, and a listener on :::5680 (0x1630)
+const (
+	testTCPTable = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:162E 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:162E 0100007F:D431 01 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:D431 0100007F:162E 01 00000000:00000000 00:00000000 00000000     0        0 1003 1 0000000000000000 20 4 30 10 -1
+   3: 0100007F:162F 0100007F:D432 06 00000000:00000000 03:00000000 00000000     0        0 0 3 0000000000000000
+`
+	testTCP6Table = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:1630 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1004 1 0000000000000000 100 0 0 10 0
+`
+)
+
+// useSocketTables has the status server examine the given socket tables.  The app's
+// processes are not found, and so all sockets are considered.
+func useSocketTables(t *testing.T, tables ...string) {
+	dir := t.TempDir()
+	oldProcNetTCP := procNetTCP
+	procNetTCP = nil
+	for i, table := range tables {
+		procNetTCP = append(procNetTCP, writeFile(t, dir, "tcp"+string(rune('0'+i)), table))
+	}
+	t.Cleanup(func() { procNetTCP = oldProcNetTCP })
+	useProcesses(t, nil)
+}
+
+// useProcesses has the status server find the given processes, described by their
+// parent pid and the socket inodes that they hold open.
+func useProcesses(t *testing.T, processes map[int]testProcess) {
+	dir := t.TempDir()
+	for pid, p := range processes {
+		writeFile(t, dir, fmt.Sprintf("%d/stat", pid), fmt.Sprintf("%d (python3 (app)) S %d 1 1 0 -1", pid, p.ppid))
+		if err := os.MkdirAll(filepath.Join(dir, strconv.Itoa(pid), "fd"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for i, inode := range p.sockets {
+			if err := os.Symlink(fmt.Sprintf("socket:[%d]", inode), filepath.Join(dir, strconv.Itoa(pid), "fd", strconv.Itoa(i+3))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	oldProcRoot := procRoot
+	procRoot = dir
+	t.Cleanup(func() { procRoot = oldProcRoot })
+}
+
+type testProcess struct {
+	ppid    int
+	sockets []uint64
+}
+
+func TestSocketTableProbe(t *testing.T) {
+	useSocketTables(t, testTCPTable, testTCP6Table)
+	table, err := readSocketTables(nil)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	tests := []struct {
+		port      uint
+		listening bool
+		clients   int
+	}{
+		{5678, true, 1},
+		{5679, false, 0}, // TIME_WAIT
+		{5680, true, 0},
+		{9999, false, 0},
+	}
+	for _, test := range tests {
+		listening, clients := table.probe(test.port)
+		if listening != test.listening || clients != test.clients {
+			t.Errorf("port %d: expected listening=%v clients=%d but got %v and %d", test.port, test.listening, test.clients, listening, clients)
+		}
+	}
+}
+
+func TestProcessTreeSockets(t *testing.T) {
+	useProcesses(t, map[int]testProcess{
+		1234: {ppid: 1, sockets: []uint64{1001}},
+		1240: {ppid: 1234, sockets: []uint64{1002}},
+		1250: {ppid: 1240, sockets: []uint64{1005}},
+		999:  {ppid: 1, sockets: []uint64{1004}},
+	})
+	owned, err := processTreeSockets(1234)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if diff := cmp.Diff(map[uint64]bool{1001: true, 1002: true, 1005: true}, owned); diff != "" {
+		t.Errorf("sockets differ (-got, +want): %s", diff)
+	}
+	if _, err := processTreeSockets(4321); err == nil {
+		t.Error("expected an error for a missing process")
+	}
+}
+
+func TestStatusServerOtherProcess(t *testing.T) {
+	useSocketTables(t, testTCPTable, testTCP6Table)
+	// the listener on 5678 is held by the app, but the listener on 5680 is not
+	useProcesses(t, map[int]testProcess{
+		1234: {ppid: 1, sockets: []uint64{1001, 1002}},
+		999:  {ppid: 1, sockets: []uint64{1004}},
+	})
+	tests := []struct {
+		port    uint
+		ready   bool
+		clients int
+	}{
+		{5678, true, 1},
+		{5680, false, 0},
+	}
+	for _, test := range tests {
+		pc := pythonContext{debugMode: "debugpy", port: test.port, args: []string{"python", "app.py"}}
+		s := newStatusServer(&pc, true)
+		s.started(1234)
+		if status := s.current(); status.Ready != test.ready || status.Clients != test.clients {
+			t.Errorf("port %d: expected ready=%v clients=%d but got %+v", test.port, test.ready, test.clients, status)
+		}
+	}
+}
+
+func TestStatusServer(t *testing.T) {
+	useSocketTables(t, testTCPTable)
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+
+	pc := pythonContext{debugMode: "debugpy", port: 5678, version: pythonVersion{major: 3, minor: 11, patch: 4}, implementation: "cpython", args: []string{"python", "-m", "debugpy", "--listen", "5678", "app.py"}}
+	s := newStatusServer(&pc, true)
+
+	// not ready until the app has started
+	recorder := httptest.NewRecorder()
+	s.handleReady(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before start but got %d", recorder.Code)
+	}
+
+	s.started(1234)
+	recorder = httptest.NewRecorder()
+	s.handleReady(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected 200 once started but got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	s.handleStatus(recorder, httptest.NewRequest("GET", "/status", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("expected 200 with JSON but got %d %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	var result launchStatus
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal("invalid status:", err)
+	}
+	expected := launchStatus{Configured: true, Mode: "debugpy", Port: 5678, PythonVersion: "3.11.4", Implementation: "cpython", CommandLine: pc.args, PID: 1234, Running: true, Ready: true, Clients: 1}
+	if diff := cmp.Diff(expected, result); diff != "" {
+		t.Errorf("status differs (-got, +want): %s", diff)
+	}
+
+	s.exited(0)
+	if status := s.current(); status.Running || status.Ready || status.ExitCode == nil || *status.ExitCode != 0 {
+		t.Errorf("expected exited status but got %+v", status)
+	}
+
+	// killed by SIGTERM
+	s.exited(syscall.WaitStatus(syscall.SIGTERM))
+	if status := s.current(); status.ExitCode == nil || *status.ExitCode != 143 || status.Signal != "terminated" {
+		t.Errorf("expected killed status but got %+v", status)
+	}
+}
+
+func TestStatusServerWorkers(t *testing.T) {
+	useSocketTables(t, testTCPTable)
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+	writeFile(t, portsDir(), "5679.json", `{"pid": 11, "ppid": 10, "port": 5679, "argv": ["app.py"]}`)
+	writeFile(t, portsDir(), "5678.json", `{"pid": 10, "ppid": 1, "port": 5678, "argv": ["app.py"]}`)
+	writeFile(t, portsDir(), "bad.json", `not json`)
+
+	pc := pythonContext{debugMode: "debugpy", port: 5678, portRange: portRange{first: 5678, last: 5687}, args: []string{"python", "launch.py"}}
+	s := newStatusServer(&pc, true)
+	s.started(10)
+	status := s.current()
+	expected := []workerStatus{{PID: 10, PPID: 1, Port: 5678, Ready: true, Clients: 1}, {PID: 11, PPID: 10, Port: 5679}}
+	if diff := cmp.Diff(expected, status.Workers); diff != "" {
+		t.Errorf("workers differ (-got, +want): %s", diff)
+	}
+	if status.PortRange != "5678-5687" {
+		t.Errorf("expected port range 5678-5687 but got %q", status.PortRange)
+	}
+	if !status.Ready || status.Clients != 1 {
+		t.Errorf("expected ready with 1 client once a worker listens but got %v and %d", status.Ready, status.Clients)
+	}
+
+	// not ready until some worker is listening
+	writeFile(t, portsDir(), "5678.json", "")
+	if status := s.current(); status.Ready {
+		t.Errorf("expected not ready without a listening worker: %+v", status)
+	}
+}
+
+func TestStatusServerConnect(t *testing.T) {
+	useSocketTables(t, testTCPTable)
+	tests := []struct {
+		description string
+		port        uint
+		ready       bool
+	}{
+		{"connected", 5678, true},
+		{"not yet connected", 9999, false},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			pc := pythonContext{debugMode: "debugpy", port: test.port, connect: "ide.example.com:5678", args: []string{"python", "app.py"}}
+			s := newStatusServer(&pc, true)
+			s.started(1234)
+			recorder := httptest.NewRecorder()
+			s.handleReady(recorder, httptest.NewRequest("GET", "/readyz", nil))
+			if status := s.current(); status.Ready != test.ready {
+				t.Errorf("expected ready=%v but got %+v", test.ready, status)
+			}
+			if expected := map[bool]int{true: http.StatusOK, false: http.StatusServiceUnavailable}[test.ready]; recorder.Code != expected {
+				t.Errorf("expected %d but got %d", expected, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestStatusServerNotConfigured(t *testing.T) {
+	useSocketTables(t, testTCPTable)
+	pc := pythonContext{debugMode: "debugpy", port: 5678, args: []string{"app"}}
+	s := newStatusServer(&pc, false)
+	s.started(1234)
+	recorder := httptest.NewRecorder()
+	s.handleReady(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("unconfigured app should be ready but got %d", recorder.Code)
+	}
+	if status := s.current(); status.Ready || status.Configured {
+		t.Errorf("unconfigured app should not report the backend: %+v", status)
+	}
+}
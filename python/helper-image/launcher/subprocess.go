@@ -0,0 +1,125 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// childPortCount is the number of ports following the debug port from which pydevd's
+// child processes claim their ports.
+const childPortCount = 10
+
+// childPortLease is how long, in seconds, a child's port claim is held by the parent
+// process before the claim is considered stale should the port be free.  The child
+// should be listening on the port by then.
+const childPortLease = 60
+
+// childPortsBootstrap follows portClaimBootstrap in a script that runs pydevd such that
+// each child process that pydevd debugs is given a port of its own.  pydevd launches its
+// children with its own settings, including the port, as obtained through setup_to_argv().
+// The port is claimed by the parent for a short lease, and the children run this script in
+// place of pydevd so that each child then records itself as holding the port, and so that
+// its own children are given ports too.  As with `python -m pydevd`, the current directory
+// is first on the path.
+const childPortsBootstrap = `from _pydevd_bundle import pydevd_command_line_handling as _skaffold_cl
+
+_skaffold_script = os.path.abspath(__file__)
+_skaffold_setup_to_argv = _skaffold_cl.setup_to_argv
+
+def _skaffold_bindable(port):
+    s = socket.socket(socket.AF_INET, socket.SOCK_STREAM)
+    try:
+        s.bind(('', port))
+        return True
+    except socket.error:
+        return False
+    finally:
+        s.close()
+
+def _skaffold_child_setup_to_argv(setup, skip_names=None):
+    # the child's pydevd binds the port itself, and so the port can only be probed
+    port = _skaffold_claim(lease={lease})
+    while port is not None and not _skaffold_bindable(port):
+        _skaffold_release(port)
+        port = _skaffold_claim(port + 1, lease={lease})
+    if port is None:
+        sys.stderr.write('skaffold: no free debug port in %d-%d for a child of process %d\n' % (_skaffold_first, _skaffold_last, os.getpid()))
+    else:
+        setup = dict(setup)
+        setup['port'] = port
+    argv = _skaffold_setup_to_argv(setup, skip_names)
+    if argv and os.path.basename(argv[0]).startswith('pydevd.py'):
+        argv[0] = _skaffold_script
+    return argv
+
+def _skaffold_adopt():
+    # record this child, rather than the parent that claimed the port on its behalf
+    try:
+        port = int(sys.argv[sys.argv.index('--port') + 1])
+    except (ValueError, IndexError):
+        return
+    if not _skaffold_first <= port <= _skaffold_last:
+        return
+    argv = sys.argv[sys.argv.index('--file') + 1:] if '--file' in sys.argv else sys.argv
+    pid = os.getpid()
+    info = {'pid': pid, 'ppid': os.getppid(), 'start': _skaffold_start_time(pid), 'boot': _skaffold_boot, 'port': port, 'argv': argv}
+    _skaffold_update(port, lambda claim: info if claim.get('expires') else None)
+
+_skaffold_adopt()
+_skaffold_cl.setup_to_argv = _skaffold_child_setup_to_argv
+
+` + launchPreamble + `import runpy
+runpy.run_module('pydevd', run_name='__main__', alter_sys=True)
+`
+
+// childPortRange returns the range of ports from which pydevd's child processes claim ports.
+func (pc *pythonContext) childPortRange() (portRange, error) {
+	first := pc.port + 1
+	last := pc.port + childPortCount
+	if last > 65535 {
+		last = 65535
+	}
+	if first > last {
+		return portRange{}, fmt.Errorf("no ports above %d for child processes", pc.port)
+	}
+	return portRange{first: first, last: last}, nil
+}
+
+// childPortsScript returns a script that runs pydevd with the script's arguments and
+// that has pydevd's child processes claim ports from the range.
+func childPortsScript(r portRange) string {
+	bootstrap := portClaimReplacer(r, "{lease}", fmt.Sprint(childPortLease)).Replace(portClaimBootstrap + childPortsBootstrap)
+	return "import base64\n" + bootstrap
+}
+
+// writeChildPortsScript writes out a script to run pydevd such that its child processes
+// claim ports of their own, and returns its location.
+func (pc *pythonContext) writeChildPortsScript() (string, error) {
+	r, err := pc.childPortRange()
+	if err != nil {
+		return "", err
+	}
+	f, err := pc.writeScript("pydevd*", "skaffold_pydevd_children.py", childPortsScript(r))
+	if err != nil {
+		return "", err
+	}
+	logrus.Infof("child processes claim debug ports from %s; allocations are recorded in %q", r, portsDir())
+	return f, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestChildPortRange(t *testing.T) {
+	tests := []struct {
+		port      uint
+		shouldErr bool
+		expected  portRange
+	}{
+		{port: 5678, expected: portRange{first: 5679, last: 5688}},
+		{port: 65530, expected: portRange{first: 65531, last: 65535}},
+		{port: 65535, shouldErr: true},
+	}
+	for _, test := range tests {
+		pc := pythonContext{port: test.port}
+		result, err := pc.childPortRange()
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("port %d: expected an error but got %v", test.port, result)
+			}
+		} else if err != nil {
+			t.Errorf("port %d: unexpected error: %v", test.port, err)
+		} else if result != test.expected {
+			t.Errorf("port %d: expected %v but got %v", test.port, test.expected, result)
+		}
+	}
+}
+
+func TestUpdateCommandLineWithPydevdSubprocesses(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+
+	pc := pythonContext{debugMode: "pydevd", port: 5678, subprocess: true, args: []string{"python", "-u", "-m", "celery", "worker"}, env: env{}}
+	if err := pc.updateCommandLine(context.TODO()); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(pc.args) < 3 || !fileMatch(t, filepath.Join(dbgRoot, "python", "tmp", "pydevd*", "skaffold_pydevd_children.py"), pc.args[2]) {
+		t.Fatalf("expected python -u <pydevd script> ... but got %q", pc.args)
+	}
+	expected := []string{"python", "-u", pc.args[2], "--server", "--port", "5678", "--continue", "--multiprocess", "--module", "--file", "celery", "worker"}
+	if diff := cmp.Diff(expected, pc.args); diff != "" {
+		t.Errorf("args differ (-got, +want): %s", diff)
+	}
+	script, err := ioutil.ReadFile(pc.args[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{
+		"_skaffold_first, _skaffold_last = 5679, 5688",
+		"_skaffold_claim(lease=60)",
+		"_skaffold_cl.setup_to_argv = _skaffold_child_setup_to_argv",
+		"argv[0] = _skaffold_script",
+		"_skaffold_adopt()",
+		"runpy.run_module('pydevd', run_name='__main__', alter_sys=True)",
+	} {
+		if !strings.Contains(string(script), c) {
+			t.Errorf("script should contain %q:\n%s", c, script)
+		}
+	}
+	if len(pc.scripts) != 1 {
+		t.Errorf("script should be recorded for removal: %q", pc.scripts)
+	}
+}
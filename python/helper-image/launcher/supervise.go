@@ -0,0 +1,224 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+)
+
+// for testing
+var execve = syscall.Exec
+var getpid = os.Getpid
+
+// forwardedSignals are relayed to the app's process group.
+var forwardedSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// reraisedSignals are the signals that the Go runtime handles by dying from the
+// signal, and so can be used to mirror the app's death by the signal.
+var reraisedSignals = map[syscall.Signal]bool{syscall.SIGTERM: true, syscall.SIGINT: true, syscall.SIGHUP: true, syscall.SIGKILL: true}
+
+// processGroupAttr returns the attributes to start the app in its own process group so
+// that signals can be forwarded to the app and its children.  If stdin is a terminal,
+// the app's group is made the foreground group so that the app can read from it;
+// restoreForeground hands the terminal back once the app exits.
+func processGroupAttr() *syscall.SysProcAttr {
+	if isTerminal(os.Stdin) {
+		return &syscall.SysProcAttr{Setpgid: true, Foreground: true, Ctty: 0}
+	}
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// restoreForeground makes the launcher's process group the foreground group of the
+// terminal again, as otherwise the shell that started the launcher is left without
+// its terminal.  The launcher is in a background group at this point and so must
+// ignore SIGTTOU to change the foreground group.
+func restoreForeground() {
+	if !isTerminal(os.Stdin) {
+		return
+	}
+	signal.Ignore(syscall.SIGTTOU)
+	pgrp := int32(syscall.Getpgrp())
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&pgrp)))
+	if errno != 0 {
+		logrus.Debug("unable to restore the terminal's foreground process group: ", errno)
+	}
+}
+
+// isTerminal returns true if the file is a terminal.
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
+
+// execInPlace replaces the launcher with the command, for when the launcher has nothing
+// to do while the app runs.  The command is found in the PATH of the app's environment.
+// It returns only if the command could not be executed.
+func execInPlace(cmdline []string, env env) error {
+	p, err := lookPath(cmdline[0], env)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("exec: %v", cmdline)
+	return execve(p, cmdline, env.AsPairs())
+}
+
+// supervise runs the command, reporting its progress to the status server, if any,
+// and describing the debug session while the command runs if describe is true.
+// Launch scripts are removed once the command exits, and the launcher then exits
+// with the command's exit status.
+func (pc *pythonContext) supervise(cmd commander, status *statusServer, describe bool) {
+	if err := cmd.Start(); err != nil {
+		pc.removeScripts()
+		logrus.Fatal("error launching python debugging: ", err)
+	}
+	pid := commandPid(cmd)
+	if status != nil {
+		status.started(pid)
+	}
+	var session string
+	if describe {
+		f, err := writeSessionDescriptor(pc.newSessionDescriptor(pid))
+		if err != nil {
+			logrus.Warn(err)
+		}
+		session = f
+	}
+	ws, err := waitForApp(cmd, pid)
+	restoreForeground()
+	pc.removeScripts()
+	if session != "" {
+		if rmErr := os.Remove(session); rmErr != nil {
+			logrus.Debug("unable to remove session descriptor: ", rmErr)
+		}
+	}
+	if err != nil {
+		logrus.Fatal("error launching python debugging: ", err)
+	}
+	if status != nil {
+		status.exited(ws)
+	}
+	exit(ws)
+}
+
+// waitForApp waits for the app to exit while forwarding signals to its process group.
+// When the launcher is running as init (PID 1), orphaned processes are reaped too.
+func waitForApp(cmd commander, pid int) (syscall.WaitStatus, error) {
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+	if getpid() != 1 {
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		for {
+			select {
+			case s := <-signals:
+				forwardSignal(pid, s)
+			case err := <-done:
+				return waitStatus(err)
+			}
+		}
+	}
+
+	// as init, reap all children; the app's exit status is collected along the way
+	signal.Notify(signals, syscall.SIGCHLD)
+	for {
+		ws, exited, err := reap(pid)
+		if exited || err != nil {
+			return ws, err
+		}
+		if s := <-signals; s != syscall.SIGCHLD {
+			forwardSignal(pid, s)
+		}
+	}
+}
+
+// forwardSignal relays a signal to the process group of the app.
+func forwardSignal(pid int, s os.Signal) {
+	sig, ok := s.(syscall.Signal)
+	if !ok || pid <= 0 {
+		return
+	}
+	logrus.Debugf("forwarding %s to process group %d", sig, pid)
+	if err := syscall.Kill(-pid, sig); err != nil && err != syscall.ESRCH {
+		logrus.Debugf("unable to forward %s: %v", sig, err)
+	}
+}
+
+// reap collects the exit status of all exited children without blocking, and returns
+// the exit status of the app if it was among them.
+func reap(pid int) (syscall.WaitStatus, bool, error) {
+	for {
+		var ws syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err != nil:
+			return 0, false, fmt.Errorf("unable to wait for app: %w", err)
+		case wpid <= 0:
+			return 0, false, nil
+		case wpid == pid:
+			return ws, true, nil
+		default:
+			logrus.Debugf("reaped orphaned process %d", wpid)
+		}
+	}
+}
+
+// waitStatus returns the wait status from the result of waiting for a command.
+func waitStatus(err error) (syscall.WaitStatus, error) {
+	if err == nil {
+		return 0, nil
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok {
+			return ws, nil
+		}
+	}
+	return 0, err
+}
+
+// exitCode returns the conventional shell exit code for a wait status: the exit code
+// of the process, or 128 plus the signal number if killed by a signal.
+func exitCode(ws syscall.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// exit exits with the same status as the app.  If the app was killed by a signal then
+// the launcher kills itself with the same signal where possible.
+func exit(ws syscall.WaitStatus) {
+	if ws.Signaled() && reraisedSignals[ws.Signal()] && getpid() != 1 {
+		// init is immune to signals without a handler
+		signal.Reset(ws.Signal())
+		syscall.Kill(getpid(), ws.Signal())
+	}
+	os.Exit(exitCode(ws))
+	// NOTREACHED
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		description string
+		ws          syscall.WaitStatus
+		expected    int
+	}{
+		{"success", 0, 0},
+		{"exit 3", 3 << 8, 3},
+		{"exit 255", 255 << 8, 255},
+		{"SIGTERM", syscall.WaitStatus(syscall.SIGTERM), 143},
+		{"SIGKILL", syscall.WaitStatus(syscall.SIGKILL), 137},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if result := exitCode(test.ws); result != test.expected {
+				t.Errorf("expected %d but got %d", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestWaitStatus(t *testing.T) {
+	ws, err := waitStatus(exec.Command("sh", "-c", "exit 3").Run())
+	if err != nil || ws.Signaled() || ws.ExitStatus() != 3 {
+		t.Errorf("expected exit 3 but got %v (%v)", ws, err)
+	}
+	ws, err = waitStatus(exec.Command("sh", "-c", "kill -TERM $$").Run())
+	if err != nil || !ws.Signaled() || ws.Signal() != syscall.SIGTERM {
+		t.Errorf("expected SIGTERM but got %v (%v)", ws, err)
+	}
+	ws, err = waitStatus(nil)
+	if err != nil || ws != 0 {
+		t.Errorf("expected success but got %v (%v)", ws, err)
+	}
+	if _, err = waitStatus(errors.New("oops")); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestWaitForAppForwardsSignals(t *testing.T) {
+	// the trap handler runs once the current command completes
+	cmd := exec.Command("sh", "-c", `trap "exit 7" TERM; echo ready; while :; do sleep 0.1; done`)
+	cmd.SysProcAttr = processGroupAttr()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stdout.Read(make([]byte, 6)); err != nil {
+		t.Fatal(err)
+	}
+	forwardSignal(cmd.Process.Pid, syscall.SIGTERM)
+
+	ws, err := waitForApp(cmd, cmd.Process.Pid)
+	if err != nil || ws.Signaled() || ws.ExitStatus() != 7 {
+		t.Errorf("expected exit 7 but got %v (%v)", ws, err)
+	}
+}
+
+func TestWaitForAppAsInit(t *testing.T) {
+	oldGetpid := getpid
+	getpid = func() int { return 1 }
+	t.Cleanup(func() { getpid = oldGetpid })
+
+	// another child, standing in for an orphan, is reaped too
+	other := exec.Command("true")
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", "sleep 0.2; exit 5")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan syscall.WaitStatus, 1)
+	go func() {
+		ws, err := waitForApp(cmd, cmd.Process.Pid)
+		if err != nil {
+			t.Error("unexpected error:", err)
+		}
+		result <- ws
+	}()
+	select {
+	case ws := <-result:
+		if ws.Signaled() || ws.ExitStatus() != 5 {
+			t.Errorf("expected exit 5 but got %v", ws)
+		}
+		var ws2 syscall.WaitStatus
+		if _, err := syscall.Wait4(other.Process.Pid, &ws2, syscall.WNOHANG, nil); err != syscall.ECHILD {
+			t.Errorf("expected other child to have been reaped but got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for app")
+	}
+}
+
+func TestReap(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "exit 4")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		ws, exited, err := reap(cmd.Process.Pid)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if exited {
+			if ws.ExitStatus() != 4 {
+				t.Errorf("expected exit 4 but got %v", ws)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("app was not reaped")
+}
+
+func TestExecInPlace(t *testing.T) {
+	var argv0 string
+	var argv, envv []string
+	oldExecve := execve
+	execve = func(p string, a []string, e []string) error {
+		argv0, argv, envv = p, a, e
+		return errors.New("exec'd")
+	}
+	t.Cleanup(func() { execve = oldExecve })
+
+	err := execInPlace([]string{"sh", "-c", "true"}, env{"A": "B"})
+	if err == nil || err.Error() != "exec'd" {
+		t.Fatal("expected execve to be called but got:", err)
+	}
+	if filepath.Base(argv0) != "sh" || !filepath.IsAbs(argv0) {
+		t.Errorf("expected path to sh but got %q", argv0)
+	}
+	if diff := cmp.Diff([]string{"sh", "-c", "true"}, argv); diff != "" {
+		t.Errorf("args differ (-got, +want): %s", diff)
+	}
+	if diff := cmp.Diff([]string{"A=B"}, envv); diff != "" {
+		t.Errorf("env differs (-got, +want): %s", diff)
+	}
+
+	if err := execInPlace([]string{"not-a-real-command"}, nil); err == nil || err.Error() == "exec'd" {
+		t.Error("expected a lookup error but got:", err)
+	}
+
+	// the command is found in the app's PATH rather than the launcher's
+	bin := t.TempDir()
+	app := writeFile(t, bin, "app-only", "#!/bin/sh\n")
+	if err := execInPlace([]string{"app-only"}, env{"PATH": bin}); err == nil || err.Error() != "exec'd" {
+		t.Fatal("expected execve to be called but got:", err)
+	}
+	if argv0 != app {
+		t.Errorf("expected %q but got %q", app, argv0)
+	}
+}
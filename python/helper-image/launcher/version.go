@@ -0,0 +1,396 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// pythonFilenamePattern matches versioned interpreter names like `python3.11`, `python3.13t`,
+	// or `pypy3.10`
+	pythonFilenamePattern = regexp.MustCompile(`^(python|pypy|graalpy)(\d+\.\d+t?)([^.\d]|$)`)
+	// libpythonPattern matches the python shared library like `libpython3.11.so.1.0`
+	// or `libpypy3.10-c.so`
+	libpythonPattern = regexp.MustCompile(`^lib(python|pypy)(\d+\.\d+t?)`)
+	// stdlibDirPattern matches the python standard library directory like `python3.11`
+	// or `pypy3.10`
+	stdlibDirPattern = regexp.MustCompile(`^(python|pypy)(\d+\.\d+t?)$`)
+	// versionPattern matches python version strings like `3.12.1`, `3.13.0rc2`, `3.12.1+`,
+	// `3.13t`, and `3.11.4.final.0` (sys.version_info form, as found in pyvenv.cfg)
+	versionPattern = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.(\d+))?(?:(a|b|rc|c)(\d+)|\.(alpha|beta|candidate|final)\.(\d+))?(t)?\+?(\s.*)?$`)
+)
+
+// pythonVersion is a python version along with the ABI flags that affect the installation layout.
+type pythonVersion struct {
+	major, minor, patch int
+	// preRelease is the pre-release level and serial, like `a1`, `b2`, or `rc3`
+	preRelease string
+	// abiFlags are the ABI flags that affect the library layout: `t` for free-threaded builds
+	abiFlags string
+}
+
+// parsePythonVersion parses a python version string.
+func parsePythonVersion(s string) (pythonVersion, error) {
+	s = strings.TrimSpace(s)
+	m := versionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return pythonVersion{}, fmt.Errorf("invalid python version %q", s)
+	}
+	var v pythonVersion
+	v.major, _ = strconv.Atoi(m[1])
+	v.minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		v.patch, _ = strconv.Atoi(m[3])
+	}
+	switch {
+	case m[4] == "c":
+		v.preRelease = "rc" + m[5]
+	case m[4] != "":
+		v.preRelease = m[4] + m[5]
+	case m[6] == "alpha":
+		v.preRelease = "a" + m[7]
+	case m[6] == "beta":
+		v.preRelease = "b" + m[7]
+	case m[6] == "candidate":
+		v.preRelease = "rc" + m[7]
+	}
+	// free-threaded builds may describe themselves in the version banner
+	if m[8] != "" || strings.Contains(m[9], "free-threading") {
+		v.abiFlags = "t"
+	}
+	return v, nil
+}
+
+// String returns the version in the form used by python, like `3.13.0rc2`.
+func (v pythonVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d%s%s", v.major, v.minor, v.patch, v.preRelease, v.abiFlags)
+}
+
+// libDir returns the name of the library directory for this version, like `python3.11`
+// or `python3.13t` for free-threaded builds.
+func (v pythonVersion) libDir() string {
+	return fmt.Sprintf("python%d.%d%s", v.major, v.minor, v.abiFlags)
+}
+
+// compare compares the major and minor versions, returning -1, 0, or 1 if this
+// version is older, the same, or newer than the other.
+func (v pythonVersion) compare(other pythonVersion) int {
+	switch {
+	case v.major != other.major:
+		return sign(v.major - other.major)
+	default:
+		return sign(v.minor - other.minor)
+	}
+}
+
+func sign(i int) int {
+	switch {
+	case i < 0:
+		return -1
+	case i > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// interpreterInfo describes a python interpreter.
+type interpreterInfo struct {
+	// version is the python language version string
+	version string
+	// implementation is the python implementation, or "" if not known
+	implementation string
+}
+
+// versionDetectors are the inexpensive means to determine the python version for an
+// interpreter, tried in order before resorting to executing `python -VV`.  Each returns
+// an empty version if the version could not be determined.
+var versionDetectors = []struct {
+	name   string
+	detect func(interpreter string) interpreterInfo
+}{
+	{"file name", versionFromFilename},
+	{"pyvenv.cfg", versionFromPyvenvCfg},
+	{"ELF dependencies", versionFromELF},
+	{"library layout", versionFromLibraryLayout},
+}
+
+// detectInterpreter returns the version string and implementation of the given python
+// interpreter.  Previously determined results are cached under the helpers root by the
+// interpreter's location before resolving links, as interpreters in different virtual
+// environments may link to the same binary.
+func detectInterpreter(ctx context.Context, launcherBin string, env env) (interpreterInfo, error) {
+	p, err := lookPath(launcherBin, env)
+	if err != nil {
+		logrus.Debugf("unable to resolve %q: %v", launcherBin, err)
+		return interpreterFromExec(ctx, launcherBin, env)
+	}
+	real, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		real = p
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return interpreterFromExec(ctx, launcherBin, env)
+	}
+
+	if cached, found := readVersionCache(p, info); found {
+		logrus.Debugf("Python %s %q for %q from cache", cached.implementation, cached.version, p)
+		return cached, nil
+	}
+	for i, d := range versionDetectors {
+		if detected := d.detect(p); detected.version != "" {
+			// a venv's `pyvenv.cfg` may not record the implementation
+			for _, other := range versionDetectors[i+1:] {
+				if detected.implementation != "" {
+					break
+				}
+				detected.implementation = other.detect(p).implementation
+			}
+			if detected.implementation == "" {
+				detected.implementation = implementationFromName(p)
+			}
+			logrus.Debugf("Python %s %q for %q from %s", detected.implementation, detected.version, p, d.name)
+			writeVersionCache(p, info, detected)
+			return detected, nil
+		}
+	}
+	detected, err := interpreterFromExec(ctx, launcherBin, env)
+	if err == nil {
+		writeVersionCache(p, info, detected)
+	}
+	return detected, err
+}
+
+// interpreterFromExec determines the python version and implementation by executing `python -VV`,
+// as only the verbose banner describes free-threaded builds.  CPython prints `Python 3.11.4 (...)`
+// or `Python 3.13.1 experimental free-threading build (...)`, PyPy prints
+// `Python 3.10.13 (...)\n[PyPy 7.3.15 ...]`, and GraalPy may print `GraalPy 3.10.8 (...)`.
+// Python 2 and older versions of Python 3 print just the version.
+func interpreterFromExec(ctx context.Context, launcherBin string, env env) (interpreterInfo, error) {
+	logrus.Debugf("trying to determine python version from %q", launcherBin)
+	cmd := newCommand(ctx, []string{launcherBin, "-VV"}, env)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return interpreterInfo{}, fmt.Errorf("unable to determine python version from %q: %w", launcherBin, err)
+	}
+	banner := string(out)
+	logrus.Debugf("'%s -VV' = %q", launcherBin, banner)
+	firstLine := strings.SplitN(strings.TrimSpace(banner), "\n", 2)[0]
+	for _, prefix := range []string{"Python ", "GraalPy "} {
+		if strings.HasPrefix(firstLine, prefix) {
+			version := strings.TrimSpace(firstLine[len(prefix):])
+			// strip any build information like `(75b3de9d9035, Apr 21 2024, 10:54:48)`
+			if i := strings.Index(version, " ("); i > 0 {
+				version = version[:i]
+			}
+			return interpreterInfo{version: version, implementation: implementationFromBanner(banner)}, nil
+		}
+	}
+	return interpreterInfo{}, fmt.Errorf("launcher is not a python interpreter: %q", launcherBin)
+}
+
+// versionFromFilename extracts the version from a versioned interpreter name like `python3.11`,
+// or the name of the file that it links to.
+func versionFromFilename(interpreter string) interpreterInfo {
+	names := []string{filepath.Base(interpreter)}
+	if real, err := filepath.EvalSymlinks(interpreter); err == nil {
+		names = append(names, filepath.Base(real))
+	}
+	for _, name := range names {
+		if m := pythonFilenamePattern.FindStringSubmatch(name); m != nil {
+			return interpreterInfo{version: m[2], implementation: interpreterPrefixes[m[1]]}
+		}
+	}
+	return interpreterInfo{}
+}
+
+// versionFromPyvenvCfg extracts the version from the `pyvenv.cfg` of a virtual environment,
+// found in the interpreter's directory or its parent.
+func versionFromPyvenvCfg(interpreter string) interpreterInfo {
+	dir := filepath.Dir(interpreter)
+	for _, cfg := range []string{filepath.Join(dir, "pyvenv.cfg"), filepath.Join(filepath.Dir(dir), "pyvenv.cfg")} {
+		values := readPyvenvCfg(cfg)
+		// `version` is written by venv, and `version_info` and `implementation` by virtualenv and uv
+		for _, key := range []string{"version", "version_info"} {
+			if v := values[key]; v != "" {
+				info := interpreterInfo{version: freeThreadedVenvVersion(filepath.Dir(cfg), v)}
+				if impl := values["implementation"]; impl != "" {
+					info.implementation = implementationFromBanner(impl)
+				}
+				return info
+			}
+		}
+	}
+	return interpreterInfo{}
+}
+
+// freeThreadedVenvVersion adds the `t` ABI flag to the version recorded for a virtual
+// environment created by a free-threaded build, which records only the version number
+// but installs packages under `lib/pythonX.Yt`.
+func freeThreadedVenvVersion(venv, version string) string {
+	v, err := parsePythonVersion(version)
+	if err != nil || v.abiFlags != "" {
+		return version
+	}
+	v.abiFlags = "t"
+	if pathExists(filepath.Join(venv, "lib", v.libDir())) {
+		return version + "t"
+	}
+	return version
+}
+
+// readPyvenvCfg returns the `key = value` settings from a `pyvenv.cfg` file.
+func readPyvenvCfg(cfg string) map[string]string {
+	f, err := os.Open(cfg)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) == 2 {
+			values[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return values
+}
+
+// versionFromELF extracts the version from the `libpythonX.Y` (or `libpypyX.Y-c`) shared
+// library required by an ELF interpreter binary.
+func versionFromELF(interpreter string) interpreterInfo {
+	f, err := elf.Open(interpreter)
+	if err != nil {
+		return interpreterInfo{}
+	}
+	defer f.Close()
+	libs, err := f.ImportedLibraries()
+	if err != nil {
+		return interpreterInfo{}
+	}
+	for _, lib := range libs {
+		if m := libpythonPattern.FindStringSubmatch(lib); m != nil {
+			return interpreterInfo{version: m[2], implementation: interpreterPrefixes[m[1]]}
+		}
+	}
+	return interpreterInfo{}
+}
+
+// versionFromLibraryLayout extracts the version from the standard library directory
+// (`<prefix>/lib/pythonX.Y` or `<prefix>/lib/pypyX.Y`) of an interpreter installed
+// as `<prefix>/bin/python`.
+func versionFromLibraryLayout(interpreter string) interpreterInfo {
+	real, err := filepath.EvalSymlinks(interpreter)
+	if err != nil {
+		return interpreterInfo{}
+	}
+	prefix := filepath.Dir(filepath.Dir(real))
+	entries, err := ioutil.ReadDir(filepath.Join(prefix, "lib"))
+	if err != nil {
+		return interpreterInfo{}
+	}
+	var found []interpreterInfo
+	for _, e := range entries {
+		if m := stdlibDirPattern.FindStringSubmatch(e.Name()); m != nil && e.IsDir() && pathExists(filepath.Join(prefix, "lib", e.Name(), "os.py")) {
+			found = append(found, interpreterInfo{version: m[2], implementation: interpreterPrefixes[m[1]]})
+		}
+	}
+	// multiple installations share this prefix, so we can't tell which is which
+	if len(found) != 1 {
+		return interpreterInfo{}
+	}
+	return found[0]
+}
+
+// versionCacheEntry records a previously-determined python version.  The entry is
+// valid only while the interpreter's inode and modification time are unchanged.
+type versionCacheEntry struct {
+	Path           string `json:"path"`
+	Inode          uint64 `json:"inode"`
+	ModTime        int64  `json:"mtime"`
+	Version        string `json:"version"`
+	Implementation string `json:"implementation,omitempty"`
+}
+
+// versionCacheFile returns the location of the cache entry for the given interpreter.
+func versionCacheFile(interpreter string) string {
+	return filepath.Join(dbgRoot, "python", "cache", fmt.Sprintf("version-%x.json", sha256.Sum256([]byte(interpreter))))
+}
+
+func newVersionCacheEntry(interpreter string, info os.FileInfo) versionCacheEntry {
+	entry := versionCacheEntry{Path: interpreter, ModTime: info.ModTime().UnixNano()}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		entry.Inode = uint64(st.Ino)
+	}
+	return entry
+}
+
+// readVersionCache returns the cached details for the interpreter, or false if not found or stale.
+func readVersionCache(interpreter string, info os.FileInfo) (interpreterInfo, bool) {
+	data, err := ioutil.ReadFile(versionCacheFile(interpreter))
+	if err != nil {
+		return interpreterInfo{}, false
+	}
+	var cached versionCacheEntry
+	if err := json.Unmarshal(data, &cached); err != nil {
+		logrus.Debugf("ignoring invalid version cache entry for %q: %v", interpreter, err)
+		return interpreterInfo{}, false
+	}
+	current := newVersionCacheEntry(interpreter, info)
+	if cached.Path != current.Path || cached.Inode != current.Inode || cached.ModTime != current.ModTime || cached.Version == "" {
+		logrus.Debugf("ignoring stale version cache entry for %q", interpreter)
+		return interpreterInfo{}, false
+	}
+	return interpreterInfo{version: cached.Version, implementation: cached.Implementation}, true
+}
+
+// writeVersionCache records the details for the interpreter.  Failures are ignored as
+// the helpers root may not be writable.
+func writeVersionCache(interpreter string, info os.FileInfo, detected interpreterInfo) {
+	entry := newVersionCacheEntry(interpreter, info)
+	entry.Version = strings.TrimSpace(detected.version)
+	entry.Implementation = detected.implementation
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	f := versionCacheFile(interpreter)
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		logrus.Debugf("unable to cache python version: %v", err)
+		return
+	}
+	if err := writeFileAtomic(f, data); err != nil {
+		logrus.Debugf("unable to cache python version: %v", err)
+	}
+}
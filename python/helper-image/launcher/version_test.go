@@ -0,0 +1,311 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeFile creates a file, and any parent directories, relative to dir.
+func writeFile(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, []byte(contents), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestVersionFromFilename(t *testing.T) {
+	dir := t.TempDir()
+	real := writeFile(t, dir, "bin/python3.11", "")
+	if err := os.Symlink(real, filepath.Join(dir, "bin", "python3")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		interpreter string
+		expected    interpreterInfo
+	}{
+		{filepath.Join(dir, "bin", "python3.11"), interpreterInfo{"3.11", "cpython"}},
+		{filepath.Join(dir, "bin", "python3"), interpreterInfo{"3.11", "cpython"}},
+		{"/nonexistent/python2.7", interpreterInfo{"2.7", "cpython"}},
+		{"/nonexistent/python3.10-dbg", interpreterInfo{"3.10", "cpython"}},
+		{"/nonexistent/python3.6m", interpreterInfo{"3.6", "cpython"}},
+		{"/nonexistent/python3", interpreterInfo{}},
+		{"/nonexistent/python", interpreterInfo{}},
+		{"/nonexistent/python3.11-config.py", interpreterInfo{"3.11", "cpython"}},
+		{"/nonexistent/python3.x", interpreterInfo{}},
+		{"/nonexistent/python3.13t", interpreterInfo{"3.13t", "cpython"}},
+		{"/nonexistent/pypy3.10", interpreterInfo{"3.10", "pypy"}},
+		{"/nonexistent/pypy3", interpreterInfo{}},
+		{"/nonexistent/graalpy3.10", interpreterInfo{"3.10", "graalpy"}},
+	}
+	for _, test := range tests {
+		t.Run(test.interpreter, func(t *testing.T) {
+			if result := versionFromFilename(test.interpreter); result != test.expected {
+				t.Errorf("expected %+v but got %+v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestVersionFromPyvenvCfg(t *testing.T) {
+	tests := []struct {
+		description string
+		cfg         string
+		lib         string // a library directory of the environment, if any
+		expected    interpreterInfo
+	}{
+		{"venv", "home = /usr/bin\ninclude-system-site-packages = false\nversion = 3.11.4\n", "python3.11", interpreterInfo{"3.11.4", ""}},
+		{"free-threaded venv", "home = /usr/bin\nversion = 3.13.1\n", "python3.13t", interpreterInfo{"3.13.1t", ""}},
+		{"virtualenv", "home = /usr/bin\nimplementation = CPython\nversion_info = 3.10.12.final.0\n", "", interpreterInfo{"3.10.12.final.0", "cpython"}},
+		{"virtualenv with pypy", "home = /opt/pypy/bin\nimplementation = PyPy\nversion_info = 3.10.13.final.0\n", "", interpreterInfo{"3.10.13.final.0", "pypy"}},
+		{"no version", "home = /usr/bin\n", "", interpreterInfo{}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "pyvenv.cfg", test.cfg)
+			if test.lib != "" {
+				writeFile(t, dir, filepath.Join("lib", test.lib, "site-packages", "README.txt"), "")
+			}
+			python := writeFile(t, dir, "bin/python", "")
+			if result := versionFromPyvenvCfg(python); result != test.expected {
+				t.Errorf("expected %+v but got %+v", test.expected, result)
+			}
+		})
+	}
+	t.Run("no pyvenv.cfg", func(t *testing.T) {
+		if result := versionFromPyvenvCfg(writeFile(t, t.TempDir(), "bin/python", "")); result.version != "" {
+			t.Errorf("expected no version but got %q", result.version)
+		}
+	})
+}
+
+func TestVersionFromELF(t *testing.T) {
+	if result := versionFromELF(writeFile(t, t.TempDir(), "python", "#!/bin/sh\n")); result.version != "" {
+		t.Errorf("non-ELF file should have no version but got %q", result.version)
+	}
+	// the test binary does not link against libpython
+	if exe, err := os.Executable(); err == nil {
+		if result := versionFromELF(exe); result.version != "" {
+			t.Errorf("test binary should have no version but got %q", result.version)
+		}
+	}
+}
+
+func TestVersionFromLibraryLayout(t *testing.T) {
+	tests := []struct {
+		description string
+		files       []string
+		expected    interpreterInfo
+	}{
+		{"stdlib", []string{"lib/python3.9/os.py"}, interpreterInfo{"3.9", "cpython"}},
+		{"stdlib with site-packages only", []string{"lib/python3.9/os.py", "lib/python3.8/site-packages/x.py"}, interpreterInfo{"3.9", "cpython"}},
+		{"multiple stdlibs", []string{"lib/python3.9/os.py", "lib/python3.8/os.py"}, interpreterInfo{}},
+		{"no stdlib", []string{"lib/python3.9/site-packages/x.py"}, interpreterInfo{}},
+		{"no lib", nil, interpreterInfo{}},
+		{"free-threaded", []string{"lib/python3.13t/os.py"}, interpreterInfo{"3.13t", "cpython"}},
+		{"pypy", []string{"lib/pypy3.10/os.py"}, interpreterInfo{"3.10", "pypy"}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range test.files {
+				writeFile(t, dir, f, "")
+			}
+			python := writeFile(t, dir, "bin/python", "")
+			if result := versionFromLibraryLayout(python); result != test.expected {
+				t.Errorf("expected %+v but got %+v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestDetectInterpreterCache(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+
+	dir := t.TempDir()
+	python := writeFile(t, dir, "python", "")
+
+	// first determined by executing, and then from the cache
+	RunCmdOut([]string{python, "-VV"}, "Python 3.8.10\n").Setup(t)
+	if v, err := detectInterpreter(context.TODO(), python, nil); err != nil || v.version != "3.8.10" {
+		t.Errorf("expected 3.8.10 but got %q (%v)", v.version, err)
+	}
+	if v, err := detectInterpreter(context.TODO(), python, nil); err != nil || v.version != "3.8.10" {
+		t.Errorf("expected cached 3.8.10 but got %q (%v)", v.version, err)
+	}
+
+	// a modified interpreter invalidates the cache
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(python, later, later); err != nil {
+		t.Fatal(err)
+	}
+	RunCmdOut([]string{python, "-VV"}, "Python 3.9.1\n[PyPy 7.3.5]\n").Setup(t)
+	if v, err := detectInterpreter(context.TODO(), python, nil); err != nil || v != (interpreterInfo{"3.9.1", "pypy"}) {
+		t.Errorf("expected pypy 3.9.1 but got %+v (%v)", v, err)
+	}
+	if v, err := detectInterpreter(context.TODO(), python, nil); err != nil || v != (interpreterInfo{"3.9.1", "pypy"}) {
+		t.Errorf("expected cached pypy 3.9.1 but got %+v (%v)", v, err)
+	}
+
+	// detected versions are cached too
+	python311 := writeFile(t, dir, "python3.11", "")
+	if v, err := detectInterpreter(context.TODO(), python311, nil); err != nil || v.version != "3.11" {
+		t.Errorf("expected 3.11 but got %q (%v)", v.version, err)
+	}
+	info, err := os.Stat(python311)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, found := readVersionCache(python311, info); !found || v != (interpreterInfo{"3.11", "cpython"}) {
+		t.Errorf("expected cached cpython 3.11 but got %+v", v)
+	}
+}
+
+func TestDetectInterpreterCacheVenvs(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+
+	// virtual environments that link to the same binary are cached separately
+	python := writeFile(t, t.TempDir(), "python", "")
+	for _, v := range []string{"3.11.4", "3.11.9", "3.11.4"} {
+		venv := t.TempDir()
+		writeFile(t, venv, "pyvenv.cfg", "home = /usr/bin\nversion = "+v+"\n")
+		if err := os.MkdirAll(filepath.Join(venv, "bin"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(python, filepath.Join(venv, "bin", "python")); err != nil {
+			t.Fatal(err)
+		}
+		if result, err := detectInterpreter(context.TODO(), filepath.Join(venv, "bin", "python"), nil); err != nil || result.version != v {
+			t.Errorf("expected %s but got %q (%v)", v, result.version, err)
+		}
+	}
+}
+
+func TestDetectInterpreterVenvImplementation(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+
+	// venv does not record the implementation, which is then found from other sources
+	venv := t.TempDir()
+	writeFile(t, venv, "pyvenv.cfg", "home = /opt/pypy/bin\nversion = 3.10.13\n")
+	pypy := writeFile(t, venv, "bin/pypy3", "")
+	if v, err := detectInterpreter(context.TODO(), pypy, nil); err != nil || v != (interpreterInfo{"3.10.13", "pypy"}) {
+		t.Errorf("expected pypy 3.10.13 but got %+v (%v)", v, err)
+	}
+}
+
+func TestParsePythonVersion(t *testing.T) {
+	tests := []struct {
+		version   string
+		shouldErr bool
+		expected  pythonVersion
+	}{
+		{"2.7.18", false, pythonVersion{major: 2, minor: 7, patch: 18}},
+		{"3.9", false, pythonVersion{major: 3, minor: 9}},
+		{" 3.11.4\n", false, pythonVersion{major: 3, minor: 11, patch: 4}},
+		{"3.13.0rc2", false, pythonVersion{major: 3, minor: 13, preRelease: "rc2"}},
+		{"3.14.0a1", false, pythonVersion{major: 3, minor: 14, preRelease: "a1"}},
+		{"3.12.0b4", false, pythonVersion{major: 3, minor: 12, preRelease: "b4"}},
+		{"3.12.1+", false, pythonVersion{major: 3, minor: 12, patch: 1}},
+		{"3.13t", false, pythonVersion{major: 3, minor: 13, abiFlags: "t"}},
+		{"3.13.0t", false, pythonVersion{major: 3, minor: 13, abiFlags: "t"}},
+		{"3.13.1 experimental free-threading build", false, pythonVersion{major: 3, minor: 13, patch: 1, abiFlags: "t"}},
+		{"3.11.4.final.0", false, pythonVersion{major: 3, minor: 11, patch: 4}},
+		{"3.12.0.candidate.1", false, pythonVersion{major: 3, minor: 12, preRelease: "rc1"}},
+		{"3", true, pythonVersion{}},
+		{"", true, pythonVersion{}},
+		{"three.eleven", true, pythonVersion{}},
+		{"3.x", true, pythonVersion{}},
+	}
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			result, err := parsePythonVersion(test.version)
+			if test.shouldErr {
+				if err == nil {
+					t.Errorf("expected an error but got %v", result)
+				}
+			} else if err != nil {
+				t.Error("unexpected error:", err)
+			} else if diff := cmp.Diff(test.expected, result, cmp.AllowUnexported(test.expected)); diff != "" {
+				t.Errorf("%T differ (-got, +want): %s", result, diff)
+			}
+		})
+	}
+}
+
+func TestPythonVersionLibDir(t *testing.T) {
+	if d := (pythonVersion{major: 3, minor: 11, patch: 2}).libDir(); d != "python3.11" {
+		t.Errorf("expected python3.11 but got %q", d)
+	}
+	if d := (pythonVersion{major: 3, minor: 13, abiFlags: "t"}).libDir(); d != "python3.13t" {
+		t.Errorf("expected python3.13t but got %q", d)
+	}
+}
+
+func TestPythonVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b     pythonVersion
+		expected int
+	}{
+		{pythonVersion{major: 3, minor: 8, patch: 10}, pythonVersion{major: 3, minor: 8}, 0},
+		{pythonVersion{major: 3, minor: 9}, pythonVersion{major: 3, minor: 8}, 1},
+		{pythonVersion{major: 3, minor: 10}, pythonVersion{major: 3, minor: 9}, 1},
+		{pythonVersion{major: 2, minor: 7}, pythonVersion{major: 3, minor: 5}, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.a.String()+"/"+test.b.String(), func(t *testing.T) {
+			if result := test.a.compare(test.b); result != test.expected {
+				t.Errorf("expected %d but got %d", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestWriteVersionCacheReadOnly(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	dbgRoot = filepath.Join(writeFile(t, t.TempDir(), "file", ""), "not-a-dir")
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+
+	python := writeFile(t, t.TempDir(), "python3.7", "")
+	info, err := os.Stat(python)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeVersionCache(python, info, interpreterInfo{"3.7", "cpython"}) // should not panic or fail
+	if v, found := readVersionCache(python, info); found {
+		t.Errorf("expected no cached version but got %+v", v)
+	}
+}
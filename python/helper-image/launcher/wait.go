@@ -0,0 +1,148 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// What the app does when no debugger attaches within the wait timeout.
+const (
+	// WaitTimeoutContinue has the app continue to run without a debugger
+	WaitTimeoutContinue = "continue"
+	// WaitTimeoutExit has the app exit with status 1
+	WaitTimeoutExit = "exit"
+)
+
+// waitBootstrap defines `_skaffold_wait_for_client(wait, cancel)`, which calls the backend's
+// wait function to wait for a debugger to attach.  With a timeout, the wait is made in
+// another thread that is abandoned, and cancelled if possible, once the timeout expires;
+// the app then continues or exits.
+const waitBootstrap = `_skaffold_wait_timeout = {timeout}
+_skaffold_wait_exit = {exit}
+
+def _skaffold_wait_for_client(wait, cancel=None):
+    if not _skaffold_wait_timeout:
+        wait()
+        return
+    import threading
+    waiter = threading.Thread(target=wait)
+    waiter.daemon = True
+    waiter.start()
+    waiter.join(_skaffold_wait_timeout)
+    if not waiter.is_alive():
+        return
+    if cancel:
+        cancel()
+    if _skaffold_wait_exit:
+        sys.stderr.write('skaffold: no debugger attached to process %d within %d seconds: exiting\n' % (os.getpid(), _skaffold_wait_timeout))
+        sys.stderr.flush()
+        os._exit(1)
+    sys.stderr.write('skaffold: no debugger attached to process %d within %d seconds: continuing\n' % (os.getpid(), _skaffold_wait_timeout))
+
+`
+
+// waitLaunchBootstrap follows waitBootstrap in a launch script that waits for a debugger to
+// attach to the backend started on the command-line before running the program.  pydevd
+// waits until the debugger has sent its configuration and has the program run.
+const waitLaunchBootstrap = `_skaffold_mode = '{mode}'
+if _skaffold_mode == 'debugpy':
+    import debugpy
+    _skaffold_wait_for_client(debugpy.wait_for_client, debugpy.wait_for_client.cancel)
+elif _skaffold_mode == 'ptvsd':
+    import ptvsd
+    _skaffold_wait_for_client(ptvsd.wait_for_attach)
+else:
+    import threading
+    try:
+        from _pydevd_bundle.pydevd_constants import get_global_debugger
+    except ImportError:
+        from pydevd import get_global_debugger
+    _skaffold_cancelled = threading.Event()
+
+    def _skaffold_ready_to_run():
+        while not _skaffold_cancelled.wait(0.1):
+            debugger = get_global_debugger()
+            if debugger is not None and debugger.ready_to_run:
+                return
+
+    _skaffold_wait_for_client(_skaffold_ready_to_run, _skaffold_cancelled.set)
+
+`
+
+// validateWaitTimeoutAction ensures the provided action is a supported action.
+func validateWaitTimeoutAction(action string) error {
+	switch action {
+	case WaitTimeoutContinue, WaitTimeoutExit:
+		return nil
+	default:
+		return fmt.Errorf("unknown wait timeout action %q; expecting one of %v", action, []string{WaitTimeoutContinue, WaitTimeoutExit})
+	}
+}
+
+// validateWaitTimeout ensures that a wait timeout is not combined with connecting to the
+// debugger, as the backend then does not wait for the debugger.
+func validateWaitTimeout(timeout uint, connect string) error {
+	if timeout > 0 && connect != "" {
+		return fmt.Errorf("a wait timeout cannot be used when connecting to the debugger at %q", connect)
+	}
+	return nil
+}
+
+// timedWait returns true if the app is to wait for a debugger for at most the wait timeout.
+// A backend that connects to the debugger does not wait.
+func (pc *pythonContext) timedWait() bool {
+	return pc.wait && pc.waitTimeout > 0 && pc.connect == ""
+}
+
+// waitBootstrapScript returns the definition of `_skaffold_wait_for_client()`.
+func (pc *pythonContext) waitBootstrapScript() string {
+	timeout := uint(0)
+	if pc.timedWait() {
+		timeout = pc.waitTimeout
+	}
+	return strings.NewReplacer(
+		"{timeout}", strconv.Itoa(int(timeout)),
+		"{exit}", pythonBool(pc.waitTimeoutAction == WaitTimeoutExit)).Replace(waitBootstrap)
+}
+
+// writeWaitScript writes out a launch script that waits for a debugger, for at most the wait
+// timeout, and then runs the program described by the command-line.
+func (pc *pythonContext) writeWaitScript(cl pythonCommandLine) (string, error) {
+	program, err := launchScript(cl)
+	if err != nil {
+		return "", err
+	}
+	bootstrap := "import os\nimport sys\n" + pc.waitBootstrapScript() + strings.Replace(waitLaunchBootstrap, "{mode}", pc.debugMode, 1)
+	f, err := pc.writeScript("wait*", "skaffold_wait.py", bootstrap+program)
+	if err != nil {
+		return "", err
+	}
+	logrus.Debugf("wrote wait launch script %q for %q", f, cl.commandLine())
+	return f, nil
+}
+
+// logWaitTimeout describes what happens if no debugger attaches in time.
+func (pc *pythonContext) logWaitTimeout() {
+	if pc.timedWait() {
+		logrus.Infof("waiting up to %d seconds for a debugger to attach, then the app will %s", pc.waitTimeout, pc.waitTimeoutAction)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2021 The Skaffold Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateWaitTimeoutAction(t *testing.T) {
+	for _, action := range []string{WaitTimeoutContinue, WaitTimeoutExit} {
+		if err := validateWaitTimeoutAction(action); err != nil {
+			t.Errorf("%q should be valid: %v", action, err)
+		}
+	}
+	if err := validateWaitTimeoutAction("abort"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestValidateWaitTimeout(t *testing.T) {
+	if err := validateWaitTimeout(30, ""); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	if err := validateWaitTimeout(0, "ide:5678"); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	if err := validateWaitTimeout(30, "ide:5678"); err == nil {
+		t.Error("expected an error with --connect")
+	}
+}
+
+func TestUpdateCommandLineWithWaitTimeout(t *testing.T) {
+	oldDbgRoot := dbgRoot
+	dbgRoot = t.TempDir()
+	t.Cleanup(func() { dbgRoot = oldDbgRoot })
+	script := filepath.Join(dbgRoot, "python", "tmp", "wait*", "skaffold_wait.py")
+
+	tests := []struct {
+		description string
+		pc          pythonContext
+		// expected has the wait script in place of the element ""
+		expected []string
+		contains []string
+	}{
+		{
+			description: "debugpy",
+			pc:          pythonContext{debugMode: ModeDebugpy, port: 5678, wait: true, waitTimeout: 30, waitTimeoutAction: WaitTimeoutContinue, args: []string{"python", "-m", "gunicorn", "app:app"}},
+			expected:    []string{"python", "-m", "debugpy", "--listen", "5678", "", "app:app"},
+			contains:    []string{"_skaffold_wait_timeout = 30", "_skaffold_wait_exit = False", "_skaffold_mode = 'debugpy'", "runpy.run_module('gunicorn'"},
+		},
+		{
+			description: "ptvsd",
+			pc:          pythonContext{debugMode: ModePtvsd, port: 5678, wait: true, waitTimeout: 30, waitTimeoutAction: WaitTimeoutExit, args: []string{"python", "app.py"}},
+			expected:    []string{"python", "-m", "ptvsd", "--host", "localhost", "--port", "5678", ""},
+			contains:    []string{"_skaffold_wait_exit = True", "_skaffold_mode = 'ptvsd'"},
+		},
+		{
+			description: "pydevd",
+			pc:          pythonContext{debugMode: ModePydevd, port: 5678, wait: true, waitTimeout: 5, waitTimeoutAction: WaitTimeoutContinue, args: []string{"python", "-u", "app.py", "arg"}},
+			expected:    []string{"python", "-u", "-m", "pydevd", "--server", "--port", "5678", "--continue", "--file", "", "arg"},
+			contains:    []string{"_skaffold_wait_timeout = 5", "_skaffold_mode = 'pydevd'"},
+		},
+		{
+			description: "pydevd-pycharm",
+			pc:          pythonContext{debugMode: ModePydevdPycharm, port: 5678, wait: true, waitTimeout: 5, waitTimeoutAction: WaitTimeoutExit, args: []string{"python", "-c", "import app"}},
+			expected:    []string{"python", "-m", "pydevd", "--server", "--port", "5678", "--continue", "--file", ""},
+			contains:    []string{"_skaffold_mode = 'pydevd-pycharm'", "sys.argv[0] = '-c'"},
+		},
+		{
+			description: "no timeout waits indefinitely",
+			pc:          pythonContext{debugMode: ModeDebugpy, port: 5678, wait: true, waitTimeoutAction: WaitTimeoutContinue, args: []string{"python", "app.py"}},
+			expected:    []string{"python", "-m", "debugpy", "--listen", "5678", "--wait-for-client", "app.py"},
+		},
+		{
+			description: "timeout without wait",
+			pc:          pythonContext{debugMode: ModePydevd, port: 5678, waitTimeout: 30, waitTimeoutAction: WaitTimeoutContinue, args: []string{"python", "app.py"}},
+			expected:    []string{"python", "-m", "pydevd", "--server", "--port", "5678", "--continue", "--file", "app.py"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			pc := test.pc
+			if err := pc.updateCommandLine(context.TODO()); err != nil {
+				t.Fatal(err)
+			}
+			expected := append([]string(nil), test.expected...)
+			for i, arg := range expected {
+				if arg == "" && i < len(pc.args) && fileMatch(t, script, pc.args[i]) {
+					expected[i] = pc.args[i]
+				}
+			}
+			if diff := cmp.Diff(expected, pc.args); diff != "" {
+				t.Errorf("args differ (-got, +want): %s", diff)
+			}
+			if len(test.contains) == 0 {
+				if len(pc.scripts) != 0 {
+					t.Errorf("unexpected scripts: %q", pc.scripts)
+				}
+				return
+			}
+			if len(pc.scripts) != 1 {
+				t.Fatalf("expected a wait script but got %q", pc.scripts)
+			}
+			contents, err := ioutil.ReadFile(filepath.Join(pc.scripts[0], "skaffold_wait.py"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range test.contains {
+				if !strings.Contains(string(contents), s) {
+					t.Errorf("wait script should contain %q", s)
+				}
+			}
+			pc.removeScripts()
+		})
+	}
+}